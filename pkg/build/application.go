@@ -2,18 +2,181 @@ package build
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/itura/fun/pkg/fun"
 )
 
+// RuntimeArg is a value set for an application. Configured values have exactly one source:
+// a literal Value, an EnvValue or SecretValue read in CI, a StepOutput of a previous step,
+// or the app image of an ArtifactImage built by the pipeline.
+// Once resolved for a CI system, Value holds the rendered reference.
 type RuntimeArg struct {
-	Key   string
-	Value string
+	Key           string
+	Value         string
+	EnvValue      string `yaml:"envValue"`
+	SecretValue   string `yaml:"secretValue"`
+	StepOutput    string `yaml:"stepOutput"`
+	ArtifactImage string `yaml:"artifactImage"`
+	// Flag is the Helm flag used to set the value, --set when empty
+	Flag string `yaml:"-"`
+}
+
+const (
+	helmSet       = "--set"
+	helmSetString = "--set-string"
+	helmSetFile   = "--set-file"
+)
+
+func (r RuntimeArg) HelmFlag() string {
+	if r.Flag == "" {
+		return helmSet
+	}
+	return r.Flag
 }
 
 func (r RuntimeArg) EnvKey() string {
 	return strings.ReplaceAll(r.Key, ".", "_")
 }
 
+// IsEnv is true when the value is passed to commands in an environment variable named by EnvKey.
+// Literal values and artifact images are resolved by the command itself.
+func (r RuntimeArg) IsEnv() bool {
+	return !r.IsLiteral() && r.ArtifactImage == ""
+}
+
+// IsLiteral is true when the value is set in the config, rather than read from a source.
+func (r RuntimeArg) IsLiteral() bool {
+	return r.EnvValue == "" && r.SecretValue == "" && r.StepOutput == "" && r.ArtifactImage == ""
+}
+
+func (r RuntimeArg) sources() []string {
+	var sources []string
+	for source, value := range map[string]string{
+		"value":         r.Value,
+		"envValue":      r.EnvValue,
+		"secretValue":   r.SecretValue,
+		"stepOutput":    r.StepOutput,
+		"artifactImage": r.ArtifactImage,
+	} {
+		if value != "" {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func (r RuntimeArg) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if r.Key == "" {
+		errs = errs.Put("key", eMissingRequiredField)
+	}
+	switch sources := r.sources(); len(sources) {
+	case 0:
+		errs = errs.Put("value", fmt.Errorf("one of value, envValue, secretValue, stepOutput or artifactImage is required"))
+	case 1:
+	default:
+		errs = errs.Put("value", fmt.Errorf("only one of %s may be set", strings.Join(sources, ", ")))
+	}
+	if r.StepOutput != "" {
+		if _, _, err := r.stepOutput(); err != nil {
+			errs = errs.Put("stepOutput", err)
+		}
+	}
+	return errs
+}
+
+func (r RuntimeArg) stepOutput() (string, string, error) {
+	step, output, found := strings.Cut(r.StepOutput, ".")
+	if !found || step == "" || output == "" {
+		return "", "", fmt.Errorf("'%s' must be of the form <step id>.<output>", r.StepOutput)
+	}
+	return step, output, nil
+}
+
+// validateStepOutputs checks step outputs reference a step with that id in the deploy job.
+func validateStepOutputs(errs ValidationErrors, values []RuntimeArg, steps []GitHubActionsStep) ValidationErrors {
+	ids := map[string]bool{}
+	for _, step := range steps {
+		if step.Id != "" {
+			ids[step.Id] = true
+		}
+	}
+	for _, value := range values {
+		if value.StepOutput == "" {
+			continue
+		}
+		if step, _, err := value.stepOutput(); err == nil && !ids[step] {
+			errs = errs.Put("values", fmt.Errorf("stepOutput '%s' references step '%s', which isn't in the deploy job", value.StepOutput, step))
+		}
+	}
+	return errs
+}
+
+// Interpolate replaces {{ sha }} and {{ environment }} in a literal value.
+func (r RuntimeArg) Interpolate(sha string, environment string) RuntimeArg {
+	r.Value = strings.NewReplacer(
+		"{{ sha }}", sha,
+		"{{ environment }}", environment,
+	).Replace(r.Value)
+	return r
+}
+
+// Resolve interpolates literal values for commands run in-process, and renders other sources as GitHub Actions
+// expressions for the env they're read from.
+func (r RuntimeArg) Resolve(sha string, environment string) RuntimeArg {
+	if r.IsLiteral() {
+		return r.Interpolate(sha, environment)
+	}
+	return r.ResolveGitHub(environment)
+}
+
+// ResolveGitHub renders the value's source as a GitHub Actions expression.
+func (r RuntimeArg) ResolveGitHub(environment string) RuntimeArg {
+	switch {
+	case r.EnvValue != "":
+		r.Value = formatEnvValue(r.EnvValue)
+	case r.SecretValue != "":
+		r.Value = formatSecretValue(r.SecretValue)
+	case r.StepOutput != "":
+		step, output, _ := r.stepOutput()
+		r.Value = fmt.Sprintf("${{ steps.%s.outputs.%s }}", step, output)
+	case r.ArtifactImage != "":
+	default:
+		return r.Interpolate("${{ github.sha }}", environment)
+	}
+	return r
+}
+
+// ResolveShell renders the value's source as a shell word, for CI systems which pass values in the job script.
+// Env and secret values are read from variables, and shaVariable is the variable holding the current sha.
+func (r RuntimeArg) ResolveShell(environment string, shaVariable string) RuntimeArg {
+	switch {
+	case r.EnvValue != "":
+		r.Value = fmt.Sprintf(`"${%s}"`, r.EnvValue)
+	case r.SecretValue != "":
+		r.Value = fmt.Sprintf(`"${%s}"`, formatVariableName(r.SecretValue))
+	case r.ArtifactImage != "":
+	default:
+		builder := &strings.Builder{}
+		for i, part := range strings.Split(r.Interpolate("{{ sha }}", environment).Value, "{{ sha }}") {
+			if i > 0 {
+				builder.WriteString(fmt.Sprintf(`"${%s}"`, shaVariable))
+			}
+			if part != "" {
+				builder.WriteString(shellQuote(part))
+			}
+		}
+		r.Value = builder.String()
+		if r.Value == "" {
+			r.Value = "''"
+		}
+	}
+	return r
+}
+
 type Application struct {
 	Id                string
 	Path              string
@@ -25,6 +188,31 @@ type Application struct {
 	Type              ApplicationType
 	hasChanged        bool
 	Steps             []GitHubActionsStep
+	// Verify checks the deployment without applying it
+	Verify      bool
+	Environment string
+	ValuesFiles []string
+	// RenderValues passes values to Helm in a generated values file instead of on the command line
+	RenderValues bool
+	Helm         HelmConfig
+	Terraform    TerraformOptions
+	Manifests    ManifestOptions
+	// PostDeploy checks the application once it's deployed
+	PostDeploy VerifyOptions
+	// Artifacts are the ids of upstream artifacts, whose images are set in kustomize and kubectl manifests
+	Artifacts []string
+	// ImageValues passes the repository, tag and digest of each artifact image to Helm and Terraform
+	ImageValues bool
+	// RequireSignedImages verifies the signature of each artifact image before deploying, with the artifact's Signing
+	RequireSignedImages bool
+	Signing             map[string]SignOptions
+	// readsRegistry is the ApplicationConfig's readsRegistry
+	readsRegistry bool
+	// Phase limits a Terraform deploy to either planning or applying a saved plan
+	Phase string
+	// Drift checks Terraform plans are empty instead of deploying. Other applications are verified
+	Drift        bool
+	AllowDestroy bool
 }
 
 func CreateApplications(
@@ -49,11 +237,13 @@ func CreateApplications(
 		}
 
 		var runTimeArgs []RuntimeArg
-		for _, arg := range spec.Values {
-			runTimeArgs = append(runTimeArgs, RuntimeArg{
-				Key:   arg.Key,
-				Value: arg.Value,
-			})
+		for _, arg := range spec.RuntimeValues() {
+			runTimeArgs = append(runTimeArgs, arg.Resolve(args.CurrentSha, args.Environment))
+		}
+
+		var valuesFiles []string
+		for _, path := range spec.ValuesFiles {
+			valuesFiles = append(valuesFiles, RuntimeArg{Value: path}.Interpolate(args.CurrentSha, args.Environment).Value)
 		}
 
 		setupSteps := []GitHubActionsStep{
@@ -65,18 +255,40 @@ func CreateApplications(
 		runTimeArgs = append(runTimeArgs, secretProviders.ResolveRuntimeArgs(spec.Secrets)...)
 		setupSteps = append(setupSteps, secretProviders.ResolveSetupSteps(spec.Secrets)...)
 
-		applications[spec.Id] = Application{
-			Type:              spec.Type,
-			Id:                spec.Id,
-			Path:              spec.Path,
-			Repository:        artifactRepository,
-			CurrentSha:        args.CurrentSha,
-			Namespace:         spec.Namespace,
-			RuntimeArgs:       runTimeArgs,
-			KubernetesCluster: config.Resources.KubernetesCluster,
-			hasChanged:        cd.HasChanged(dependencies.GetAllPaths(spec.Id)...),
-			Steps:             setupSteps,
+		application := Application{
+			Type:                spec.Type,
+			Id:                  spec.Id,
+			Path:                spec.Path,
+			Repository:          artifactRepository,
+			CurrentSha:          args.CurrentSha,
+			Namespace:           spec.Namespace,
+			RuntimeArgs:         runTimeArgs,
+			KubernetesCluster:   config.Resources.KubernetesCluster,
+			hasChanged:          dependencies.HasChanged(cd, spec.Id),
+			Steps:               setupSteps,
+			Verify:              args.Verify,
+			Environment:         args.Environment,
+			ValuesFiles:         valuesFiles,
+			RenderValues:        spec.RenderValues,
+			Helm:                spec.Helm,
+			Terraform:           spec.Terraform.ForEnvironment(args.Environment),
+			Manifests:           spec.Manifests,
+			PostDeploy:          spec.Verify,
+			Artifacts:           dockerArtifacts(config, spec.Artifacts),
+			ImageValues:         spec.ImageValues,
+			RequireSignedImages: spec.RequireSignedImages,
+			Signing:             artifactSigning(config),
+			readsRegistry:       spec.readsRegistry(),
+			Phase:               args.Phase,
+			Drift:               args.Drift,
+			AllowDestroy:        args.AllowDestroy,
 		}
+		applicationConfigErrors = validateStepOutputs(applicationConfigErrors, spec.RuntimeValues(), application.GetSteps("", ""))
+		if applicationConfigErrors.IsPresent() {
+			allValidationErrors = allValidationErrors.PutChild(applicationConfigErrors)
+			continue
+		}
+		applications[spec.Id] = application
 	}
 
 	if allValidationErrors.IsPresent() {
@@ -85,17 +297,135 @@ func CreateApplications(
 	return applications, nil
 }
 
+// artifactSigning maps the ids of signed artifacts to how they're signed.
+func artifactSigning(config PipelineConfigRaw) map[string]SignOptions {
+	var signing map[string]SignOptions
+	for _, artifact := range config.Artifacts {
+		if !artifact.Docker.Sign.IsEmpty() {
+			if signing == nil {
+				signing = map[string]SignOptions{}
+			}
+			signing[artifact.Id] = artifact.Docker.Sign
+		}
+	}
+	return signing
+}
+
+// dockerArtifacts filters artifact ids to those of Docker images.
+func dockerArtifacts(config PipelineConfigRaw, ids []string) []string {
+	types := map[string]ArtifactType{}
+	for _, artifact := range config.Artifacts {
+		types[artifact.Id] = artifact.Type
+	}
+
+	var results []string
+	for _, id := range ids {
+		if artifactType, present := types[id]; present && artifactType == artifactTypeApp {
+			results = append(results, id)
+		}
+	}
+	return results
+}
+
 func (a Application) PrepareBuild() Build {
 	switch a.Type {
 	case applicationTypeTerraform:
 		return NewTerraform(a)
 	case applicationTypeHelm:
 		return NewHelm(a)
+	case applicationTypeKustomize, applicationTypeKubectl:
+		return NewManifests(a)
 	default:
 		return NullBuild{}
 	}
 }
 
+// runtimeValue is a literal value, or references the environment variable holding arg, or the image of an artifact.
+func (a Application) runtimeValue(arg RuntimeArg) string {
+	if arg.IsLiteral() {
+		return arg.Value
+	}
+	if arg.IsEnv() {
+		return fmt.Sprintf("$%s", arg.EnvKey())
+	}
+	return Artifact{Id: arg.ArtifactImage, Repository: a.Repository}.AppImageName(a.CurrentSha)
+}
+
+// expandedValue is the runtimeValue of arg, for commands which expand env references in it.
+// Literal values are escaped, so that they're passed as they are.
+func (a Application) expandedValue(arg RuntimeArg) string {
+	if arg.IsLiteral() {
+		return escapeDollars(arg.Value)
+	}
+	return a.runtimeValue(arg)
+}
+
+// ArtifactImages are the app images the application deploys at its current sha, from its artifacts and artifact image values.
+func (a Application) ArtifactImages() []string {
+	var images []string
+	for _, id := range a.artifactImageIds() {
+		images = append(images, Artifact{Id: id, Repository: a.Repository}.AppImageName(a.CurrentSha))
+	}
+	return images
+}
+
+func (a Application) artifactImageIds() []string {
+	var ids []string
+	for _, id := range a.Artifacts {
+		if !fun.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return append(ids, a.valueImageIds(ids)...)
+}
+
+// valueImageIds are the ids of artifacts referenced by artifact image values, besides those in exclude.
+func (a Application) valueImageIds(exclude []string) []string {
+	var ids []string
+	for _, arg := range a.RuntimeArgs {
+		if arg.ArtifactImage != "" && !fun.Contains(exclude, arg.ArtifactImage) && !fun.Contains(ids, arg.ArtifactImage) {
+			ids = append(ids, arg.ArtifactImage)
+		}
+	}
+	return ids
+}
+
+// ImageValue describes an artifact's app image, as passed to applications with imageValues.
+type ImageValue struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+}
+
+// imageValues describe each artifact image the application deploys, keyed by artifact id.
+// Digests reference the variables resolveDigests sets.
+func (a Application) imageValues() map[string]ImageValue {
+	values := map[string]ImageValue{}
+	for _, id := range a.artifactImageIds() {
+		values[id] = ImageValue{
+			Repository: Artifact{Id: id, Repository: a.Repository}.AppImageBase(),
+			Tag:        a.CurrentSha,
+			Digest:     "$" + digestVar(id),
+		}
+	}
+	return values
+}
+
+// pinnedImage references an artifact's app image by the digest resolveDigests reads.
+func (a Application) pinnedImage(id string) string {
+	return fmt.Sprintf("%s@$%s", Artifact{Id: id, Repository: a.Repository}.AppImageBase(), digestVar(id))
+}
+
+// resolveDigests reads the digest the commit tag of each artifact's image points at, into the variable named by digestVar.
+func (a Application) resolveDigests(ids []string) []Command {
+	var commands []Command
+	for _, id := range ids {
+		image := Artifact{Id: id, Repository: a.Repository}.AppImageName(a.CurrentSha)
+		commands = append(commands, NewCommand("oras", "resolve", image).SetVars(DigestVars{Name: digestVar(id)}))
+	}
+	return commands
+}
+
 func (a Application) JobId() string {
 	return fmt.Sprintf("deploy-%s", a.Id)
 }
@@ -108,6 +438,16 @@ func (a Application) AddRuntimeArg(key, value string) Application {
 	return a
 }
 
+// AddSecretArg adds a value read from a secret, so that it's redacted from plans.
+func (a Application) AddSecretArg(key, secretName, value string) Application {
+	a.RuntimeArgs = append(a.RuntimeArgs, RuntimeArg{
+		Key:         key,
+		Value:       value,
+		SecretValue: secretName,
+	})
+	return a
+}
+
 func (a Application) SetNamespace(namespace string) Application {
 	a.Namespace = namespace
 	return a
@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-func (a Application) ToGitHubActionsJob(cmd string, configPath string, dependencies Dependencies) GitHubActionsJob {
+func (a Application) ToGitHubActionsJob(cmd string, configPath string, dependencies Dependencies, deployArgs ...string) GitHubActionsJob {
 	return GitHubActionsJob{
 		Name:   "Deploy " + a.Id,
 		RunsOn: "ubuntu-latest",
@@ -14,7 +14,7 @@ func (a Application) ToGitHubActionsJob(cmd string, configPath string, dependenc
 			"contents": "read",
 		},
 		Needs: dependencies.GetUpstreamJobIds(a.Id),
-		Steps: a.GetSteps(cmd, configPath),
+		Steps: a.GetSteps(cmd, configPath, deployArgs...),
 	}
 }
 
@@ -31,14 +31,24 @@ func GetGitHubActionsJob(id string, name string, steps []GitHubActionsStep, depe
 	}
 }
 
-func (a Application) GetSteps(cmd string, configPath string) []GitHubActionsStep {
+func (a Application) GetSteps(cmd string, configPath string, deployArgs ...string) []GitHubActionsStep {
 	if a.Type == applicationTypeHelm {
-		a.Steps = append(a.Steps, GetHelmSteps(a.KubernetesCluster)...)
+		a.Steps = append(a.Steps, GetHelmSteps(a.KubernetesCluster, a.Helm.ClientVersion())...)
 	} else if a.Type == applicationTypeTerraform {
-		a.Steps = append(a.Steps, GetTerraformSteps()...)
+		a.Steps = append(a.Steps, GetTerraformSteps(a.Terraform.ClientVersion())...)
+	} else if a.Type.HasManifests() {
+		a.Steps = append(a.Steps, GetKustomizeSteps(a.KubernetesCluster, a.Manifests.ClientVersion())...)
+	}
+	if a.readsRegistry {
+		host, _, _ := strings.Cut(a.Repository, "/")
+		a.Steps = append(a.Steps, GetConfigureDockerSteps(host)...)
+		a.Steps = append(a.Steps, GetSetupOrasStep())
+	}
+	if a.RequireSignedImages {
+		a.Steps = append(a.Steps, GetSetupCosignStep())
 	}
 
-	deployStep := GetDeployStep(a.Id, a.RuntimeArgs, GetDeployRunCommand(a.Id, cmd, configPath))
+	deployStep := GetDeployStep(a.Id, a.RuntimeArgs, GetDeployRunCommand(a.Id, cmd, configPath, deployArgs...))
 
 	a.Steps = append(a.Steps, deployStep)
 
@@ -56,17 +66,17 @@ func GetSetupGkeStep(cluster ClusterConfig) GitHubActionsStep {
 	}
 }
 
-func GetSetupHelmStep() GitHubActionsStep {
+func GetSetupHelmStep(version string) GitHubActionsStep {
 	return GitHubActionsStep{
 		Name: "Setup Helm",
 		Uses: "azure/setup-helm@v3",
 		With: map[string]interface{}{
-			"version": "v3.10.2",
+			"version": version,
 		},
 	}
 }
 
-func GetHelmSteps(cluster ClusterConfig) []GitHubActionsStep {
+func GetHelmSteps(cluster ClusterConfig, version string) []GitHubActionsStep {
 	gkeAuthStep := GitHubActionsStep{
 		Name: "Authenticate to GKE Cluster",
 		Uses: "google-github-actions/get-gke-credentials@v1",
@@ -76,62 +86,72 @@ func GetHelmSteps(cluster ClusterConfig) []GitHubActionsStep {
 		},
 	}
 
-	setupHelmStep := GitHubActionsStep{
-		Name: "Setup Helm",
-		Uses: "azure/setup-helm@v3",
-		With: map[string]interface{}{
-			"version": "v3.10.2",
-		},
-	}
-
 	return []GitHubActionsStep{
 		gkeAuthStep,
-		setupHelmStep,
+		GetSetupHelmStep(version),
 	}
 }
 
-func GetTerraformSteps() []GitHubActionsStep {
+// GetKustomizeSteps sets up kustomize, which edits images, alongside the kubectl preinstalled on runners.
+func GetKustomizeSteps(cluster ClusterConfig, version string) []GitHubActionsStep {
 	return []GitHubActionsStep{
-		{
-			Name: "Setup Terraform",
-			Uses: "hashicorp/setup-terraform@v2",
-			With: map[string]interface{}{
-				"terraform_version": "1.3.6",
-			},
+		GetSetupGkeStep(cluster),
+		GetSetupKustomizeStep(version),
+	}
+}
+
+func GetSetupKustomizeStep(version string) GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup Kustomize",
+		Uses: "imranismail/setup-kustomize@v2",
+		With: map[string]interface{}{
+			"kustomize-version": version,
 		},
 	}
 }
 
-func GetSetupTerraformStep() GitHubActionsStep {
+func GetTerraformSteps(version string) []GitHubActionsStep {
+	return []GitHubActionsStep{
+		GetSetupTerraformStep(version),
+	}
+}
+
+func GetSetupTerraformStep(version string) GitHubActionsStep {
 	return GitHubActionsStep{
 		Name: "Setup Terraform",
 		Uses: "hashicorp/setup-terraform@v2",
 		With: map[string]interface{}{
-			"terraform_version": "1.3.6",
+			"terraform_version": version,
 		},
 	}
 }
 
 func GetDeployStep(applicationId string, runtimeArgs []RuntimeArg, runCommand string) GitHubActionsStep {
-	var envMap map[string]string
-	if len(runtimeArgs) > 0 {
-		envMap = map[string]string{}
-		for _, arg := range runtimeArgs {
-			envMap[arg.EnvKey()] = arg.Value
-		}
-	}
-
 	return GitHubActionsStep{
 		Name: "Deploy " + applicationId,
-		Env:  envMap,
+		Env:  runtimeArgEnv(nil, runtimeArgs),
 		Run:  runCommand,
 	}
 }
 
-func GetDeployRunCommand(applicationId string, cmd string, configPath string) string {
-	return strings.Join([]string{
+// runtimeArgEnv adds the env holding each runtime arg, keeping the map nil when there are none.
+func runtimeArgEnv(envMap map[string]string, runtimeArgs []RuntimeArg) map[string]string {
+	for _, arg := range runtimeArgs {
+		if !arg.IsEnv() {
+			continue
+		}
+		if envMap == nil {
+			envMap = map[string]string{}
+		}
+		envMap[arg.EnvKey()] = arg.Value
+	}
+	return envMap
+}
+
+func GetDeployRunCommand(applicationId string, cmd string, configPath string, extraArgs ...string) string {
+	return strings.Join(append([]string{
 		fmt.Sprintf("go run %s deploy-application %s", cmd, applicationId),
 		"--config " + configPath,
 		"--current-sha $GITHUB_SHA",
-	}, " \\\n  ")
+	}, extraArgs...), " \\\n  ")
 }
@@ -0,0 +1,550 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRuntimeArgs(t *testing.T) {
+	cases := []struct {
+		name   string
+		arg    RuntimeArg
+		github string
+		shell  string
+	}{
+		{
+			name:   "Literal",
+			arg:    RuntimeArg{Key: "app.name", Value: "cool-api"},
+			github: "cool-api",
+			shell:  "cool-api",
+		},
+		{
+			name:   "LiteralInterpolated",
+			arg:    RuntimeArg{Key: "app.release", Value: "{{ environment }} build {{ sha }}"},
+			github: "staging build ${{ github.sha }}",
+			shell:  `'staging build '"${SHA}"`,
+		},
+		{
+			name:   "Sha",
+			arg:    RuntimeArg{Key: "app.version", Value: "{{ sha }}"},
+			github: "${{ github.sha }}",
+			shell:  `"${SHA}"`,
+		},
+		{
+			name:   "Env",
+			arg:    RuntimeArg{Key: "app.lifecycle", EnvValue: "LIFECYCLE"},
+			github: "${{ env.LIFECYCLE }}",
+			shell:  `"${LIFECYCLE}"`,
+		},
+		{
+			name:   "Secret",
+			arg:    RuntimeArg{Key: "db.password", SecretValue: "db-password"},
+			github: "${{ secrets.db-password }}",
+			shell:  `"${db_password}"`,
+		},
+		{
+			name:   "StepOutput",
+			arg:    RuntimeArg{Key: "app.endpoint", StepOutput: "infra.endpoint"},
+			github: "${{ steps.infra.outputs.endpoint }}",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.github, tc.arg.ResolveGitHub("staging").Value)
+			if tc.shell != "" {
+				assert.Equal(t, tc.shell, tc.arg.ResolveShell("staging", "SHA").Value)
+			}
+		})
+	}
+}
+
+func TestResolveLiteralRuntimeArgsInProcess(t *testing.T) {
+	release := RuntimeArg{Key: "app.release", Value: "{{ environment }}-{{ sha }}"}.Resolve("currentSha", "staging")
+	assert.Equal(t, "staging-currentSha", release.Value)
+	assert.False(t, release.IsEnv())
+
+	secret := RuntimeArg{Key: "db.password", SecretValue: "db-password"}.Resolve("currentSha", "staging")
+	assert.Equal(t, "${{ secrets.db-password }}", secret.Value)
+	assert.True(t, secret.IsEnv())
+}
+
+func TestDeployHelmApplicationWithArtifactImage(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "app.lifecycle", EnvValue: "LIFECYCLE", Value: "${{ env.LIFECYCLE }}"},
+		{Key: "app.image", ArtifactImage: "api"},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewCommand("helm", "upgrade", "api-chart", "helm/api",
+		"--install",
+		"--atomic",
+		"--namespace", "api",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"--set", "app.lifecycle=$app_lifecycle",
+		"--set", fmt.Sprintf("app.image=%s/api-app:%s", builder.repository(), builder.currentSha),
+	).SetExpandArgs(true), sideEffects.Commands[1])
+
+	step := GetDeployStep(application.Id, application.RuntimeArgs, "deploy")
+	assert.Equal(t, map[string]string{"app_lifecycle": "${{ env.LIFECYCLE }}"}, step.Env)
+}
+
+func TestArtifactImageDependencies(t *testing.T) {
+	config := PipelineConfigRaw{
+		Artifacts: []ArtifactConfig{{Id: "api", Path: "packages/api"}},
+		Applications: []ApplicationConfig{{
+			Id:     "api-chart",
+			Path:   "helm/api",
+			Values: []RuntimeArg{{Key: "app.image", ArtifactImage: "api"}},
+		}},
+	}
+
+	dependencies := ParseDependencies(config)
+
+	assert.Equal(t, []string{"build-api"}, dependencies.GetUpstreamJobIds("api-chart"))
+}
+
+func TestValuesValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Artifacts: []ArtifactConfig{{Id: "api", Path: "packages/api"}},
+		Applications: []ApplicationConfig{{
+			Id: "api-chart",
+			Values: []RuntimeArg{
+				{Key: "app.name", Value: "cool-api"},
+				{Key: "app.lifecycle"},
+				{Key: "app.password", Value: "hunter2", SecretValue: "db-password"},
+				{Key: "app.endpoint", StepOutput: "infra"},
+				{Key: "app.image", ArtifactImage: "client"},
+				{Value: "orphan"},
+			},
+		}},
+		Environments: EnvironmentConfigs{{
+			Name: "prod",
+			Applications: map[string]EnvironmentApplicationConfig{
+				"api-chart": {Values: []RuntimeArg{{Key: "app.name"}}},
+			},
+		}},
+	}
+
+	errs := ValidateApplications(NewValidationErrors(""), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("applications").
+				PutChild(NewValidationErrors("api-chart").
+					PutChild(NewValidationErrors("values").
+						PutChild(NewValidationErrors("1").
+							Put("value", fmt.Errorf("one of value, envValue, secretValue, stepOutput or artifactImage is required"))).
+						PutChild(NewValidationErrors("2").
+							Put("value", fmt.Errorf("only one of secretValue, value may be set"))).
+						PutChild(NewValidationErrors("3").
+							Put("stepOutput", fmt.Errorf("'infra' must be of the form <step id>.<output>"))).
+						PutChild(NewValidationErrors("4").
+							Put("artifactImage", fmt.Errorf("unknown artifact 'client'"))).
+						PutChild(NewValidationErrors("5").
+							Put("key", eMissingRequiredField))))).
+			PutChild(NewValidationErrors("environments").
+				PutChild(NewValidationErrors("0").
+					PutChild(NewValidationErrors("applications").
+						PutChild(NewValidationErrors("api-chart").
+							PutChild(NewValidationErrors("values").
+								PutChild(NewValidationErrors("0").
+									Put("value", fmt.Errorf("one of value, envValue, secretValue, stepOutput or artifactImage is required")))))))),
+		errs,
+	)
+}
+
+func TestStepOutputsUnsupportedTarget(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	config.Applications[1].Values = append(config.Applications[1].Values,
+		RuntimeArg{Key: "password", StepOutput: "secrets-gcp-project.pg-password"})
+
+	_, err = ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetGitlab)
+
+	assert.EqualError(t, err, "stepOutput values are not supported for target 'gitlab'")
+}
+
+func TestStepOutputsReferenceDeployJobSteps(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	config.Applications[1].Values = append(config.Applications[1].Values,
+		RuntimeArg{Key: "password", StepOutput: "secrets-gcp-project.pg-password"})
+
+	_, err = ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetGithub)
+	assert.Nil(t, err)
+	_, err = CreateApplications(TestArgs(""), NewAlwaysChanged(), config, "repo", ParseDependencies(config))
+	assert.Nil(t, err)
+
+	config.Applications[0].Values = append(config.Applications[0].Values, RuntimeArg{Key: "endpoint", StepOutput: "infra.endpoint"})
+	expected := NewValidationErrors("applications").
+		PutChild(NewValidationErrors("infra").
+			Put("values", fmt.Errorf("stepOutput 'infra.endpoint' references step 'infra', which isn't in the deploy job")))
+
+	_, err = ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetGithub)
+	assert.Equal(t, expected, err)
+	_, err = CreateApplications(TestArgs(""), NewAlwaysChanged(), config, "repo", ParseDependencies(config))
+	assert.Equal(t, expected, err)
+}
+
+func TestDeployHelmApplicationWithValuesFiles(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.ValuesFiles = []string{"helm/api/values-prod.yaml"}
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "app.name", Value: "cool-api"},
+		{Key: "app.version", Value: "0123", Flag: helmSetString},
+		{Key: "app.config", Value: "config/app.conf", Flag: helmSetFile},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Empty(t, sideEffects.Files)
+	assert.Equal(t, NewCommand("helm", "upgrade", "api-chart", "helm/api",
+		"--install",
+		"--atomic",
+		"--namespace", "api",
+		"-f", "helm/api/values-prod.yaml",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"--set", "app.name=cool-api",
+		"--set-string", "app.version=0123",
+		"--set-file", "app.config=config/app.conf",
+	), sideEffects.Commands[1])
+}
+
+func TestDeployHelmApplicationWithRenderedValues(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.RenderValues = true
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "app.name", EnvValue: "APP_NAME", Value: "${{ env.APP_NAME }}"},
+		{Key: "app.replicas", Value: "3"},
+		{Key: "app.version", Value: "0123", Flag: helmSetString},
+		{Key: "app.config", Value: "config/app.conf", Flag: helmSetFile},
+		{Key: "image", ArtifactImage: "api"},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Len(t, sideEffects.Files, 1)
+	file := sideEffects.Files[0]
+	assert.Equal(t, NewCommand("helm", "upgrade", "api-chart", "helm/api",
+		"--install",
+		"--atomic",
+		"--namespace", "api",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"-f", "$API_CHART_VALUES_FILE",
+		"--set-file", "app.config=config/app.conf",
+	), sideEffects.Commands[1])
+	assert.Equal(t, "api-chart-values-*.yaml", file.Path)
+	assert.Equal(t, "API_CHART_VALUES_FILE", file.Var)
+
+	env := map[string]string{
+		"app_name": "cool, api",
+	}
+	content, err := file.Resolve(func(key string) string {
+		return env[key]
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf(`app:
+    name: cool, api
+    replicas: 3
+    version: !!str 0123
+image: %s/api-app:%s
+`, builder.repository(), builder.currentSha), content)
+}
+
+func TestDeployHelmApplicationPassesLiteralValuesUnchanged(t *testing.T) {
+	t.Setenv("app_name", "cool-api")
+	t.Setenv("word", "expanded")
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "app.name", EnvValue: "APP_NAME", Value: "${{ env.APP_NAME }}"},
+		{Key: "app.password", Value: "pa$word$$"},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+	assert.Nil(t, err)
+	upgrade := sideEffects.Commands[1]
+	assert.True(t, upgrade.ExpandArgs)
+	resolved := ResolveArgs(upgrade.Arguments)
+	assert.Contains(t, resolved, "app.name=cool-api")
+	assert.Contains(t, resolved, "app.password=pa$word$$")
+
+	application.RenderValues = true
+	sideEffects, err = application.PrepareBuild().Build()
+	assert.Nil(t, err)
+	content, err := sideEffects.Files[0].Resolve(os.Getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "app:\n    name: cool-api\n    password: pa$word$$\n", content)
+}
+
+func TestDeployHelmApplicationPinningDigests(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.RenderValues = true
+	application.Helm.PinDigests = true
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "app.name", Value: "cool-api"},
+		{Key: "app.image", ArtifactImage: "api"},
+		{Key: "worker.image", ArtifactImage: "api"},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+	assert.Nil(t, err)
+
+	image := builder.repository() + "/api-app"
+	runner := new(mocks.CommandRunner)
+	runner.On("Run", "helm", "dep", "update", "helm/api").Return(nil)
+	runner.On("Output", "oras", "resolve", image+":"+builder.currentSha).Return("sha256:abc123\n", nil)
+	runner.On("Run", "helm", "upgrade", "api-chart", "helm/api",
+		"--install",
+		"--atomic",
+		"--namespace", "api",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"-f", "/tmp/api-chart-values-1.yaml",
+		"--set", "app.image="+image+"@sha256:abc123",
+		"--set", "worker.image="+image+"@sha256:abc123",
+	).Return(nil)
+
+	assert.Nil(t, runCommands(runner, sideEffects.Commands, map[string]string{"API_CHART_VALUES_FILE": "/tmp/api-chart-values-1.yaml"}))
+	runner.AssertExpectations(t)
+	assert.NotContains(t, sideEffects.Files[0].Content, "image")
+
+	application.Verify = true
+	sideEffects, err = application.PrepareBuild().Build()
+	assert.Nil(t, err)
+	assert.Contains(t, sideEffects.Files[0].Content, image+":"+builder.currentSha)
+	assert.NotContains(t, commandLines(sideEffects.Commands), []string{"oras", "resolve", image + ":" + builder.currentSha})
+}
+
+func TestDeployHelmApplicationWithImageValues(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("website", "helm/website", applicationTypeHelm).
+		SetNamespace("website")
+	application.ImageValues = true
+	application.readsRegistry = true
+	application.Artifacts = []string{"client", "api"}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"oras", "resolve", builder.repository() + "/client-app:" + builder.currentSha},
+		{"oras", "resolve", builder.repository() + "/api-app:" + builder.currentSha},
+	}, commandLines(sideEffects.Commands[1:3]))
+	assert.Equal(t, DigestVars{Name: "CLIENT_DIGEST"}, sideEffects.Commands[1].Vars)
+	assert.Equal(t, NewCommand("helm", "upgrade", "website", "helm/website",
+		"--install",
+		"--atomic",
+		"--namespace", "website",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"--set", "images.api.repository="+builder.repository()+"/api-app",
+		"--set", "images.api.tag="+builder.currentSha,
+		"--set", "images.api.digest=$API_DIGEST",
+		"--set", "images.client.repository="+builder.repository()+"/client-app",
+		"--set", "images.client.tag="+builder.currentSha,
+		"--set", "images.client.digest=$CLIENT_DIGEST",
+	), sideEffects.Commands[3])
+
+	assert.Equal(t, []string{"Authenticate to GKE Cluster", "Setup Helm", "Configure GCloud SDK", "Configure Docker", "Setup ORAS", "Deploy website"},
+		stepNames(application.GetSteps("./cmd/build", "pipeline.yaml")))
+}
+
+func TestDeployTerraformApplicationWithImageValues(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("infra", "tf/main", applicationTypeTerraform)
+	application.ImageValues = true
+	application.Artifacts = []string{"api"}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	image := builder.repository() + "/api-app"
+	assert.Equal(t, []string{"oras", "resolve", image + ":" + builder.currentSha}, commandLines(sideEffects.Commands)[1])
+	assert.Equal(t, map[string]string{
+		"TF_VAR_images": fmt.Sprintf(`{"api":{"repository":"%s","tag":"%s","digest":"$API_DIGEST"}}`, image, builder.currentSha),
+	}, sideEffects.Commands[2].Env)
+
+	application.Phase = terraformPhaseApply
+	sideEffects, err = application.PrepareBuild().Build()
+	assert.Nil(t, err)
+	assert.NotContains(t, commandLines(sideEffects.Commands), []string{"oras", "resolve", image + ":" + builder.currentSha})
+}
+
+func TestDeployTerraformApplicationPassesLiteralValuesUnchanged(t *testing.T) {
+	t.Setenv("region", "us-central1")
+	builder := NewTestBuilder()
+	application := builder.Application("infra", "tf/main", applicationTypeTerraform)
+	application.RuntimeArgs = []RuntimeArg{
+		{Key: "password", Value: "pa$$w0rd"},
+		{Key: "region", EnvValue: "REGION", Value: "${{ env.REGION }}"},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+	assert.Nil(t, err)
+	plan := sideEffects.Commands[1]
+	assert.Equal(t, []string{"terraform", "-chdir=tf/main", "plan", "-out=plan.out"}, commandLines(sideEffects.Commands)[1])
+
+	output, err := ShellCommandRunner{}.OutputWith(plan.options(), "sh", "-c", `printf '%s %s' "$TF_VAR_password" "$TF_VAR_region"`)
+	assert.Nil(t, err)
+	assert.Equal(t, "pa$$w0rd us-central1", output)
+
+	runner := NewRecordingCommandRunner()
+	assert.Nil(t, NewSideEffects(plan).Apply(runner))
+	assert.Equal(t, "pa$$w0rd", runner.Commands()[0].Env["TF_VAR_password"])
+}
+
+func TestPinDigestsValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Applications: []ApplicationConfig{{
+			Id:     "api-chart",
+			Type:   applicationTypeHelm,
+			Values: []RuntimeArg{{Key: "app.name", Value: "cool-api"}},
+			Helm:   HelmConfig{PinDigests: true},
+		}},
+	}
+
+	errs := ValidateApplications(NewValidationErrors(""), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("applications").
+				PutChild(NewValidationErrors("api-chart").
+					Put("helm", fmt.Errorf("pinDigests pins artifactImage values, so it requires one")))).
+			PutChild(NewValidationErrors("environments")),
+		errs,
+	)
+}
+
+func TestDeployKustomizeApplication(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-manifests", "k8s/overlays/prod", applicationTypeKustomize).
+		SetNamespace("api")
+	application.Artifacts = []string{"api"}
+	application.Manifests = ManifestOptions{
+		PruneLabels: map[string]string{"app.kubernetes.io/part-of": "api", "app.kubernetes.io/managed-by": "fun"},
+		Rollouts:    []string{"deployment/api", "statefulset/cache"},
+		Timeout:     "5m",
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Empty(t, sideEffects.Files)
+	assert.Equal(t, []Command{
+		NewCommand("kustomize", "edit", "set", "image",
+			fmt.Sprintf("%s/api-app=%s/api-app:%s", builder.repository(), builder.repository(), builder.currentSha),
+		).SetDir("k8s/overlays/prod"),
+		NewCommand("kubectl", "apply", "--server-side", "-k", "k8s/overlays/prod",
+			"--namespace", "api",
+			"--prune", "-l", "app.kubernetes.io/managed-by=fun,app.kubernetes.io/part-of=api",
+		),
+		NewCommand("kubectl", "rollout", "status", "deployment/api", "--namespace", "api", "--timeout", "5m"),
+		NewCommand("kubectl", "rollout", "status", "statefulset/cache", "--namespace", "api", "--timeout", "5m"),
+	}, sideEffects.Commands)
+
+	application.Verify = true
+	sideEffects, err = application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(NewCommand("kubectl", "kustomize", "k8s/overlays/prod")), sideEffects)
+}
+
+func TestDeployKubectlApplication(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-manifests", "test_fixtures/manifests/api", applicationTypeKubectl)
+	application.Artifacts = []string{"api"}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []File{{
+		Path: "test_fixtures/manifests/api/kustomization.yaml",
+		Content: `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+    - deployment.yaml
+    - service.yaml
+`,
+	}}, sideEffects.Files)
+	assert.Equal(t, []Command{
+		NewCommand("kustomize", "edit", "set", "image",
+			fmt.Sprintf("%s/api-app=%s/api-app:%s", builder.repository(), builder.repository(), builder.currentSha),
+		).SetDir("test_fixtures/manifests/api"),
+		NewCommand("kubectl", "apply", "--server-side", "-k", "test_fixtures/manifests/api"),
+	}, sideEffects.Commands)
+
+	application.Path = "test_fixtures/missing"
+	_, err = application.PrepareBuild().Build()
+	assert.NotNil(t, err)
+}
+
+func TestManifestsValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Applications: []ApplicationConfig{
+			{
+				Id:   "api-manifests",
+				Type: applicationTypeKustomize,
+				Manifests: ManifestOptions{
+					Timeout:  "soon",
+					Rollouts: []string{"api"},
+				},
+			},
+			{
+				Id:        "api-chart",
+				Type:      applicationTypeHelm,
+				Manifests: ManifestOptions{Rollouts: []string{"deployment/api"}},
+			},
+		},
+	}
+
+	errs := ValidateApplications(NewValidationErrors(""), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("applications").
+				PutChild(NewValidationErrors("api-manifests").
+					PutChild(NewValidationErrors("manifests").
+						Put("timeout", fmt.Errorf("'soon' is not a duration")).
+						Put("rollouts", fmt.Errorf("'api' must be of the form <kind>/<name>")))).
+				PutChild(NewValidationErrors("api-chart").
+					Put("manifests", fmt.Errorf("only applies to kustomize and kubectl applications")))),
+		errs,
+	)
+}
+
+func TestKustomizeApplicationSteps(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-manifests", "k8s/overlays/prod", applicationTypeKustomize)
+	application.Manifests = ManifestOptions{KustomizeVersion: "5.2.1"}
+
+	steps := application.GetSteps("./cmd/build", "pipeline.yaml")
+
+	assert.Equal(t, []GitHubActionsStep{
+		GetSetupGkeStep(builder.clusterConfig),
+		GetSetupKustomizeStep("5.2.1"),
+		GetDeployStep("api-manifests", nil, GetDeployRunCommand("api-manifests", "./cmd/build", "pipeline.yaml")),
+	}, steps)
+}
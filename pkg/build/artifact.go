@@ -7,31 +7,60 @@ import (
 type Artifact struct {
 	Id            string
 	Path          string
+	Type          ArtifactType
+	Platforms     []string
+	Docker        DockerOptions
 	Repository    string
 	Host          string
 	CurrentSha    string
 	hasChanged    bool
 	CloudProvider CloudProviderConfig
+	// Verify builds and tests the artifact without pushing or retagging
+	Verify         bool
+	VerifyBuildApp bool
 }
 
 func CreateArtifacts(args ActionArgs, cd ChangeDetection, config PipelineConfigRaw, artifactRepository string) map[string]Artifact {
 	artifacts := make(map[string]Artifact)
 	for _, spec := range config.Artifacts {
-		artifacts[spec.Id] = Artifact{
-			Id:            spec.Id,
-			Path:          spec.Path,
-			Repository:    artifactRepository,
-			Host:          config.Resources.ArtifactRepository.Host,
-			CurrentSha:    args.CurrentSha,
-			hasChanged:    cd.HasChanged(spec.Path),
-			CloudProvider: config.Resources.CloudProvider,
+		artifact := Artifact{
+			Id:             spec.Id,
+			Path:           spec.Path,
+			Type:           spec.Type,
+			Platforms:      spec.Platforms,
+			Docker:         spec.Docker.Resolve(args.CurrentSha),
+			Repository:     artifactRepository,
+			Host:           config.Resources.ArtifactRepository.Host,
+			CurrentSha:     args.CurrentSha,
+			CloudProvider:  config.Resources.CloudProvider,
+			Verify:         args.Verify,
+			VerifyBuildApp: args.VerifyBuildApp,
 		}
+		artifactCd := cd
+		if perArtifact, ok := cd.(ArtifactChangeDetection); ok {
+			artifactCd = perArtifact.ForArtifact(artifact)
+		}
+		artifact.hasChanged = artifactCd.HasChanged(spec.WatchPatterns()...)
+		artifacts[spec.Id] = artifact
 	}
 	return artifacts
 }
 
 func (a Artifact) PrepareBuild() (Build, error) {
-	return NewDockerImage(a), nil
+	switch a.Type {
+	case artifactTypeApp:
+		return NewDockerImage(a), nil
+	case artifactTypeLib:
+		return NewLib(a), nil
+	case artifactTypeGoBinary:
+		return NewGoBinary(a), nil
+	case artifactTypeHelmChart:
+		return NewHelmChart(a), nil
+	case artifactTypeNpm:
+		return NewNpmPackage(a), nil
+	default:
+		return nil, fmt.Errorf("invalid artifact type %d", a.Type)
+	}
 }
 
 func (a Artifact) GreenTag() string {
@@ -42,20 +71,37 @@ func (a Artifact) JobId() string {
 	return fmt.Sprintf("build-%s", a.Id)
 }
 
+// Images are named after the default targets, so that configuring targets doesn't rename images applications depend on.
+const (
+	verifyImageSuffix = "test"
+	appImageSuffix    = "app"
+)
+
 func (a Artifact) VerifyTarget() string {
-	return "test"
+	if a.Docker.Targets.Test == "" {
+		return verifyImageSuffix
+	}
+	return a.Docker.Targets.Test
 }
 
 func (a Artifact) VerifyImageName() string {
-	return fmt.Sprintf("%s/%s-%s:%s", a.Repository, a.Id, a.VerifyTarget(), a.CurrentSha)
+	return fmt.Sprintf("%s/%s-%s:%s", a.Repository, a.Id, verifyImageSuffix, a.CurrentSha)
 }
 
 func (a Artifact) AppTarget() string {
-	return "app"
+	if a.Docker.Targets.App == "" {
+		return appImageSuffix
+	}
+	return a.Docker.Targets.App
 }
 
 func (a Artifact) AppImageBase() string {
-	return fmt.Sprintf("%s/%s-%s", a.Repository, a.Id, a.AppTarget())
+	return fmt.Sprintf("%s/%s-%s", a.Repository, a.Id, appImageSuffix)
+}
+
+// CacheRef is the registry ref of a target's BuildKit cache, when the registry cache is used.
+func (a Artifact) CacheRef(target string) string {
+	return a.AppImageName("buildcache-" + target)
 }
 
 func (a Artifact) AppImageName(tag string) string {
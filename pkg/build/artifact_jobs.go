@@ -5,7 +5,7 @@ import (
 	"strings"
 )
 
-func (a Artifact) ToGitHubActionsJob(cmd string, configPath string) GitHubActionsJob {
+func (a Artifact) ToGitHubActionsJob(cmd string, configPath string, buildArgs ...string) GitHubActionsJob {
 	return GitHubActionsJob{
 		Name:   "Build " + a.Id,
 		RunsOn: "ubuntu-latest",
@@ -13,19 +13,13 @@ func (a Artifact) ToGitHubActionsJob(cmd string, configPath string) GitHubAction
 			"id-token": "write",
 			"contents": "read",
 		},
-		Steps: a.GetSteps(cmd, configPath),
+		Steps: a.GetSteps(cmd, configPath, buildArgs...),
 	}
 }
 
-func (a Artifact) GetSteps(cmd string, configPath string) []GitHubActionsStep {
+func (a Artifact) GetSteps(cmd string, configPath string, buildArgs ...string) []GitHubActionsStep {
 	// TODO consolidate setup steps
-	checkoutStep := GitHubActionsStep{
-		Name: "Checkout Repo",
-		Uses: "actions/checkout@v3",
-		With: map[string]interface{}{
-			"fetch-depth": 2,
-		},
-	}
+	checkoutStep := CheckoutRepoStep()
 
 	setupGoStep := GitHubActionsStep{
 		Name: "Setup Go",
@@ -37,35 +31,132 @@ func (a Artifact) GetSteps(cmd string, configPath string) []GitHubActionsStep {
 
 	cloudProviderAuthStep := a.CloudProvider.Impl().AuthStep()
 
-	setupGcloudStep := GitHubActionsStep{
-		Name: "Configure GCloud SDK",
-		Uses: "google-github-actions/setup-gcloud@v0",
-	}
-
-	configureDockerStep := GitHubActionsStep{
-		Name: "Configure Docker",
-		Run:  fmt.Sprintf("gcloud --quiet auth configure-docker %s", a.Host),
-	}
-
 	buildArtifactCommand := strings.Join(
-		[]string{
+		append([]string{
 			fmt.Sprintf("go run %s build-artifact %s", cmd, a.Id),
 			fmt.Sprintf("--config %s", configPath),
 			"--current-sha $GITHUB_SHA",
-		}, " \\\n  ",
+		}, buildArgs...), " \\\n  ",
 	)
 
 	buildArtifactStep := GitHubActionsStep{
 		Name: fmt.Sprintf("Build %s", a.Id),
+		Env:  GetArtifactBuildEnv(a.Type, a.Docker.BuildArgs),
 		Run:  buildArtifactCommand,
 	}
 
-	return []GitHubActionsStep{
+	steps := []GitHubActionsStep{
 		checkoutStep,
 		setupGoStep,
 		cloudProviderAuthStep,
-		setupGcloudStep,
-		configureDockerStep,
-		buildArtifactStep,
+	}
+	if a.Type.UsesRegistry(a.Docker.Cache) {
+		steps = append(steps, GetConfigureDockerSteps(a.Host)...)
+	}
+	steps = append(steps, GetArtifactToolSteps(a.Type, a.Platforms, a.Docker)...)
+	return append(steps, buildArtifactStep)
+}
+
+// GetConfigureDockerSteps authenticates Docker to the artifact repository host.
+func GetConfigureDockerSteps(host string) []GitHubActionsStep {
+	return []GitHubActionsStep{
+		{
+			Name: "Configure GCloud SDK",
+			Uses: "google-github-actions/setup-gcloud@v0",
+		},
+		{
+			Name: "Configure Docker",
+			Run:  fmt.Sprintf("gcloud --quiet auth configure-docker %s", host),
+		},
+	}
+}
+
+const (
+	defaultOrasVersion = "1.1.0"
+	defaultNodeVersion = "18"
+)
+
+// GetArtifactToolSteps sets up the tools an artifact type builds with, besides Go and Docker.
+func GetArtifactToolSteps(artifactType ArtifactType, platforms []string, docker DockerOptions) []GitHubActionsStep {
+	switch artifactType {
+	case artifactTypeApp, artifactTypeLib:
+		var steps []GitHubActionsStep
+		if artifactType == artifactTypeApp {
+			// unchanged images are promoted with oras
+			steps = append(steps, GetSetupOrasStep())
+		}
+		if len(platforms) > 0 {
+			steps = append(steps, GetSetupQemuStep())
+		}
+		if docker.UsesBuildx(platforms) {
+			steps = append(steps, GetSetupBuildxStep())
+		}
+		if docker.Cache == dockerCacheGha {
+			steps = append(steps, GetExposeActionsRuntimeStep())
+		}
+		if !docker.Sign.IsEmpty() {
+			steps = append(steps, GetSetupCosignStep())
+		}
+		return steps
+	case artifactTypeGoBinary:
+		return []GitHubActionsStep{GetSetupOrasStep()}
+	case artifactTypeHelmChart:
+		return []GitHubActionsStep{GetSetupHelmStep(defaultHelmVersion), GetSetupOrasStep()}
+	case artifactTypeNpm:
+		return []GitHubActionsStep{GetSetupNodeStep()}
+	default:
+		return nil
+	}
+}
+
+// GetArtifactBuildEnv passes Docker build args, and the npm token used by the package's .npmrc to publish.
+func GetArtifactBuildEnv(artifactType ArtifactType, buildArgs []RuntimeArg) map[string]string {
+	var envMap map[string]string
+	if artifactType == artifactTypeNpm {
+		envMap = map[string]string{"NODE_AUTH_TOKEN": "${{ secrets.NPM_TOKEN }}"}
+	}
+	return runtimeArgEnv(envMap, buildArgs)
+}
+
+// GetSetupQemuStep emulates other platforms for multi-platform builds.
+func GetSetupQemuStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup QEMU",
+		Uses: "docker/setup-qemu-action@v2",
+	}
+}
+
+// GetExposeActionsRuntimeStep exposes the cache service URL and token to run steps, which BuildKit's gha cache needs.
+func GetExposeActionsRuntimeStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Expose GitHub Actions Runtime",
+		Uses: "crazy-max/ghaction-github-runtime@v3",
+	}
+}
+
+func GetSetupBuildxStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup Docker Buildx",
+		Uses: "docker/setup-buildx-action@v2",
+	}
+}
+
+func GetSetupOrasStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup ORAS",
+		Uses: "oras-project/setup-oras@v1",
+		With: map[string]interface{}{
+			"version": defaultOrasVersion,
+		},
+	}
+}
+
+func GetSetupNodeStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup Node",
+		Uses: "actions/setup-node@v3",
+		With: map[string]interface{}{
+			"node-version": defaultNodeVersion,
+		},
 	}
 }
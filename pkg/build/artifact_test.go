@@ -0,0 +1,449 @@
+package build
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildLibArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("pkg", ".")
+	artifact.Type = artifactTypeLib
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "build", "-f", "./Dockerfile", "-t", artifact.VerifyImageName(), "--target", "test", "."),
+		NewCommand("docker", "run", "--rm", artifact.VerifyImageName()),
+	), sideEffects)
+
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(), sideEffects)
+}
+
+func TestBuildGoBinaryArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("cli", "cmd/cli")
+	artifact.Type = artifactTypeGoBinary
+	artifact.Platforms = []string{"linux/arm64", "windows/amd64"}
+	ref := fmt.Sprintf("%s/cli-app", builder.repository())
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("go", "test", "./cmd/cli/..."),
+		NewCommand("go", "build", "-o", "dist/cli/cli-linux-arm64", "./cmd/cli").
+			SetEnv("CGO_ENABLED", "0").SetEnv("GOARCH", "arm64").SetEnv("GOOS", "linux"),
+		NewCommand("go", "build", "-o", "dist/cli/cli-windows-amd64.exe", "./cmd/cli").
+			SetEnv("CGO_ENABLED", "0").SetEnv("GOARCH", "amd64").SetEnv("GOOS", "windows"),
+		NewCommand("oras", "push", ref+":currentSha", "dist/cli/cli-linux-arm64", "dist/cli/cli-windows-amd64.exe"),
+		NewCommand("oras", "tag", ref+":currentSha", "latest-green"),
+	), sideEffects)
+
+	artifact.Verify = true
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(NewCommand("go", "test", "./cmd/cli/...")), sideEffects)
+
+	artifact.Verify = false
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(NewCommand("oras", "tag", ref+":latest-green", "currentSha")), sideEffects)
+}
+
+func TestBuildHelmChartArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("common-chart", "test_fixtures/charts/common")
+	artifact.Type = artifactTypeHelmChart
+	ref := fmt.Sprintf("%s/common", builder.repository())
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("helm", "dep", "update", "test_fixtures/charts/common"),
+		NewCommand("helm", "lint", "test_fixtures/charts/common"),
+		NewCommand("helm", "package", "test_fixtures/charts/common", "--destination", "dist/common-chart", "--app-version", "currentSha"),
+		NewCommand("helm", "push", "dist/common-chart/common-1.4.0+build.2.tgz", "oci://"+builder.repository()),
+		NewCommand("oras", "tag", ref+":1.4.0_build.2", "currentSha", "latest-green"),
+	), sideEffects)
+
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(NewCommand("oras", "tag", ref+":latest-green", "currentSha")), sideEffects)
+
+	artifact.Path = "test_fixtures/charts/missing"
+	build, _ = artifact.PrepareBuild()
+	_, err = build.Build()
+	assert.NotNil(t, err)
+}
+
+func TestBuildNpmArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("ui-kit", "packages/ui-kit")
+	artifact.Type = artifactTypeNpm
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	remove := NewCommand("rm", "-rf", "packages/.ui-kit-publish")
+	assert.Equal(t, NewSideEffects(
+		NewCommand("npm", "ci", "--prefix", "packages/ui-kit"),
+		NewCommand("npm", "test", "--prefix", "packages/ui-kit"),
+		NewCommand("npm", "pkg", "get", "version").SetDir("packages/ui-kit").SetVars(NpmPackageVars{}),
+		NewCommand("cp", "-R", "packages/ui-kit", "packages/.ui-kit-publish"),
+		NewCommand("npm", "version", "${NPM_VERSION}-currentSha", "--no-git-tag-version").SetDir("packages/.ui-kit-publish"),
+		NewCommand("npm", "publish", "--tag", "latest-green").SetDir("packages/.ui-kit-publish"),
+		remove,
+	).AddOnFailure(remove), sideEffects)
+
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(), sideEffects)
+
+	artifact.hasChanged = true
+	artifact.Verify = true
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("npm", "ci", "--prefix", "packages/ui-kit"),
+		NewCommand("npm", "test", "--prefix", "packages/ui-kit"),
+	), sideEffects)
+}
+
+func TestPublishNpmArtifactWithPackageVersion(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("ui-kit", "packages/ui-kit")
+	artifact.Type = artifactTypeNpm
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+	assert.Nil(t, err)
+
+	runner := NewRecordingCommandRunner()
+	stub := npmStubRunner{RecordingCommandRunner: runner, output: `"1.2.0"`}
+	assert.Nil(t, sideEffects.Apply(stub))
+
+	assert.Equal(t, []string{
+		"npm ci --prefix packages/ui-kit",
+		"npm test --prefix packages/ui-kit",
+		"(cd packages/ui-kit && npm pkg get version)",
+		"cp -R packages/ui-kit packages/.ui-kit-publish",
+		"(cd packages/.ui-kit-publish && npm version 1.2.0-currentSha --no-git-tag-version)",
+		"(cd packages/.ui-kit-publish && npm publish --tag latest-green)",
+		"rm -rf packages/.ui-kit-publish",
+	}, recordedLines(runner.Commands()))
+}
+
+// npmStubRunner records commands, printing the package's info when it's read.
+type npmStubRunner struct {
+	RecordingCommandRunner
+	output string
+}
+
+func (n npmStubRunner) OutputWith(options ExecOptions, name string, args ...string) (string, error) {
+	_, err := n.RecordingCommandRunner.OutputWith(options, name, args...)
+	return n.output, err
+}
+
+func recordedLines(commands []RecordedCommand) []string {
+	var lines []string
+	for _, command := range commands {
+		lines = append(lines, command.String())
+	}
+	return lines
+}
+
+func TestArtifactTypeSteps(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("ui-kit", "packages/ui-kit")
+	artifact.Type = artifactTypeNpm
+
+	steps := artifact.GetSteps("./cmd/build", "pipeline.yaml")
+
+	assert.Equal(t, []string{"Checkout Repo", "Setup Go", "Authenticate to GCloud via Service Account", "Setup Node", "Build ui-kit"},
+		stepNames(steps))
+	assert.Equal(t, map[string]string{"NODE_AUTH_TOKEN": "${{ secrets.NPM_TOKEN }}"}, steps[4].Env)
+
+	artifact.Type = artifactTypeHelmChart
+	assert.Equal(t, []string{"Checkout Repo", "Setup Go", "Authenticate to GCloud via Service Account",
+		"Configure GCloud SDK", "Configure Docker", "Setup Helm", "Setup ORAS", "Build ui-kit"},
+		stepNames(artifact.GetSteps("./cmd/build", "pipeline.yaml")))
+}
+
+func TestArtifactsValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Artifacts: []ArtifactConfig{
+			{Id: "ui-kit", Path: "packages/ui-kit", Type: artifactTypeNpm, Platforms: []string{"linux/amd64"}},
+			{Id: "cli", Path: "cmd/cli", Type: artifactTypeGoBinary, Platforms: []string{"linux"}},
+		},
+		Applications: []ApplicationConfig{{
+			Id:     "api-chart",
+			Values: []RuntimeArg{{Key: "image", ArtifactImage: "cli"}},
+		}},
+	}
+
+	errs := ValidateApplications(ValidateArtifacts(NewValidationErrors(""), config), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("artifacts").
+				PutChild(NewValidationErrors("ui-kit").
+					Put("platforms", fmt.Errorf("only applies to app and go-binary artifacts"))).
+				PutChild(NewValidationErrors("cli").
+					Put("platforms", fmt.Errorf("'linux' must be of the form <os>/<arch>")))).
+			PutChild(NewValidationErrors("applications").
+				PutChild(NewValidationErrors("api-chart").
+					PutChild(NewValidationErrors("values").
+						PutChild(NewValidationErrors("0").
+							Put("artifactImage", fmt.Errorf("artifact 'cli' isn't a docker image")))))),
+		errs,
+	)
+}
+
+func stepNames(steps []GitHubActionsStep) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestBuildAppArtifactWithDockerOptions(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("api", "packages/api")
+	artifact.Docker = DockerOptions{
+		Dockerfile: "docker/api.Dockerfile",
+		Context:    ".",
+		Targets:    DockerTargets{Test: "unit", App: "runtime"},
+		BuildArgs: []RuntimeArg{
+			{Key: "GIT_SHA", Value: "{{ sha }}"},
+			{Key: "PRICE", Value: "$5"},
+			{Key: "NODE_ENV", EnvValue: "NODE_ENV"},
+			{Key: "npm.token", SecretValue: "npm-token"},
+		},
+	}.Resolve("currentSha")
+	buildArgs := []string{"--build-arg", "GIT_SHA=currentSha", "--build-arg", "PRICE=$$5", "--build-arg", "NODE_ENV=$NODE_ENV",
+		"--secret", "id=npm.token,env=npm_token"}
+	assert.Equal(t, "PRICE=$5", ResolveArgs(buildArgs)[3])
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "build", "-f", "docker/api.Dockerfile", "-t", artifact.VerifyImageName(), "--target", "unit").
+			Add(buildArgs...).Add(".").SetExpandArgs(true),
+		NewCommand("docker", "run", "--rm", artifact.VerifyImageName()),
+		NewCommand("docker", "build", "-f", "docker/api.Dockerfile", "-t", artifact.AppImageName("currentSha"), "--target", "runtime").
+			Add(buildArgs...).Add(".").SetExpandArgs(true),
+		NewCommand("docker", "tag", artifact.AppImageName("currentSha"), artifact.AppImageName("latest-green")),
+		NewCommand("docker", "push", "--all-tags", fmt.Sprintf("%s/api-app", builder.repository())),
+	), sideEffects)
+
+	assert.Equal(t, map[string]string{
+		"NODE_ENV":  "${{ env.NODE_ENV }}",
+		"npm_token": "${{ secrets.npm-token }}",
+	}, GetArtifactBuildEnv(artifact.Type, artifact.Docker.BuildArgs))
+}
+
+func TestBuildMultiPlatformAppArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("api", "packages/api")
+	artifact.Platforms = []string{"linux/amd64", "linux/arm64"}
+	artifact.Docker.Targets.SkipTest = true
+	commitTag := artifact.AppImageName("currentSha")
+	greenTag := artifact.AppImageName("latest-green")
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "buildx", "build",
+			"-f", "packages/api/Dockerfile",
+			"--platform", "linux/amd64,linux/arm64",
+			"--target", "app",
+			"-t", commitTag, "-t", greenTag, "--push",
+			"packages/api",
+		),
+	), sideEffects)
+
+	artifact.Verify = true
+	artifact.VerifyBuildApp = true
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "buildx", "build",
+			"-f", "packages/api/Dockerfile",
+			"--platform", "linux/amd64,linux/arm64",
+			"--target", "app",
+			"packages/api",
+		),
+	), sideEffects)
+
+	artifact.Verify = false
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("oras", "resolve", greenTag).SetVars(DigestVars{Name: "API_DIGEST"}),
+		NewCommand("oras", "tag", artifact.AppImageBase()+"@$API_DIGEST", "currentSha"),
+	), sideEffects)
+
+	assert.Equal(t, []string{"Checkout Repo", "Setup Go", "Authenticate to GCloud via Service Account",
+		"Configure GCloud SDK", "Configure Docker", "Setup ORAS", "Setup QEMU", "Setup Docker Buildx", "Build api"},
+		stepNames(artifact.GetSteps("./cmd/build", "pipeline.yaml")))
+}
+
+func TestDockerOptionsValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Artifacts: []ArtifactConfig{
+			{
+				Id:   "api",
+				Path: "packages/api",
+				Docker: DockerOptions{BuildArgs: []RuntimeArg{
+					{Key: "GIT_SHA", Value: "{{ sha }}"},
+					{Key: "ENDPOINT", StepOutput: "infra.endpoint"},
+				}},
+			},
+			{Id: "pkg", Path: ".", Type: artifactTypeLib, Docker: DockerOptions{Targets: DockerTargets{SkipTest: true}}},
+			{Id: "cli", Path: "cmd/cli", Type: artifactTypeGoBinary, Docker: DockerOptions{Context: "."}},
+		},
+	}
+
+	errs := ValidateArtifacts(NewValidationErrors(""), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("artifacts").
+				PutChild(NewValidationErrors("api").
+					PutChild(NewValidationErrors("docker").
+						PutChild(NewValidationErrors("buildArgs").
+							PutChild(NewValidationErrors("1").
+								Put("value", fmt.Errorf("only value, envValue and secretValue apply to build args")))))).
+				PutChild(NewValidationErrors("pkg").
+					PutChild(NewValidationErrors("docker").
+						PutChild(NewValidationErrors("targets").
+							Put("skipTest", fmt.Errorf("lib artifacts only build the test target"))))).
+				PutChild(NewValidationErrors("cli").
+					Put("docker", fmt.Errorf("only applies to app and lib artifacts")))),
+		errs,
+	)
+}
+
+func TestBuildCachedAppArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("api", "packages/api")
+	artifact.Docker.Cache = dockerCacheRegistry
+	commitTag := artifact.AppImageName("currentSha")
+	greenTag := artifact.AppImageName("latest-green")
+	testCache := fmt.Sprintf("type=registry,ref=%s/api-app:buildcache-test", builder.repository())
+	appCache := fmt.Sprintf("type=registry,ref=%s/api-app:buildcache-app", builder.repository())
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "buildx", "build",
+			"-f", "packages/api/Dockerfile",
+			"--target", "test",
+			"--cache-from", testCache, "--cache-from", appCache, "--cache-to", testCache+",mode=max",
+			"-t", artifact.VerifyImageName(), "--load",
+			"packages/api",
+		),
+		NewCommand("docker", "run", "--rm", artifact.VerifyImageName()),
+		NewCommand("docker", "buildx", "build",
+			"-f", "packages/api/Dockerfile",
+			"--target", "app",
+			"--cache-from", testCache, "--cache-from", appCache, "--cache-to", appCache+",mode=max",
+			"-t", commitTag, "-t", greenTag, "--push",
+			"packages/api",
+		),
+	), sideEffects)
+
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("oras", "resolve", greenTag).SetVars(DigestVars{Name: "API_DIGEST"}),
+		NewCommand("oras", "tag", artifact.AppImageBase()+"@$API_DIGEST", "currentSha"),
+	), sideEffects)
+}
+
+func TestBuildGhaCachedLibArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("pkg", ".")
+	artifact.Type = artifactTypeLib
+	artifact.Docker.Cache = dockerCacheGha
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "buildx", "build",
+			"-f", "./Dockerfile",
+			"--target", "test",
+			"--cache-from", "type=gha,scope=pkg-test", "--cache-from", "type=gha,scope=pkg-app",
+			"--cache-to", "type=gha,scope=pkg-test,mode=max",
+			"-t", artifact.VerifyImageName(), "--load",
+			".",
+		),
+		NewCommand("docker", "run", "--rm", artifact.VerifyImageName()),
+	), sideEffects)
+
+	assert.Equal(t, []string{"Checkout Repo", "Setup Go", "Authenticate to GCloud via Service Account",
+		"Setup Docker Buildx", "Expose GitHub Actions Runtime", "Build pkg"},
+		stepNames(artifact.GetSteps("./cmd/build", "pipeline.yaml")))
+}
+
+func TestGhaCacheUnsupportedTarget(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	config.Artifacts[0].Docker.Cache = dockerCacheGha
+
+	_, err = ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetCircleci)
+
+	assert.EqualError(t, err, "gha docker cache is not supported for target 'circleci'")
+}
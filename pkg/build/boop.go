@@ -1,67 +1,84 @@
 package build
 
+// StepProvider resolves the setup steps for one kind of resource,
+// where StepDto is the step type of a CI system such as GitHubActionsStep.
 type StepProvider[StepDto any] interface {
 	ResolveSetupSteps(secretConfigs []SecretConfig) []StepDto
 	Validate(ValidationErrors) ValidationErrors
 }
 
 type SecretProvider1[StepDto any] interface {
-	ResolveSetupSteps(secretConfigs []SecretConfig) []StepDto
-	Validate(ValidationErrors) ValidationErrors
+	StepProvider[StepDto]
+	ResolveRuntimeArgs(secretConfigs []SecretConfig) []RuntimeArg
 }
 
 type CloudProvider1[StepDto any] interface {
-	ResolveSetupSteps(secretConfigs []SecretConfig) []StepDto
-	Validate(ValidationErrors) ValidationErrors
+	StepProvider[StepDto]
 }
 
 type ArtifactRepositoryProvider[StepDto any] interface {
-	ResolveSetupSteps(secretConfigs []SecretConfig) []StepDto
-	Validate(ValidationErrors) ValidationErrors
+	StepProvider[StepDto]
 }
 
 type KubernetesProvider[StepDto any] interface {
-	ResolveSetupSteps(secretConfigs []SecretConfig) []StepDto
-	Validate(ValidationErrors) ValidationErrors
+	StepProvider[StepDto]
 }
 
+// ProviderFactory creates the providers for a CI system. Getters return nil for unsupported types.
 type ProviderFactory[StepDto any] interface {
-	GetCloudProvider() CloudProvider1[StepDto]
-	GetSecretProvider(t SecretProviderType) SecretProvider1[StepDto]
-	GetArtifactRepositoryProvider(t string) ArtifactRepositoryProvider[StepDto]
-	GetKubernetesProvider(t string) KubernetesProvider[StepDto]
+	GetCloudProvider(config CloudProviderConfig) CloudProvider1[StepDto]
+	GetSecretProvider(config SecretProviderConfig) SecretProvider1[StepDto]
+	GetArtifactRepositoryProvider(config ArtifactRepository) ArtifactRepositoryProvider[StepDto]
+	GetKubernetesProvider(config ClusterConfig) KubernetesProvider[StepDto]
 }
 
-func parseConfig1(args ActionArgs, cd ChangeDetection) {
-	config, err := readFile(args.ConfigPath)
-	if err != nil {
+func ResolveCloudProviderSteps[StepDto any](factory ProviderFactory[StepDto], config CloudProviderConfig) []StepDto {
+	provider := factory.GetCloudProvider(config)
+	if provider == nil {
 		panic("😎")
 	}
+	return provider.ResolveSetupSteps(nil)
+}
 
-	validationErrors := config.Validate("")
-	if validationErrors.IsPresent() {
-		panic("😎")
+func ResolveArtifactRepositorySteps[StepDto any](factory ProviderFactory[StepDto], config ArtifactRepository) []StepDto {
+	provider := factory.GetArtifactRepositoryProvider(config)
+	if provider == nil {
+		t, _ := ArtifactRepositoryTypeEnum.ToString(config.Type)
+		panic(t + "is not a valid type")
 	}
-	//buildType := "github"
-
-	//if buildType == "github" {
-	//	factory := GithubActionsFactory{config: config}
-	//
-	//	cloudProvider := factory.GetCloudProviderSteps(config.Resources.CloudProvider.Type)
-	//	artifactRepositoryProvider := factory.GetArtifactRepositoryProvider()
-	//
-	//	setupSteps := append(
-	//		[]GitHubActionsStep{CheckoutRepoStep(), SetupGoStep()},
-	//		cloudProvider.GetSetupSteps()...,
-	//	)
-	//
-	//} else if buildType == "circleCi" {
-	//	factory := CircleCiProviderFactory{config: config}
-	//	cloudProvider := factory.GetCloudProviderSteps(config.Resources.CloudProvider.Type)
-	//
-	//	blah := cloudProvider.GetSetupSteps()
-	//}
+	return provider.ResolveSetupSteps(nil)
+}
 
+func ResolveKubernetesSteps[StepDto any](factory ProviderFactory[StepDto], config ClusterConfig) []StepDto {
+	provider := factory.GetKubernetesProvider(config)
+	if provider == nil {
+		panic("🍕")
+	}
+	return provider.ResolveSetupSteps(nil)
 }
 
-type CircleCiStep struct{}
+// ResolveSecrets returns the setup steps and runtime args for secrets across every secret provider.
+func ResolveSecrets[StepDto any](
+	factory ProviderFactory[StepDto],
+	providerConfigs SecretProviderConfigs,
+	secretConfigs []SecretConfig,
+) ([]StepDto, []RuntimeArg) {
+	var steps []StepDto
+	var runtimeArgs []RuntimeArg
+	if len(secretConfigs) == 0 {
+		return steps, runtimeArgs
+	}
+
+	for _, providerConfig := range providerConfigs {
+		if len(providerConfig.SecretNames) == 0 {
+			continue
+		}
+		provider := factory.GetSecretProvider(providerConfig)
+		if provider == nil {
+			panic("👺")
+		}
+		steps = append(steps, provider.ResolveSetupSteps(secretConfigs)...)
+		runtimeArgs = append(runtimeArgs, provider.ResolveRuntimeArgs(secretConfigs)...)
+	}
+	return steps, runtimeArgs
+}
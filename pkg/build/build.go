@@ -1,7 +1,15 @@
 package build
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Build interface {
@@ -16,14 +24,22 @@ func (b NullBuild) Build() (SideEffects, error) {
 type DockerImage struct {
 	Artifact
 	dockerfile string
-	workdir    string
+	context    string
 }
 
 func NewDockerImage(a Artifact) DockerImage {
+	dockerfile := a.Docker.Dockerfile
+	if dockerfile == "" {
+		dockerfile = fmt.Sprintf("%s/Dockerfile", a.Path)
+	}
+	context := a.Docker.Context
+	if context == "" {
+		context = a.Path
+	}
 	return DockerImage{
 		Artifact:   a,
-		dockerfile: fmt.Sprintf("%s/Dockerfile", a.Path),
-		workdir:    a.Path,
+		dockerfile: dockerfile,
+		context:    context,
 	}
 }
 
@@ -31,36 +47,143 @@ func (b DockerImage) Build() (SideEffects, error) {
 	commitTag := b.AppImageName(b.CurrentSha)
 	greenTag := b.AppImageName(b.GreenTag())
 
+	if b.Verify {
+		return b.verify(), nil
+	}
+
 	if b.hasChanged {
-		return NewSideEffects(
-			// tests
-			NewCommand("docker", "build",
-				"-f", b.dockerfile,
-				"-t", b.VerifyImageName(),
-				"--target", b.VerifyTarget(),
-				b.workdir,
-			),
-			NewCommand("docker", "run", "--rm", b.VerifyImageName()),
+		sideEffects := b.test()
+		if b.usesBuildx() {
+			// buildx pushes both tags itself, since manifest lists and images in a container builder can't be tagged locally
+			push := append(b.Docker.attestationArgs(), "-t", commitTag, "-t", greenTag, "--push")
+			return sideEffects.Add(b.buildx(b.AppTarget(), b.Platforms, push...)).Add(b.sign()...), nil
+		}
+		return sideEffects.Add(
 			//app
-			NewCommand("docker", "build",
-				"-f", b.dockerfile,
-				"-t", b.AppImageName(b.CurrentSha),
-				"--target", b.AppTarget(),
-				b.workdir,
-			),
+			b.build(b.AppTarget(), b.AppImageName(b.CurrentSha)),
 			NewCommand("docker", "tag", commitTag, greenTag),
 			NewCommand("docker", "push",
 				"--all-tags",
 				b.AppImageBase(),
 			),
-		), nil
-	} else {
-		return NewSideEffects(
-			NewCommand("docker", "pull", greenTag),
-			NewCommand("docker", "tag", greenTag, commitTag),
-			NewCommand("docker", "push", commitTag),
-		), nil
+		).Add(b.sign()...), nil
+	}
+	// the green manifest is resolved to its digest and tagged in the registry, so no layers are pulled or pushed,
+	// its signature and attestations still apply, and the commit tag is the digest that was promoted even if
+	// latest-green moves meanwhile
+	return NewSideEffects(
+		NewCommand("oras", "resolve", greenTag).SetVars(DigestVars{Name: digestVar(b.Id)}),
+		NewCommand("oras", "tag", fmt.Sprintf("%s@$%s", b.AppImageBase(), digestVar(b.Id)), b.CurrentSha),
+	), nil
+}
+
+// verify builds and runs the test image, and optionally builds the app image, without pushing or retagging.
+func (b DockerImage) verify() SideEffects {
+	if !b.hasChanged {
+		return NewSideEffects()
+	}
+
+	sideEffects := b.test()
+	if b.VerifyBuildApp {
+		if b.usesBuildx() {
+			return sideEffects.Add(b.buildx(b.AppTarget(), b.Platforms))
+		}
+		sideEffects.Commands = append(sideEffects.Commands, b.build(b.AppTarget(), b.AppImageName(b.CurrentSha)))
+	}
+	return sideEffects
+}
+
+// test builds and runs the test image for the runner's platform, unless the test target is skipped.
+func (b DockerImage) test() SideEffects {
+	if b.Docker.Targets.SkipTest {
+		return NewSideEffects()
+	}
+	build := b.build(b.VerifyTarget(), b.VerifyImageName())
+	if b.usesBuildx() {
+		build = b.buildx(b.VerifyTarget(), nil, "-t", b.VerifyImageName(), "--load")
+	}
+	return NewSideEffects(
+		// tests
+		build,
+		NewCommand("docker", "run", "--rm", b.VerifyImageName()),
+	)
+}
+
+func (b DockerImage) build(target string, tag string) Command {
+	return NewCommand("docker", "build",
+		"-f", b.dockerfile,
+		"-t", tag,
+		"--target", target,
+	).Add(b.buildArgs()...).Add(b.context).SetExpandArgs(b.expandsBuildArgs())
+}
+
+// buildx builds a target for the given platforms, or the runner's. Without --push or --load the result is only cached.
+func (b DockerImage) buildx(target string, platforms []string, args ...string) Command {
+	build := NewCommand("docker", "buildx", "build", "-f", b.dockerfile)
+	if len(platforms) > 0 {
+		build = build.Add("--platform", strings.Join(platforms, ","))
+	}
+	return build.Add("--target", target).
+		Add(b.buildArgs()...).
+		Add(b.cacheArgs(target)...).
+		Add(args...).
+		Add(b.context).
+		SetExpandArgs(b.expandsBuildArgs())
+}
+
+// buildArgs set literal values, escaped when the arguments are expanded, and reference the env holding the others. Secrets are read from env by BuildKit,
+// so they aren't in the image history.
+func (b DockerImage) buildArgs() []string {
+	var args []string
+	for _, arg := range b.Docker.BuildArgs {
+		switch {
+		case arg.SecretValue != "":
+			args = append(args, "--secret", fmt.Sprintf("id=%s,env=%s", arg.Key, arg.EnvKey()))
+		case arg.IsLiteral():
+			value := arg.Value
+			if b.expandsBuildArgs() {
+				value = escapeDollars(value)
+			}
+			args = append(args, "--build-arg", fmt.Sprintf("%s=%s", arg.Key, value))
+		default:
+			args = append(args, "--build-arg", fmt.Sprintf("%s=$%s", arg.Key, arg.EnvKey()))
+		}
+	}
+	return args
+}
+
+// expandsBuildArgs is true when build args reference env, which docker only reads from --build-arg values.
+func (b DockerImage) expandsBuildArgs() bool {
+	for _, arg := range b.Docker.BuildArgs {
+		if arg.EnvValue != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheArgs import the caches of both targets, and export to the built target's own cache, so that the test and
+// app builds don't overwrite each other's. mode=max exports the layers of every stage, not just the target's.
+func (b DockerImage) cacheArgs(target string) []string {
+	if b.Docker.Cache != dockerCacheRegistry && b.Docker.Cache != dockerCacheGha {
+		return nil
+	}
+	var args []string
+	for _, t := range []string{b.VerifyTarget(), b.AppTarget()} {
+		args = append(args, "--cache-from", b.cache(t))
+	}
+	return append(args, "--cache-to", b.cache(target)+",mode=max")
+}
+
+func (b DockerImage) cache(target string) string {
+	if b.Docker.Cache == dockerCacheGha {
+		return fmt.Sprintf("type=gha,scope=%s-%s", b.Id, target)
 	}
+	return fmt.Sprintf("type=registry,ref=%s", b.CacheRef(target))
+}
+
+func (b DockerImage) usesBuildx() bool {
+	return b.Docker.UsesBuildx(b.Platforms)
 }
 
 type HelmDeployment struct {
@@ -74,22 +197,315 @@ func NewHelm(a Application) HelmDeployment {
 }
 
 func (b HelmDeployment) Build() (SideEffects, error) {
-	deploy := NewCommand("helm", "upgrade", b.Id, b.Path,
-		"--install",
-		"--atomic",
-		"--namespace", b.Namespace,
+	values := []string{"--namespace", b.Namespace}
+	for _, path := range b.ValuesFiles {
+		values = append(values, "-f", path)
+	}
+	values = append(values,
 		"--set", fmt.Sprintf("repo=%s", b.Repository),
 		"--set", fmt.Sprintf("tag=%s", b.CurrentSha),
 	)
 
+	var files []File
+	if b.RenderValues {
+		file, err := b.valuesFile()
+		if err != nil {
+			return SideEffects{}, err
+		}
+		files = append(files, file)
+		values = append(values, "-f", "$"+file.Var)
+	}
 	for _, arg := range b.RuntimeArgs {
-		deploy = deploy.Add("--set", fmt.Sprintf("%s=$%s", arg.Key, arg.EnvKey()))
+		if b.inValuesFile(arg) {
+			continue
+		}
+		values = append(values, arg.HelmFlag(), fmt.Sprintf("%s=%s", arg.Key, b.setValue(arg)))
 	}
+	values = append(values, b.imageFlags()...)
 
-	return NewSideEffects(
-		NewCommand("helm", "dep", "update"),
-		deploy,
-	), nil
+	release := b.Helm.Release(b.Id)
+	sideEffects := NewSideEffects().AddFile(files...)
+	if b.isLocalChart() {
+		sideEffects = sideEffects.Add(NewCommand("helm", "dep", "update", b.Path))
+	}
+	sideEffects = sideEffects.Add(b.resolveDigests(b.digestIds())...)
+
+	if b.Verify || b.Drift {
+		if b.isLocalChart() {
+			sideEffects = sideEffects.Add(NewCommand("helm", "lint", b.Path).Add(values...).SetExpandArgs(b.expandsValues()))
+		}
+		return sideEffects.Add(
+			NewCommand("helm", "template", release).Add(b.chartArgs()...).Add(values...).SetExpandArgs(b.expandsValues()),
+		), nil
+	}
+
+	strategy := b.Helm.Strategy
+	switch strategy.Type {
+	case helmStrategyCanary:
+		canary := release + "-canary"
+		weight := fmt.Sprintf("%s=%d", strategy.CanaryWeightValue(), strategy.CanaryWeight())
+		return sideEffects.
+			Add(b.upgrade(canary, append(values, "--set", weight)...)).
+			Add(b.test(canary)...).
+			Add(strategy.Check.Commands()...).
+			Add(
+				b.upgrade(release, values...),
+				NewCommand("helm", "uninstall", canary, "--namespace", b.Namespace),
+			).
+			AddOnFailure(NewCommand("helm", "uninstall", canary, "--namespace", b.Namespace)), nil
+	case helmStrategyBlueGreen:
+		idle := fmt.Sprintf("%s-$%s", release, idleSlotVar)
+		return sideEffects.
+			Add(
+				b.serviceExists(),
+				b.liveSlot(),
+				b.upgrade(idle, append(values, "--set", fmt.Sprintf("%s=$%s", strategy.Slot(), idleSlotVar))...),
+			).
+			Add(b.test(idle)...).
+			Add(strategy.Check.Commands()...).
+			Add(b.flipSlot()), nil
+	default:
+		return sideEffects.Add(b.upgrade(release, values...)).Add(b.test(release)...), nil
+	}
+}
+
+// upgrade installs or upgrades a release, rolling it back if it fails.
+func (b HelmDeployment) upgrade(release string, values ...string) Command {
+	deploy := NewCommand("helm", "upgrade", release).
+		Add(b.chartArgs()...).
+		Add("--install", "--atomic")
+	if b.Helm.Timeout != "" {
+		deploy = deploy.Add("--timeout", b.Helm.Timeout)
+	}
+	if b.Helm.WaitForJobs {
+		deploy = deploy.Add("--wait-for-jobs")
+	}
+	if b.Helm.HistoryMax > 0 {
+		deploy = deploy.Add("--history-max", strconv.Itoa(b.Helm.HistoryMax))
+	}
+	if b.Helm.CreateNamespace {
+		deploy = deploy.Add("--create-namespace")
+	}
+	return deploy.Add(values...).SetExpandArgs(b.expandsValues())
+}
+
+// expandsValues is true when values set on the command line reference env, since Helm only reads them from its
+// arguments. Rendering values keeps them out of the arguments.
+func (b HelmDeployment) expandsValues() bool {
+	for _, arg := range b.RuntimeArgs {
+		if arg.IsEnv() && !b.inValuesFile(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b HelmDeployment) test(release string) []Command {
+	if !b.Helm.Test {
+		return nil
+	}
+	test := NewCommand("helm", "test", release, "--namespace", b.Namespace)
+	if b.Helm.Timeout != "" {
+		test = test.Add("--timeout", b.Helm.Timeout)
+	}
+	return []Command{test}
+}
+
+// serviceExists fails before deploying when the blue/green Service is missing, since it can't be flipped to either slot.
+func (b HelmDeployment) serviceExists() Command {
+	return NewCommand("kubectl", "get", "service", b.Helm.Strategy.Service, "--namespace", b.Namespace,
+		"--ignore-not-found", "--output", "name").
+		SetCheck(ServiceCheck{Service: b.Helm.Strategy.Service, Namespace: b.Namespace})
+}
+
+// ServiceCheck fails when kubectl prints nothing for the Service, which it does with --ignore-not-found when it's missing.
+type ServiceCheck struct {
+	Service   string
+	Namespace string
+}
+
+func (s ServiceCheck) Check(output string) error {
+	if strings.TrimSpace(output) == "" {
+		return fmt.Errorf("service %s must exist in namespace %s before the first blue/green deploy", s.Service, s.Namespace)
+	}
+	return nil
+}
+
+// liveSlot reads the slot the blue/green Service selects, setting the other as the idle slot.
+func (b HelmDeployment) liveSlot() Command {
+	return NewCommand("kubectl", "get", "service", b.Helm.Strategy.Service, "--namespace", b.Namespace,
+		"--output", fmt.Sprintf("jsonpath={.spec.selector.%s}", b.Helm.Strategy.Slot())).
+		SetVars(SlotVars{})
+}
+
+// flipSlot points the blue/green Service at the idle slot.
+func (b HelmDeployment) flipSlot() Command {
+	selector := fmt.Sprintf(`{"spec":{"selector":{"%s":"$%s"}}}`, b.Helm.Strategy.Slot(), idleSlotVar)
+	return NewCommand("kubectl", "patch", "service", b.Helm.Strategy.Service, "--namespace", b.Namespace,
+		"--type", "merge", "--patch", selector)
+}
+
+const (
+	slotBlue    = "blue"
+	slotGreen   = "green"
+	idleSlotVar = "IDLE_SLOT"
+)
+
+// SlotVars sets the slot a blue/green Service doesn't select as the idle slot. Until it selects one, blue is deployed first.
+type SlotVars struct{}
+
+func (s SlotVars) Vars(output string) map[string]string {
+	if output == slotBlue {
+		return map[string]string{idleSlotVar: slotGreen}
+	}
+	return map[string]string{idleSlotVar: slotBlue}
+}
+
+// DigestVars sets a variable to the digest printed by oras resolve. When nothing is printed, as in dry runs,
+// references to the variable are left as they are.
+type DigestVars struct {
+	Name string
+}
+
+func (d DigestVars) Vars(output string) map[string]string {
+	digest := strings.TrimSpace(output)
+	if digest == "" {
+		return nil
+	}
+	return map[string]string{d.Name: digest}
+}
+
+// digestVar names the variable holding the digest of an artifact's app image.
+func digestVar(artifactId string) string {
+	return strings.ToUpper(strings.ReplaceAll(artifactId, "-", "_")) + "_DIGEST"
+}
+
+// pinsDigests is true when artifact images are deployed by digest, either as configured or to deploy the digests
+// signatures were verified for. Verifying and checking for drift only render the chart, so they keep the commit tag
+// rather than reading the registry.
+func (b HelmDeployment) pinsDigests() bool {
+	return (b.Helm.PinDigests || b.RequireSignedImages) && !b.Verify && !b.Drift
+}
+
+// digestIds are the artifacts whose digests are resolved before deploying. SignatureChecks already resolve every
+// artifact image, so they aren't read again.
+func (b HelmDeployment) digestIds() []string {
+	if b.Verify || b.Drift || b.verifiesSignatures() {
+		return nil
+	}
+	if b.ImageValues {
+		return b.artifactImageIds()
+	}
+	if b.pinsDigests() {
+		return b.valueImageIds(nil)
+	}
+	return nil
+}
+
+// imageFlags set images.<id>.repository, tag and digest for each artifact image, when imageValues is set.
+func (b HelmDeployment) imageFlags() []string {
+	if !b.ImageValues {
+		return nil
+	}
+	var flags []string
+	values := b.imageValues()
+	for _, id := range sortedKeys(values) {
+		value := values[id]
+		flags = append(flags,
+			"--set", fmt.Sprintf("images.%s.repository=%s", id, value.Repository),
+			"--set", fmt.Sprintf("images.%s.tag=%s", id, value.Tag),
+			"--set", fmt.Sprintf("images.%s.digest=%s", id, value.Digest),
+		)
+	}
+	return flags
+}
+
+// setValue pins artifact images to their resolved digest, when pinning. Literal values are escaped when
+// the arguments are expanded, so that Helm is given them as they are.
+func (b HelmDeployment) setValue(arg RuntimeArg) string {
+	if arg.ArtifactImage != "" && b.pinsDigests() {
+		return b.pinnedImage(arg.ArtifactImage)
+	}
+	if b.expandsValues() {
+		return b.expandedValue(arg)
+	}
+	return b.runtimeValue(arg)
+}
+
+// inValuesFile is true for the values rendered into the values file. Files are always set on the command line,
+// as are pinned images, since their digests are only known once resolved.
+func (b HelmDeployment) inValuesFile(arg RuntimeArg) bool {
+	if !b.RenderValues || arg.HelmFlag() == helmSetFile {
+		return false
+	}
+	return arg.ArtifactImage == "" || !b.pinsDigests()
+}
+
+func (b HelmDeployment) isLocalChart() bool {
+	return b.Helm.Chart == ""
+}
+
+// chartArgs references either the chart at the application path, or a remote chart.
+func (b HelmDeployment) chartArgs() []string {
+	if b.isLocalChart() {
+		return []string{b.Path}
+	}
+	args := []string{b.Helm.Chart}
+	if b.Helm.Repository != "" {
+		args = append(args, "--repo", b.Helm.Repository)
+	}
+	if b.Helm.Version != "" {
+		args = append(args, "--version", b.Helm.Version)
+	}
+	return args
+}
+
+// valuesFile renders runtime args, except files, into a values file nested by key.
+// setString values are tagged so that they stay strings once expanded.
+func (b HelmDeployment) valuesFile() (File, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, arg := range b.RuntimeArgs {
+		if !b.inValuesFile(arg) {
+			continue
+		}
+		parent := root
+		keys := strings.Split(arg.Key, ".")
+		for _, key := range keys[:len(keys)-1] {
+			parent = mappingChild(parent, key)
+		}
+		leaf := &yaml.Node{Kind: yaml.ScalarNode, Value: b.expandedValue(arg)}
+		if arg.HelmFlag() == helmSetString {
+			leaf.Tag = "!!str"
+			leaf.Style = yaml.TaggedStyle
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: keys[len(keys)-1]}, leaf)
+	}
+
+	content, err := yaml.Marshal(root)
+	if err != nil {
+		return File{}, err
+	}
+	return File{
+		Path:    fmt.Sprintf("%s-values-*.yaml", b.Id),
+		Var:     valuesFileVar(b.Id),
+		Content: string(content),
+	}, nil
+}
+
+// valuesFileVar names the variable holding the path of an application's values file.
+func valuesFileVar(applicationId string) string {
+	return strings.ToUpper(strings.ReplaceAll(applicationId, "-", "_")) + "_VALUES_FILE"
+}
+
+func mappingChild(parent *yaml.Node, key string) *yaml.Node {
+	for i := 0; i < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == key && parent.Content[i+1].Kind == yaml.MappingNode {
+			return parent.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
 }
 
 type TfConfig struct {
@@ -102,11 +518,378 @@ func NewTerraform(a Application) TfConfig {
 	}
 }
 
+const (
+	terraformPhasePlan  = "plan"
+	terraformPhaseApply = "apply"
+	terraformPlanFile   = "plan.out"
+	// imagesVar is the Terraform variable holding image values, a map of artifact id to repository, tag and digest
+	imagesVar = "images"
+)
+
 func (b TfConfig) Build() (SideEffects, error) {
 	chdir := fmt.Sprintf("-chdir=%s", b.Path)
-	return NewSideEffects(
-		NewCommand("terraform", chdir, "init"),
-		NewCommand("terraform", chdir, "plan", "-out=plan.out"),
-		NewCommand("terraform", chdir, "apply", "plan.out"),
+	init := NewCommand("terraform", chdir, "init")
+	for _, key := range sortedKeys(b.Terraform.BackendConfig) {
+		init = init.Add(fmt.Sprintf("-backend-config=%s=%s", key, b.Terraform.BackendConfig[key]))
+	}
+	sideEffects := NewSideEffects(init)
+	if b.Terraform.Workspace != "" {
+		sideEffects = sideEffects.Add(NewCommand("terraform", chdir, "workspace", "select", "-or-create", b.Terraform.Workspace))
+	}
+	// applying a saved plan doesn't read variables, drift checks compare against the deployed digests,
+	// and SignatureChecks have already resolved the digests they verified
+	if b.ImageValues && !b.Verify && b.Phase != terraformPhaseApply && !b.verifiesSignatures() {
+		sideEffects = sideEffects.Add(b.resolveDigests(b.artifactImageIds())...)
+	}
+
+	if b.Verify || b.Drift {
+		return sideEffects.Add(b.plan(chdir, "-lock=false", "-out="+terraformPlanFile)).
+			Add(b.show(chdir, PlanCheck{ApplicationId: b.Id, Drift: b.Drift})...), nil
+	}
+
+	check := b.show(chdir, PlanCheck{ApplicationId: b.Id, PreventDestroy: b.Terraform.PreventDestroy && !b.AllowDestroy})
+	apply := NewCommand("terraform", chdir, "apply", terraformPlanFile)
+	switch b.Phase {
+	case "":
+		return sideEffects.Add(b.plan(chdir, "-out="+terraformPlanFile)).Add(check...).Add(apply), nil
+	case terraformPhasePlan:
+		return sideEffects.Add(b.plan(chdir, "-out="+terraformPlanFile)).Add(check...), nil
+	case terraformPhaseApply:
+		return sideEffects.Add(check...).Add(apply), nil
+	default:
+		return SideEffects{}, fmt.Errorf("unknown phase %s", b.Phase)
+	}
+}
+
+// show summarizes the saved plan, then checks it, failing the deploy when the check doesn't pass.
+// The summary is written first, so that it's there to explain a failed check.
+func (b TfConfig) show(chdir string, check PlanCheck) []Command {
+	show := NewCommand("terraform", chdir, "show", "-json", terraformPlanFile)
+	return []Command{show.SetVars(check), WritePlanSummary(), show.SetCheck(check)}
+}
+
+// plan passes runtime args as TF_VAR_ variables, named by their env key.
+func (b TfConfig) plan(chdir string, args ...string) Command {
+	plan := NewCommand("terraform", chdir, "plan").Add(args...)
+	for _, path := range b.Terraform.VarFiles {
+		plan = plan.Add("-var-file=" + path)
+	}
+	// env values are expanded when the command is run
+	for _, arg := range b.RuntimeArgs {
+		plan = plan.SetEnv("TF_VAR_"+arg.EnvKey(), b.expandedValue(arg))
+	}
+	if b.ImageValues {
+		images, _ := json.Marshal(b.imageValues())
+		plan = plan.SetEnv("TF_VAR_"+imagesVar, string(images))
+	}
+	return plan
+}
+
+// ManifestDeployment applies a kustomize overlay, or a directory of plain manifests through a generated kustomization,
+// with server-side apply.
+type ManifestDeployment struct {
+	Application
+}
+
+func NewManifests(a Application) ManifestDeployment {
+	return ManifestDeployment{
+		Application: a,
+	}
+}
+
+func (b ManifestDeployment) Build() (SideEffects, error) {
+	sideEffects := NewSideEffects()
+	if b.Type == applicationTypeKubectl {
+		file, err := b.kustomization()
+		if err != nil {
+			return SideEffects{}, err
+		}
+		sideEffects = sideEffects.AddFile(file)
+	}
+
+	if b.Verify || b.Drift {
+		return sideEffects.Add(NewCommand("kubectl", "kustomize", b.Path)), nil
+	}
+
+	for _, id := range b.Artifacts {
+		artifact := Artifact{Id: id, Repository: b.Repository}
+		image := artifact.AppImageName(b.CurrentSha)
+		if b.verifiesSignatures() {
+			image = b.pinnedImage(id)
+		}
+		sideEffects = sideEffects.Add(NewCommand("kustomize", "edit", "set", "image",
+			fmt.Sprintf("%s=%s", artifact.AppImageBase(), image),
+		).SetDir(b.Path))
+	}
+
+	apply := NewCommand("kubectl", "apply", "--server-side", "-k", b.Path)
+	if b.Namespace != "" {
+		apply = apply.Add("--namespace", b.Namespace)
+	}
+	if selector := b.Manifests.PruneSelector(); selector != "" {
+		apply = apply.Add("--prune", "-l", selector)
+	}
+	sideEffects = sideEffects.Add(apply)
+
+	for _, resource := range b.Manifests.Rollouts {
+		rollout := NewCommand("kubectl", "rollout", "status", resource)
+		if b.Namespace != "" {
+			rollout = rollout.Add("--namespace", b.Namespace)
+		}
+		if b.Manifests.Timeout != "" {
+			rollout = rollout.Add("--timeout", b.Manifests.Timeout)
+		}
+		sideEffects = sideEffects.Add(rollout)
+	}
+	return sideEffects, nil
+}
+
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomization lists the manifests at the application path as resources, so that images can be set with kustomize.
+func (b ManifestDeployment) kustomization() (File, error) {
+	entries, err := os.ReadDir(b.Path)
+	if err != nil {
+		return File{}, err
+	}
+
+	var resources []string
+	for _, entry := range entries {
+		name := entry.Name()
+		for _, kustomization := range kustomizationFiles {
+			if name == kustomization {
+				return File{}, fmt.Errorf("%s has a kustomization, use type kustomize", b.Path)
+			}
+		}
+		extension := filepath.Ext(name)
+		if !entry.IsDir() && (extension == ".yaml" || extension == ".yml" || extension == ".json") {
+			resources = append(resources, name)
+		}
+	}
+	if len(resources) == 0 {
+		return File{}, fmt.Errorf("%s has no manifests", b.Path)
+	}
+
+	content, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	})
+	if err != nil {
+		return File{}, err
+	}
+	return File{
+		Path:    filepath.Join(b.Path, kustomizationFiles[0]),
+		Content: string(content),
+	}, nil
+}
+
+// LibPackage only runs the tests in the verify target of its Dockerfile, since nothing is published.
+type LibPackage struct {
+	DockerImage
+}
+
+func NewLib(a Artifact) LibPackage {
+	return LibPackage{
+		DockerImage: NewDockerImage(a),
+	}
+}
+
+func (b LibPackage) Build() (SideEffects, error) {
+	b.VerifyBuildApp = false
+	return b.verify(), nil
+}
+
+const distDir = "dist"
+
+// GoBinary tests and cross-compiles a Go main package, pushing the binaries to the artifact repository with oras.
+type GoBinary struct {
+	Artifact
+}
+
+func NewGoBinary(a Artifact) GoBinary {
+	return GoBinary{
+		Artifact: a,
+	}
+}
+
+func (b GoBinary) Build() (SideEffects, error) {
+	commitRef := b.AppImageName(b.CurrentSha)
+	if !b.hasChanged {
+		if b.Verify {
+			return NewSideEffects(), nil
+		}
+		return NewSideEffects(NewCommand("oras", "tag", b.AppImageName(b.GreenTag()), b.CurrentSha)), nil
+	}
+
+	sideEffects := NewSideEffects(NewCommand("go", "test", b.goPackage()+"/..."))
+	if b.Verify && !b.VerifyBuildApp {
+		return sideEffects, nil
+	}
+
+	var binaries []string
+	for _, platform := range b.platforms() {
+		goos, goarch, _ := strings.Cut(platform, "/")
+		binary := path.Join(distDir, b.Id, fmt.Sprintf("%s-%s-%s", b.Id, goos, goarch))
+		if goos == "windows" {
+			binary += ".exe"
+		}
+		binaries = append(binaries, binary)
+		sideEffects = sideEffects.Add(NewCommand("go", "build", "-o", binary, b.goPackage()).
+			SetEnv("CGO_ENABLED", "0").
+			SetEnv("GOARCH", goarch).
+			SetEnv("GOOS", goos))
+	}
+	if b.Verify {
+		return sideEffects, nil
+	}
+
+	return sideEffects.Add(
+		NewCommand("oras", "push", commitRef).Add(binaries...),
+		NewCommand("oras", "tag", commitRef, b.GreenTag()),
 	), nil
 }
+
+// goPackage references the artifact path as a package of the module at the repository root.
+func (b GoBinary) goPackage() string {
+	cleaned := filepath.ToSlash(filepath.Clean(b.Path))
+	if cleaned == "." {
+		return cleaned
+	}
+	return "./" + cleaned
+}
+
+func (b GoBinary) platforms() []string {
+	if len(b.Platforms) == 0 {
+		return []string{"linux/amd64"}
+	}
+	return b.Platforms
+}
+
+// HelmChart lints and packages a chart, pushing it to the artifact repository as an OCI chart.
+// Besides its chart version, the pushed chart is tagged with the commit, so unchanged charts can be promoted like images.
+type HelmChart struct {
+	Artifact
+}
+
+func NewHelmChart(a Artifact) HelmChart {
+	return HelmChart{
+		Artifact: a,
+	}
+}
+
+type chartMetadata struct {
+	Name    string
+	Version string
+}
+
+func (b HelmChart) Build() (SideEffects, error) {
+	chart, err := b.metadata()
+	if err != nil {
+		return SideEffects{}, err
+	}
+	chartRef := fmt.Sprintf("%s/%s", b.Repository, chart.Name)
+
+	if !b.hasChanged {
+		if b.Verify {
+			return NewSideEffects(), nil
+		}
+		return NewSideEffects(NewCommand("oras", "tag", fmt.Sprintf("%s:%s", chartRef, b.GreenTag()), b.CurrentSha)), nil
+	}
+
+	sideEffects := NewSideEffects(
+		NewCommand("helm", "dep", "update", b.Path),
+		NewCommand("helm", "lint", b.Path),
+	)
+	if b.Verify && !b.VerifyBuildApp {
+		return sideEffects, nil
+	}
+
+	destination := path.Join(distDir, b.Id)
+	sideEffects = sideEffects.Add(NewCommand("helm", "package", b.Path, "--destination", destination, "--app-version", b.CurrentSha))
+	if b.Verify {
+		return sideEffects, nil
+	}
+
+	// OCI tags can't contain +, so helm push replaces it in versions
+	versionTag := strings.ReplaceAll(chart.Version, "+", "_")
+	return sideEffects.Add(
+		NewCommand("helm", "push", path.Join(destination, fmt.Sprintf("%s-%s.tgz", chart.Name, chart.Version)), "oci://"+b.Repository),
+		NewCommand("oras", "tag", fmt.Sprintf("%s:%s", chartRef, versionTag), b.CurrentSha, b.GreenTag()),
+	), nil
+}
+
+func (b HelmChart) metadata() (chartMetadata, error) {
+	content, err := os.ReadFile(filepath.Join(b.Path, "Chart.yaml"))
+	if err != nil {
+		return chartMetadata{}, err
+	}
+	var chart chartMetadata
+	err = yaml.Unmarshal(content, &chart)
+	if err != nil {
+		return chartMetadata{}, err
+	}
+	if chart.Name == "" || chart.Version == "" {
+		return chartMetadata{}, fmt.Errorf("%s/Chart.yaml requires a name and version", b.Path)
+	}
+	return chart, nil
+}
+
+// NpmPackage tests and publishes a package under the green dist-tag, which stays on the last good version while it's unchanged.
+type NpmPackage struct {
+	Artifact
+}
+
+func NewNpmPackage(a Artifact) NpmPackage {
+	return NpmPackage{
+		Artifact: a,
+	}
+}
+
+// Build publishes changed packages as a prerelease of their version for the commit, since registries reject
+// republishing a version, and tags it latest-green. The version is set in a copy of the package, so the checkout
+// is left as it is. Unchanged packages are left alone, since latest-green already has the last good version.
+func (b NpmPackage) Build() (SideEffects, error) {
+	if !b.hasChanged {
+		return NewSideEffects(), nil
+	}
+
+	sideEffects := NewSideEffects(
+		NewCommand("npm", "ci", "--prefix", b.Path),
+		NewCommand("npm", "test", "--prefix", b.Path),
+	)
+	if b.Verify {
+		return sideEffects, nil
+	}
+	// publishing runs in the package's copy, so that it's authenticated by the package's .npmrc
+	publishDir := b.publishDir()
+	remove := NewCommand("rm", "-rf", publishDir)
+	return sideEffects.Add(
+		NewCommand("npm", "pkg", "get", "version").SetDir(b.Path).SetVars(NpmPackageVars{}),
+		NewCommand("cp", "-R", b.Path, publishDir),
+		NewCommand("npm", "version", fmt.Sprintf("${%s}-%s", npmVersionVar, b.CurrentSha), "--no-git-tag-version").
+			SetDir(publishDir),
+		NewCommand("npm", "publish", "--tag", b.GreenTag()).SetDir(publishDir),
+		remove,
+	).AddOnFailure(remove), nil
+}
+
+// publishDir is where the package is copied to publish it. It's next to the package, so that relative
+// file dependencies and links still resolve.
+func (b NpmPackage) publishDir() string {
+	return path.Join(path.Dir(b.Path), "."+path.Base(b.Path)+"-publish")
+}
+
+const npmVersionVar = "NPM_VERSION"
+
+// NpmPackageVars reads the version of a package from the JSON string printed by npm pkg get version.
+// When nothing is printed, as in dry runs, references to it are left as they are.
+type NpmPackageVars struct{}
+
+func (n NpmPackageVars) Vars(output string) map[string]string {
+	var version string
+	if err := json.Unmarshal([]byte(output), &version); err != nil || version == "" {
+		return nil
+	}
+	return map[string]string{npmVersionVar: version}
+}
@@ -0,0 +1,191 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type ChangeDetection interface {
+	HasChanged(paths ...string) bool
+	Describe() string
+}
+
+// ArtifactChangeDetection is implemented by change detection which compares each artifact against its own base.
+type ArtifactChangeDetection interface {
+	ForArtifact(artifact Artifact) ChangeDetection
+}
+
+// SharedChangeDetection is implemented by change detection which counts changes to shared paths as changes to everything.
+type SharedChangeDetection interface {
+	WithSharedWatch(patterns ...string) ChangeDetection
+}
+
+type StaticChangeDetection struct {
+	hasChanged bool
+}
+
+func NewAlwaysChanged() ChangeDetection {
+	return StaticChangeDetection{hasChanged: true}
+}
+
+func NewNeverChanged() ChangeDetection {
+	return StaticChangeDetection{hasChanged: false}
+}
+
+func (c StaticChangeDetection) HasChanged(...string) bool {
+	return c.hasChanged
+}
+
+func (c StaticChangeDetection) Describe() string {
+	if c.hasChanged {
+		return "everything changed"
+	}
+	return "nothing changed"
+}
+
+// BaseRef describes the candidates for the commit to compare HEAD against, in order of precedence.
+type BaseRef struct {
+	Sha        string
+	Branch     string
+	EventPath  string
+	SinceGreen bool
+}
+
+var (
+	nullSha = strings.Repeat("0", 40)
+	shaTag  = regexp.MustCompile("^[0-9a-f]{40}$")
+)
+
+// defaultSharedWatch is watched when the config has no watch patterns of its own, so workflow changes rebuild everything.
+var defaultSharedWatch = []string{".github/"}
+
+type GitChangeDetection struct {
+	baseSha      string
+	source       string
+	sinceGreen   bool
+	paths        []string
+	changedFiles []string
+	runner       CommandRunner
+}
+
+// NewGitChangeDetection resolves the base to compare against. If it can't be resolved,
+// for example in a shallow clone or after a force push, everything is considered changed.
+// A null base sha, which GitLab sets on merge request, scheduled and manual pipelines, gives way to the base branch.
+func NewGitChangeDetection(runner CommandRunner, base BaseRef) GitChangeDetection {
+	g := GitChangeDetection{
+		runner:     runner,
+		sinceGreen: base.SinceGreen,
+		paths:      defaultSharedWatch,
+	}
+
+	switch {
+	case base.Sha != "" && base.Sha != nullSha:
+		return g.withBase(base.Sha, "--base-sha")
+	case base.Branch != "":
+		sha, err := runner.Output("git", "merge-base", "HEAD", "origin/"+base.Branch)
+		if err != nil {
+			return g.withBase("", "merge base with "+base.Branch)
+		}
+		return g.withBase(sha, "merge base with "+base.Branch)
+	case base.Sha != "":
+		// the first push of a new branch has nothing to compare against
+		return g.withBase(base.Sha, "--base-sha")
+	case base.EventPath != "":
+		sha, err := readEventBeforeSha(base.EventPath)
+		if err == nil && sha != "" {
+			return g.withBase(sha, "push event before")
+		}
+	}
+
+	sha, err := runner.Output("git", "rev-list", "-n", "1", "HEAD~1")
+	if err != nil {
+		return g.withBase("", "previous commit")
+	}
+	return g.withBase(sha, "previous commit")
+}
+
+// WithSharedWatch replaces the shared PathFilter patterns whose changes count for every artifact and application.
+func (g GitChangeDetection) WithSharedWatch(patterns ...string) ChangeDetection {
+	g.paths = patterns
+	return g
+}
+
+func (g GitChangeDetection) withBase(sha string, source string) GitChangeDetection {
+	g.source = source
+	g.baseSha = ""
+	g.changedFiles = nil
+	if sha == nullSha || sha == "" || !g.commitExists(sha) {
+		return g
+	}
+
+	// -z separates paths with NUL and doesn't quote them, so paths with spaces or unusual characters stay whole
+	output, err := g.runner.Output("git", "diff", "--name-only", "-z", sha, "HEAD")
+	if err != nil {
+		return g
+	}
+	g.baseSha = sha
+	g.changedFiles = nil
+	for _, path := range strings.Split(output, "\x00") {
+		if path != "" {
+			g.changedFiles = append(g.changedFiles, path)
+		}
+	}
+	return g
+}
+
+func (g GitChangeDetection) commitExists(sha string) bool {
+	return g.runner.RunSilent("git", "cat-file", "-e", sha+"^{commit}") == nil
+}
+
+// ForArtifact compares against the commit whose image was last tagged green, when enabled.
+func (g GitChangeDetection) ForArtifact(artifact Artifact) ChangeDetection {
+	if !g.sinceGreen {
+		return g
+	}
+	output, err := g.runner.Output("gcloud", "container", "images", "list-tags", artifact.AppImageBase(),
+		fmt.Sprintf("--filter=tags:%s", artifact.GreenTag()),
+		"--format=value(tags)",
+	)
+	if err != nil {
+		return g.withBase("", artifact.GreenTag())
+	}
+	for _, tag := range strings.FieldsFunc(output, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n'
+	}) {
+		if shaTag.MatchString(tag) {
+			return g.withBase(tag, artifact.GreenTag())
+		}
+	}
+	return g.withBase("", artifact.GreenTag())
+}
+
+// HasChanged reports whether any file changed since the base matches the given PathFilter patterns.
+func (g GitChangeDetection) HasChanged(patterns ...string) bool {
+	if g.baseSha == "" {
+		return true
+	}
+	return NewPathFilter(g.paths...).MatchAny(g.changedFiles) ||
+		NewPathFilter(patterns...).MatchAny(g.changedFiles)
+}
+
+func (g GitChangeDetection) Describe() string {
+	if g.baseSha == "" {
+		return fmt.Sprintf("everything changed, could not resolve %s", g.source)
+	}
+	return fmt.Sprintf("changes since %s (%s)", g.baseSha, g.source)
+}
+
+func readEventBeforeSha(eventPath string) (string, error) {
+	data, err := os.ReadFile(eventPath)
+	if err != nil {
+		return "", err
+	}
+	var event struct {
+		Before string `json:"before"`
+	}
+	err = json.Unmarshal(data, &event)
+	return event.Before, err
+}
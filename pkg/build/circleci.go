@@ -0,0 +1,496 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itura/fun/pkg/fun"
+)
+
+const circleCiWorkflowId = "ci-cd"
+
+type CircleCiConfig struct {
+	Version   string                      `yaml:"version"`
+	Orbs      map[string]string           `yaml:"orbs"`
+	Jobs      map[string]CircleCiJob      `yaml:"jobs"`
+	Workflows map[string]CircleCiWorkflow `yaml:"workflows"`
+}
+
+func NewCircleCiConfig() CircleCiConfig {
+	return CircleCiConfig{
+		Version: "2.1",
+		Orbs: map[string]string{
+			"gcp-cli":   "circleci/gcp-cli@3.1.1",
+			"helm":      "circleci/helm@3.0.2",
+			"terraform": "circleci/terraform@3.2.1",
+		},
+		Jobs: map[string]CircleCiJob{},
+		Workflows: map[string]CircleCiWorkflow{
+			circleCiWorkflowId: {},
+		},
+	}
+}
+
+// SetJob defines the job and adds it to the workflow.
+func (c CircleCiConfig) SetJob(id string, job CircleCiJob, workflowJob CircleCiWorkflowJob) CircleCiConfig {
+	c.Jobs[id] = job
+	workflow := c.Workflows[circleCiWorkflowId]
+	workflowJob.Id = id
+	workflow.Jobs = append(workflow.Jobs, workflowJob)
+	c.Workflows[circleCiWorkflowId] = workflow
+	return c
+}
+
+func (c CircleCiConfig) WriteYaml(path string) error {
+	return writeYaml(path, c)
+}
+
+type CircleCiJob struct {
+	Docker []CircleCiImage `yaml:"docker"`
+	Steps  []CircleCiStep  `yaml:"steps"`
+}
+
+func NewCircleCiJob() CircleCiJob {
+	return CircleCiJob{
+		Docker: []CircleCiImage{{Image: "cimg/go:1.19"}},
+		Steps:  []CircleCiStep{CircleCiCheckoutStep()},
+	}
+}
+
+func (c CircleCiJob) AddSteps(steps ...CircleCiStep) CircleCiJob {
+	c.Steps = append(c.Steps, steps...)
+	return c
+}
+
+type CircleCiImage struct {
+	Image string `yaml:"image"`
+}
+
+type CircleCiWorkflow struct {
+	Jobs []CircleCiWorkflowJob `yaml:"jobs"`
+}
+
+type CircleCiWorkflowJob struct {
+	Id       string
+	Requires []string
+	Context  []string
+}
+
+// MarshalYAML writes the job as `id` or `id: {requires, context}`.
+func (c CircleCiWorkflowJob) MarshalYAML() (interface{}, error) {
+	if len(c.Requires) == 0 && len(c.Context) == 0 {
+		return c.Id, nil
+	}
+
+	options := map[string][]string{}
+	if len(c.Requires) > 0 {
+		options["requires"] = c.Requires
+	}
+	if len(c.Context) > 0 {
+		options["context"] = c.Context
+	}
+	return map[string]map[string][]string{c.Id: options}, nil
+}
+
+// CircleCiStep is a built-in step such as `run` or an orb command such as `helm/install_helm_client`.
+type CircleCiStep struct {
+	Command    string
+	Parameters map[string]interface{}
+}
+
+func (c CircleCiStep) MarshalYAML() (interface{}, error) {
+	if len(c.Parameters) == 0 {
+		return c.Command, nil
+	}
+	return map[string]map[string]interface{}{c.Command: c.Parameters}, nil
+}
+
+func CircleCiCheckoutStep() CircleCiStep {
+	return CircleCiStep{Command: "checkout"}
+}
+
+func CircleCiRunStep(name string, command string) CircleCiStep {
+	return CircleCiStep{
+		Command: "run",
+		Parameters: map[string]interface{}{
+			"name":    name,
+			"command": command,
+		},
+	}
+}
+
+func CircleCiSetupGcloudStep() CircleCiStep {
+	return CircleCiStep{Command: "gcp-cli/install"}
+}
+
+func CircleCiGcpAuthStep(workloadIdentityProvider, serviceAccount string) CircleCiStep {
+	return CircleCiRunStep("Authenticate to GCP", strings.Join([]string{
+		`echo "$CIRCLE_OIDC_TOKEN_V2" > .gcp_oidc_token`,
+		fmt.Sprintf(
+			"gcloud iam workload-identity-pools create-cred-config %s --service-account=%s --credential-source-file=.gcp_oidc_token --output-file=.gcp_credentials.json",
+			workloadIdentityProvider,
+			serviceAccount,
+		),
+		"gcloud auth login --cred-file=.gcp_credentials.json",
+	}, "\n"))
+}
+
+func CircleCiSetupDockerStep() CircleCiStep {
+	return CircleCiStep{Command: "setup_remote_docker"}
+}
+
+func CircleCiConfigureDockerStep(host string) CircleCiStep {
+	return CircleCiRunStep("Configure Docker Auth", fmt.Sprintf("gcloud --quiet auth configure-docker %s", host))
+}
+
+func CircleCiSetupGkeStep(cluster ClusterConfig) CircleCiStep {
+	return CircleCiRunStep("Get GKE Credentials", strings.Join([]string{
+		"gcloud --quiet components install gke-gcloud-auth-plugin kubectl",
+		fmt.Sprintf("gcloud container clusters get-credentials %s --location %s", cluster.Name, cluster.Location),
+	}, "\n"))
+}
+
+func CircleCiSetupHelmStep(version string) CircleCiStep {
+	return CircleCiStep{
+		Command:    "helm/install_helm_client",
+		Parameters: map[string]interface{}{"version": version},
+	}
+}
+
+// CircleCiSetupBuildxStep emulates other platforms on the remote Docker engine, and creates a builder able to build for them.
+func CircleCiSetupBuildxStep() CircleCiStep {
+	return CircleCiRunStep("Setup Docker Buildx", strings.Join([]string{
+		"docker run --privileged --rm tonistiigi/binfmt --install all",
+		"docker buildx create --use",
+	}, "\n"))
+}
+
+func CircleCiSetupOrasStep() CircleCiStep {
+	return CircleCiRunStep("Setup ORAS", orasInstallCommand(defaultOrasVersion))
+}
+
+func CircleCiSetupCosignStep() CircleCiStep {
+	return CircleCiRunStep("Setup Cosign", cosignInstallCommand(defaultCosignVersion))
+}
+
+func CircleCiSetupNodeStep() CircleCiStep {
+	return CircleCiRunStep("Setup Node", "sudo apt-get update -qq && sudo apt-get install -qq -y nodejs npm > /dev/null")
+}
+
+func CircleCiSetupKustomizeStep(version string) CircleCiStep {
+	return CircleCiRunStep("Setup Kustomize", kustomizeInstallCommand(version))
+}
+
+func CircleCiSetupTerraformStep(version string) CircleCiStep {
+	return CircleCiStep{
+		Command:    "terraform/install",
+		Parameters: map[string]interface{}{"terraform_version": version},
+	}
+}
+
+func CircleCiBuildArtifactStep(id string, buildArgs []RuntimeArg, configPath string, cmd string) CircleCiStep {
+	return CircleCiRunStep("Build "+id, withEnv(buildArgs, circleCiRunCommand(cmd, "build-artifact", id, configPath)))
+}
+
+func CircleCiDeployStep(id string, runtimeArgs []RuntimeArg, configPath string, cmd string) CircleCiStep {
+	return CircleCiRunStep(
+		"Deploy "+id,
+		withEnv(runtimeArgs, circleCiRunCommand(cmd, "deploy-application", id, configPath)),
+	)
+}
+
+func circleCiRunCommand(cmd string, subcommand string, id string, configPath string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("go run %s %s %s", cmd, subcommand, id),
+		"--config " + configPath,
+		"--current-sha $CIRCLE_SHA1",
+		`--base-sha "<< pipeline.git.base_revision >>"`,
+	}, " \\\n  ")
+}
+
+type CircleCiGcpCloudProvider struct {
+	Config map[string]string
+}
+
+func (c CircleCiGcpCloudProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []CircleCiStep {
+	return []CircleCiStep{
+		CircleCiSetupGcloudStep(),
+		CircleCiGcpAuthStep(
+			"$"+formatVariableName(c.Config["workloadIdentityProvider"]),
+			"$"+formatVariableName(c.Config["serviceAccount"]),
+		),
+	}
+}
+
+func (c CircleCiGcpCloudProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type CircleCiGcpDockerRepository struct {
+	Host string
+}
+
+func (c CircleCiGcpDockerRepository) ResolveSetupSteps(secretConfigs []SecretConfig) []CircleCiStep {
+	return []CircleCiStep{
+		CircleCiSetupDockerStep(),
+		CircleCiConfigureDockerStep(c.Host),
+	}
+}
+
+func (c CircleCiGcpDockerRepository) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type CircleCiGkeCluster struct {
+	Cluster ClusterConfig
+}
+
+func (c CircleCiGkeCluster) ResolveSetupSteps(secretConfigs []SecretConfig) []CircleCiStep {
+	return []CircleCiStep{CircleCiSetupGkeStep(c.Cluster)}
+}
+
+func (c CircleCiGkeCluster) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+// CircleCiContextSecretProvider reads secrets from a context named after the secret provider id.
+// It's used for `github-actions` secret providers, so the same config works for both.
+type CircleCiContextSecretProvider struct {
+	secretNames []string
+}
+
+func (c CircleCiContextSecretProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []CircleCiStep {
+	return []CircleCiStep{}
+}
+
+func (c CircleCiContextSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretConfig) []RuntimeArg {
+	var runtimeArgs []RuntimeArg
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(c.secretNames, secretConfig.SecretName) {
+			runtimeArgs = append(runtimeArgs, RuntimeArg{
+				Key:         secretConfig.Key,
+				Value:       fmt.Sprintf(`"${%s}"`, formatVariableName(secretConfig.SecretName)),
+				SecretValue: secretConfig.SecretName,
+			})
+		}
+	}
+	return runtimeArgs
+}
+
+func (c CircleCiContextSecretProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+// CircleCiGcpSecretProvider reads secrets from Secret Manager into $BASH_ENV, which is loaded by later steps.
+type CircleCiGcpSecretProvider struct {
+	id          string
+	project     string
+	secretNames []string
+}
+
+func (c CircleCiGcpSecretProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []CircleCiStep {
+	var script []string
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(c.secretNames, secretConfig.SecretName) {
+			script = append(script, fmt.Sprintf(
+				`printf 'export %s=%%q\n' "$(gcloud secrets versions access latest --secret=%s --project=%s)" >> "$BASH_ENV"`,
+				c.variable(secretConfig.SecretName),
+				secretConfig.SecretName,
+				c.project,
+			))
+		}
+	}
+	if len(script) == 0 {
+		return []CircleCiStep{}
+	}
+	return []CircleCiStep{CircleCiRunStep("Get Secrets from "+c.id, strings.Join(script, "\n"))}
+}
+
+func (c CircleCiGcpSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretConfig) []RuntimeArg {
+	var runtimeArgs []RuntimeArg
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(c.secretNames, secretConfig.SecretName) {
+			runtimeArgs = append(runtimeArgs, RuntimeArg{
+				Key:         secretConfig.Key,
+				Value:       fmt.Sprintf(`"${%s}"`, c.variable(secretConfig.SecretName)),
+				SecretValue: secretConfig.SecretName,
+			})
+		}
+	}
+	return runtimeArgs
+}
+
+func (c CircleCiGcpSecretProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+func (c CircleCiGcpSecretProvider) variable(secretName string) string {
+	return formatVariableName(fmt.Sprintf("secrets_%s_%s", c.id, secretName))
+}
+
+func NewCircleCiFactory(configPath string, cmd string, config PipelineConfigRaw, dependencies Dependencies) CircleCiFactory {
+	return CircleCiFactory{
+		config:       config,
+		configPath:   configPath,
+		cmd:          cmd,
+		dependencies: dependencies,
+	}
+}
+
+type CircleCiFactory struct {
+	config       PipelineConfigRaw
+	configPath   string
+	cmd          string
+	dependencies Dependencies
+}
+
+func (c CircleCiFactory) GetCloudProvider(config CloudProviderConfig) CloudProvider1[CircleCiStep] {
+	switch config.Type {
+	case cloudProviderTypeGcp:
+		return CircleCiGcpCloudProvider{config.Config}
+	default:
+		return nil
+	}
+}
+
+func (c CircleCiFactory) GetSecretProvider(config SecretProviderConfig) SecretProvider1[CircleCiStep] {
+	switch config.Type {
+	case secretProviderTypeGithub:
+		return CircleCiContextSecretProvider{secretNames: config.SecretNames}
+	case secretProviderTypeGcp:
+		return CircleCiGcpSecretProvider{
+			id:          config.Id,
+			project:     config.Config["project"],
+			secretNames: config.SecretNames,
+		}
+	default:
+		return nil
+	}
+}
+
+func (c CircleCiFactory) GetArtifactRepositoryProvider(config ArtifactRepository) ArtifactRepositoryProvider[CircleCiStep] {
+	switch config.Type {
+	case artifactRepositoryTypeGcpDocker:
+		return CircleCiGcpDockerRepository{config.Host}
+	default:
+		return nil
+	}
+}
+
+func (c CircleCiFactory) GetKubernetesProvider(config ClusterConfig) KubernetesProvider[CircleCiStep] {
+	switch config.Type {
+	case "gke":
+		return CircleCiGkeCluster{config}
+	default:
+		return nil
+	}
+}
+
+func (c CircleCiFactory) Create() CircleCiConfig {
+	config := NewCircleCiConfig()
+	for _, artifact := range c.config.Artifacts {
+		config = config.SetJob(
+			c.dependencies.GetJobId(artifact.Id),
+			c.GetArtifactJob(artifact),
+			CircleCiWorkflowJob{Requires: c.dependencies.GetUpstreamJobIds(artifact.Id)},
+		)
+	}
+	for _, application := range c.config.Applications {
+		config = config.SetJob(
+			c.dependencies.GetJobId(application.Id),
+			c.GetApplicationJob(application),
+			CircleCiWorkflowJob{
+				Requires: c.dependencies.GetUpstreamJobIds(application.Id),
+				Context:  c.getContexts(application),
+			},
+		)
+	}
+	return config
+}
+
+// getContexts returns the `github-actions` secret providers holding the application's secrets.
+func (c CircleCiFactory) getContexts(application ApplicationConfig) []string {
+	var contexts []string
+	for _, providerConfig := range c.config.Resources.SecretProviders {
+		if providerConfig.Type != secretProviderTypeGithub {
+			continue
+		}
+		for _, secretConfig := range application.Secrets {
+			if fun.Contains(providerConfig.SecretNames, secretConfig.SecretName) {
+				contexts = append(contexts, providerConfig.Id)
+				break
+			}
+		}
+	}
+	return contexts
+}
+
+func (c CircleCiFactory) GetArtifactJob(artifact ArtifactConfig) CircleCiJob {
+	job := NewCircleCiJob().
+		AddSteps(ResolveCloudProviderSteps[CircleCiStep](c, c.config.Resources.CloudProvider)...)
+	if artifact.Type.UsesRegistry(artifact.Docker.Cache) {
+		job = job.AddSteps(ResolveArtifactRepositorySteps[CircleCiStep](c, c.config.Resources.ArtifactRepository)...)
+	}
+
+	switch artifact.Type {
+	case artifactTypeApp, artifactTypeLib:
+		if artifact.Type == artifactTypeApp {
+			job = job.AddSteps(CircleCiSetupOrasStep())
+		}
+		if artifact.Docker.UsesBuildx(artifact.Platforms) {
+			job = job.AddSteps(CircleCiSetupBuildxStep())
+		}
+		if !artifact.Docker.Sign.IsEmpty() {
+			job = job.AddSteps(CircleCiSetupCosignStep())
+		}
+	case artifactTypeGoBinary:
+		job = job.AddSteps(CircleCiSetupOrasStep())
+	case artifactTypeHelmChart:
+		job = job.AddSteps(CircleCiSetupHelmStep(defaultHelmVersion), CircleCiSetupOrasStep())
+	case artifactTypeNpm:
+		job = job.AddSteps(CircleCiSetupNodeStep())
+	}
+
+	var buildArgs []RuntimeArg
+	for _, arg := range artifact.Docker.BuildArgs {
+		buildArgs = append(buildArgs, arg.ResolveShell("", "CIRCLE_SHA1"))
+	}
+	return job.AddSteps(CircleCiBuildArtifactStep(artifact.Id, buildArgs, c.configPath, c.cmd))
+}
+
+func (c CircleCiFactory) GetApplicationJob(application ApplicationConfig) CircleCiJob {
+	job := NewCircleCiJob().
+		AddSteps(ResolveCloudProviderSteps[CircleCiStep](c, c.config.Resources.CloudProvider)...)
+
+	var runtimeArgs []RuntimeArg
+	for _, arg := range application.RuntimeValues() {
+		runtimeArgs = append(runtimeArgs, arg.ResolveShell("", "CIRCLE_SHA1"))
+	}
+	secretSteps, secretArgs := ResolveSecrets[CircleCiStep](c, c.config.Resources.SecretProviders, application.Secrets)
+	job = job.AddSteps(secretSteps...)
+	runtimeArgs = append(runtimeArgs, secretArgs...)
+
+	switch application.Type {
+	case applicationTypeHelm:
+		job = job.
+			AddSteps(ResolveKubernetesSteps[CircleCiStep](c, c.config.Resources.KubernetesCluster)...).
+			AddSteps(CircleCiSetupHelmStep(application.Helm.ClientVersion()))
+	case applicationTypeTerraform:
+		job = job.AddSteps(CircleCiSetupTerraformStep(application.Terraform.ClientVersion()))
+	case applicationTypeKustomize, applicationTypeKubectl:
+		job = job.
+			AddSteps(ResolveKubernetesSteps[CircleCiStep](c, c.config.Resources.KubernetesCluster)...).
+			AddSteps(CircleCiSetupKustomizeStep(application.Manifests.ClientVersion()))
+	default:
+		panic("😅")
+	}
+	if application.readsRegistry() {
+		job = job.AddSteps(ResolveArtifactRepositorySteps[CircleCiStep](c, c.config.Resources.ArtifactRepository)...)
+		job = job.AddSteps(CircleCiSetupOrasStep())
+	}
+	if application.RequireSignedImages {
+		job = job.AddSteps(CircleCiSetupCosignStep())
+	}
+
+	return job.AddSteps(CircleCiDeployStep(application.Id, runtimeArgs, c.configPath, c.cmd))
+}
@@ -0,0 +1,87 @@
+package build
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+type circleCiConfigDto struct {
+	Version   string                            `yaml:"version"`
+	Orbs      map[string]string                 `yaml:"orbs"`
+	Jobs      map[string]circleCiJobDto         `yaml:"jobs"`
+	Workflows map[string]map[string][]yaml.Node `yaml:"workflows"`
+}
+
+type circleCiJobDto struct {
+	Docker []CircleCiImage `yaml:"docker"`
+	Steps  []yaml.Node     `yaml:"steps"`
+}
+
+// stepCommand returns the command of a step written as `command` or `command: {...}`.
+func stepCommand(node yaml.Node) string {
+	if node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+	return node.Content[0].Value
+}
+
+func TestCircleCiGeneration(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	writer, err := ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.23", ciTargetCircleci)
+	assert.Nil(t, err)
+
+	outputPath := t.TempDir() + "/config.yml"
+	assert.Nil(t, writer.WriteYaml(outputPath))
+	bytes, err := os.ReadFile(outputPath)
+	assert.Nil(t, err)
+
+	var actual circleCiConfigDto
+	assert.Nil(t, yaml.Unmarshal(bytes, &actual))
+	assert.Equal(t, "2.1", actual.Version)
+
+	var workflowJobs []string
+	requires := map[string][]string{}
+	contexts := map[string][]string{}
+	for _, node := range actual.Workflows["ci-cd"]["jobs"] {
+		if node.Kind == yaml.ScalarNode {
+			workflowJobs = append(workflowJobs, node.Value)
+			continue
+		}
+		id := node.Content[0].Value
+		var options map[string][]string
+		assert.Nil(t, node.Content[1].Decode(&options))
+		workflowJobs = append(workflowJobs, id)
+		requires[id] = options["requires"]
+		contexts[id] = options["context"]
+	}
+
+	assert.Equal(t, []string{"build-api", "build-client", "deploy-infra", "deploy-db", "deploy-website"}, workflowJobs)
+	assert.Equal(t, []string{"deploy-infra"}, requires["deploy-db"])
+	assert.ElementsMatch(t, []string{"build-client", "build-api", "deploy-infra", "deploy-db"}, requires["deploy-website"])
+	assert.Equal(t, []string{"github"}, contexts["deploy-db"])
+	assert.Nil(t, contexts["deploy-website"])
+
+	for _, id := range workflowJobs {
+		job, present := actual.Jobs[id]
+		assert.True(t, present, id)
+		assert.Equal(t, "checkout", stepCommand(job.Steps[0]))
+		for _, step := range job.Steps {
+			command := stepCommand(step)
+			if orb, _, isOrb := strings.Cut(command, "/"); isOrb {
+				assert.Contains(t, actual.Orbs, orb, command)
+			}
+		}
+	}
+
+	var helmSteps []string
+	for _, step := range actual.Jobs["deploy-db"].Steps {
+		helmSteps = append(helmSteps, stepCommand(step))
+	}
+	assert.Contains(t, helmSteps, "helm/install_helm_client")
+	assert.Equal(t, "setup_remote_docker", stepCommand(actual.Jobs["build-api"].Steps[3]))
+}
@@ -1,5 +1,10 @@
 package build
 
+import (
+	"fmt"
+	"os"
+)
+
 type PipelineCommand interface {
 	Run() error
 }
@@ -9,25 +14,97 @@ type CommonArgs struct {
 	Self       bool   `arg:"--self" help:"Run the tool in its own source repo."`
 }
 
-type ActionArgs struct {
-	CommonArgs
-	Id         string `arg:"positional,required"`
+type DryRunArgs struct {
+	DryRun bool   `arg:"--dry-run" help:"print commands instead of running them"`
+	Format string `arg:"--format" default:"text" help:"dry run output format, text or json"`
+}
+
+func (d DryRunArgs) PrintPlan(pipeline Pipeline, ids ...string) error {
+	plan, err := pipeline.Plan(ids...)
+	if err != nil {
+		return err
+	}
+	return d.WritePlan(plan)
+}
+
+func (d DryRunArgs) WritePlan(plan Plan) error {
+	switch d.Format {
+	case "json":
+		return plan.WriteJson(os.Stdout)
+	case "text", "":
+		return plan.WriteText(os.Stdout)
+	default:
+		return fmt.Errorf("unknown format %s", d.Format)
+	}
+}
+
+type ChangeDetectionArgs struct {
+	BaseSha    string `arg:"--base-sha" help:"sha to compare against for change detection"`
+	BaseBranch string `arg:"--base-branch,env:GITHUB_BASE_REF" help:"pull request target branch, compared against using the merge base"`
+	EventPath  string `arg:"--event-path,env:GITHUB_EVENT_PATH" help:"GitHub push event payload, compared against its before sha"`
+	SinceGreen bool   `arg:"--since-green" help:"compare each artifact against the sha of its latest-green image"`
+}
+
+func (c ChangeDetectionArgs) BaseRef() BaseRef {
+	return BaseRef{
+		Sha:        c.BaseSha,
+		Branch:     c.BaseBranch,
+		EventPath:  c.EventPath,
+		SinceGreen: c.SinceGreen,
+	}
+}
+
+// PipelineArgs are the options of commands which build artifacts and deploy applications at a sha.
+type PipelineArgs struct {
 	CurrentSha string `arg:"--current-sha,required" help:"current git sha, used for change detection"`
 	Force      bool   `arg:"--force" help:"Ignore change detection"`
+	Verify     bool   `arg:"--verify" help:"test artifacts and check applications without pushing or deploying"`
+	// Environment selects the environment overrides to deploy applications with
+	Environment string `arg:"--environment" help:"environment to deploy applications to"`
+	// VerifyBuildApp also builds the app image when verifying an artifact
+	VerifyBuildApp bool `arg:"--verify-build-app" help:"with --verify, also build artifact app images"`
+	// Phase splits Terraform deploys into separate jobs
+	Phase        string `arg:"--phase" help:"terraform phase to run, plan or apply; both by default"`
+	Drift        bool   `arg:"--drift" help:"fail when terraform plans have changes, without applying them"`
+	AllowDestroy bool   `arg:"--allow-destroy" help:"apply terraform plans which destroy resources despite preventDestroy"`
+}
+
+type ActionArgs struct {
+	CommonArgs
+	DryRunArgs
+	ChangeDetectionArgs
+	PipelineArgs
+	Id string `arg:"positional,required"`
 }
 
 func (a ActionArgs) CreatePipeline() (Pipeline, error) {
 	runner := ShellCommandRunner{}
-	cd, err := NewGitChangeDetection(runner)
-	if err != nil {
-		return Pipeline{}, err
-	}
+	cd := NewGitChangeDetection(runner, a.BaseRef())
 	return ParsePipeline(a, cd)
 }
 
+type RunArgs struct {
+	CommonArgs
+	DryRunArgs
+	ChangeDetectionArgs
+	PipelineArgs
+	Workers int `arg:"--workers" default:"4" help:"maximum number of artifacts and applications to run at once"`
+}
+
+func (r RunArgs) CreatePipeline() (Pipeline, error) {
+	return ActionArgs{
+		CommonArgs:          r.CommonArgs,
+		DryRunArgs:          r.DryRunArgs,
+		ChangeDetectionArgs: r.ChangeDetectionArgs,
+		PipelineArgs:        r.PipelineArgs,
+		Id:                  "",
+	}.CreatePipeline()
+}
+
 type GenerateArgs struct {
 	CommonArgs
-	OutputPath string `arg:"positional,required" help:"path to write generated Github Actions definition to"`
+	OutputPath string   `arg:"positional,required" help:"path to write generated CI definition to"`
+	Target     CiTarget `arg:"--target" default:"github" help:"CI system to generate for: github, gitlab, circleci"`
 }
 
 func (g GenerateArgs) CreatePipeline() (Pipeline, error) {
@@ -35,8 +112,6 @@ func (g GenerateArgs) CreatePipeline() (Pipeline, error) {
 		ActionArgs{
 			CommonArgs: g.CommonArgs,
 			Id:         "",
-			CurrentSha: "",
-			Force:      false,
 		},
 		NewAlwaysChanged(),
 	)
@@ -52,7 +127,27 @@ func (c GenerateCommand) Run() error {
 		return err
 	}
 
-	return pipeline.ToGitHubWorkflow().WriteYaml(c.OutputPath)
+	if c.Target == ciTargetGithub {
+		err = pipeline.ToGitHubWorkflow().WriteYaml(c.OutputPath)
+		if err != nil {
+			return err
+		}
+		rollback := pipeline.ToGitHubRollbackWorkflow()
+		if len(rollback.Jobs) == 0 {
+			return nil
+		}
+		return rollback.WriteYaml(RollbackWorkflowPath(c.OutputPath))
+	}
+
+	config, err := readFile(c.ConfigPath)
+	if err != nil {
+		return err
+	}
+	writer, err := ParseConfigForTarget(config, c.ConfigPath, pipeline.Cmd, c.Target)
+	if err != nil {
+		return err
+	}
+	return writer.WriteYaml(c.OutputPath)
 }
 
 type BuildArtifactCommand struct {
@@ -65,6 +160,10 @@ func (c BuildArtifactCommand) Run() error {
 		return err
 	}
 
+	if c.DryRun {
+		return c.PrintPlan(pipeline, c.Id)
+	}
+
 	sideEffects, err := pipeline.BuildArtifact(c.Id)
 	if err != nil {
 		return err
@@ -88,6 +187,10 @@ func (c DeployApplicationCommand) Run() error {
 		return err
 	}
 
+	if c.DryRun {
+		return c.PrintPlan(pipeline, c.Id)
+	}
+
 	sideEffects, err := pipeline.DeployApplication(c.Id)
 	if err != nil {
 		return err
@@ -100,3 +203,100 @@ func (c DeployApplicationCommand) Run() error {
 
 	return nil
 }
+
+type RunCommand struct {
+	RunArgs
+}
+
+func (c RunCommand) Run() error {
+	pipeline, err := c.CreatePipeline()
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		order, err := pipeline.config.Dependencies.TopologicalOrder()
+		if err != nil {
+			return err
+		}
+		return c.PrintPlan(pipeline, order...)
+	}
+
+	_, err = NewExecutor(pipeline, ShellCommandRunner{}, c.Workers).Run()
+	return err
+}
+
+type RollbackArgs struct {
+	CommonArgs
+	DryRunArgs
+	Id           string `arg:"positional,required"`
+	To           string `arg:"--to,required" help:"sha to roll back to, or previous for Helm applications"`
+	Environment  string `arg:"--environment" help:"environment to roll the application back in"`
+	AllowDestroy bool   `arg:"--allow-destroy" help:"apply terraform plans which destroy resources despite preventDestroy"`
+}
+
+// Sha resolves the full sha to roll back to. Helm releases keep their own history, so previous reads the config
+// as of HEAD and leaves the revision to Helm.
+func (r RollbackArgs) Sha(runner CommandRunner) (string, error) {
+	ref := r.To
+	if ref == rollbackPrevious {
+		ref = "HEAD"
+	}
+	sha, err := runner.Output("git", "rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("can't resolve %s to a commit: %w", r.To, err)
+	}
+	return sha, nil
+}
+
+func (r RollbackArgs) CreatePipeline(sha string) (Pipeline, error) {
+	return ParsePipeline(
+		ActionArgs{
+			CommonArgs: r.CommonArgs,
+			PipelineArgs: PipelineArgs{
+				CurrentSha:   sha,
+				Force:        true,
+				Environment:  r.Environment,
+				AllowDestroy: r.AllowDestroy,
+			},
+			Id: r.Id,
+		},
+		NewAlwaysChanged(),
+	)
+}
+
+type RollbackCommand struct {
+	RollbackArgs
+}
+
+func (c RollbackCommand) Run() error {
+	runner := ShellCommandRunner{}
+	sha, err := c.Sha(runner)
+	if err != nil {
+		return err
+	}
+
+	pipeline, err := c.CreatePipeline(sha)
+	if err != nil {
+		return err
+	}
+
+	var registry CommandRunner = runner
+	if c.DryRun {
+		registry = NewRecordingCommandRunner()
+	}
+	sideEffects, err := pipeline.Rollback(c.Id, c.To == rollbackPrevious, registry)
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		plan, err := pipeline.PlanSideEffects(c.Id, sideEffects)
+		if err != nil {
+			return err
+		}
+		return c.WritePlan(plan)
+	}
+
+	return sideEffects.Apply(runner)
+}
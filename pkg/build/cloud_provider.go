@@ -51,3 +51,30 @@ func (g GCPCloudProvider) Validate(errs ValidationErrors) ValidationErrors {
 	//}
 	return errs
 }
+
+type GcpDockerRepository struct {
+	Host string
+}
+
+func (g GcpDockerRepository) ResolveSetupSteps(secretConfigs []SecretConfig) []GitHubActionsStep {
+	return []GitHubActionsStep{
+		ConfigureGcloudCliStep(),
+		ConfigureGcloudDockerStep(g.Host),
+	}
+}
+
+func (g GcpDockerRepository) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type GkeCluster struct {
+	Cluster ClusterConfig
+}
+
+func (g GkeCluster) ResolveSetupSteps(secretConfigs []SecretConfig) []GitHubActionsStep {
+	return []GitHubActionsStep{GetSetupGkeStep(g.Cluster)}
+}
+
+func (g GkeCluster) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
@@ -1,13 +1,22 @@
 package build
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
 )
 
 type SideEffects struct {
 	Commands []Command
+	// Files are written before running commands, and removed after
+	Files []File
+	// OnFailure commands are run when a command fails, to undo partial changes
+	OnFailure []Command
 }
 
 func NewSideEffects(commands ...Command) SideEffects {
@@ -19,6 +28,31 @@ func NewSideEffects(commands ...Command) SideEffects {
 type Command struct {
 	Name      string
 	Arguments []string
+	// Env is added to the command's environment, with $VAR references in values expanded
+	Env map[string]string
+	// Check is given the command's output, instead of printing it
+	Check OutputCheck
+	// Dir is the working directory of the command, when it isn't the current directory
+	Dir string
+	// ExpandArgs expands $VAR references in arguments from the environment, for tools which only read values
+	// from their arguments. Other commands are given their arguments as they are
+	ExpandArgs bool
+	// Vars are set from the command's output, for later commands to reference as $NAME
+	Vars OutputVars
+	// Endpoint is requested instead of running a command, by runners which are an EndpointChecker
+	Endpoint *EndpointCheck
+	// OnFailure commands are run when this command fails, before stopping
+	OnFailure []Command
+}
+
+type OutputCheck interface {
+	Check(output string) error
+}
+
+// OutputVars reads variables from a command's output. References to them in later commands' arguments and env
+// are replaced before the commands run, so they can depend on state which is only known once applying.
+type OutputVars interface {
+	Vars(output string) map[string]string
 }
 
 func NewCommand(name string, args ...string) Command {
@@ -33,38 +67,392 @@ func (c Command) Add(args ...string) Command {
 	return c
 }
 
+func (c Command) SetCheck(check OutputCheck) Command {
+	c.Check = check
+	return c
+}
+
+func (c Command) SetVars(vars OutputVars) Command {
+	c.Vars = vars
+	return c
+}
+
+// expand replaces references to vars, leaving any others to be resolved from the environment.
+func (c Command) expand(vars map[string]string) Command {
+	if len(vars) == 0 {
+		return c
+	}
+	mapping := func(key string) string {
+		if value, present := vars[key]; present {
+			return value
+		}
+		if key == "$" {
+			return "$$"
+		}
+		return "${" + key + "}"
+	}
+
+	args := make([]string, len(c.Arguments))
+	for i, arg := range c.Arguments {
+		args[i] = os.Expand(arg, mapping)
+	}
+	c.Arguments = args
+	if c.Env != nil {
+		env := map[string]string{}
+		for key, value := range c.Env {
+			env[key] = os.Expand(value, mapping)
+		}
+		c.Env = env
+	}
+	return c
+}
+
+func (c Command) SetOnFailure(commands ...Command) Command {
+	c.OnFailure = commands
+	return c
+}
+
+func (c Command) SetDir(dir string) Command {
+	c.Dir = dir
+	return c
+}
+
+func (c Command) SetExpandArgs(expand bool) Command {
+	c.ExpandArgs = expand
+	return c
+}
+
+func (c Command) SetEnv(key string, value string) Command {
+	env := map[string]string{key: value}
+	for k, v := range c.Env {
+		if k != key {
+			env[k] = v
+		}
+	}
+	c.Env = env
+	return c
+}
+
 func (s SideEffects) Apply(r CommandRunner) error {
-	for _, command := range s.Commands {
-		err := r.Run(command.Name, command.Arguments...)
+	vars := map[string]string{}
+	if len(s.Files) > 0 {
+		writer, ok := r.(FileWriter)
+		if !ok {
+			return fmt.Errorf("%T can't write files", r)
+		}
+		for _, file := range s.Files {
+			written, err := writer.WriteFile(file)
+			if err != nil {
+				return err
+			}
+			defer writer.RemoveFile(written)
+			if file.Var != "" {
+				vars[file.Var] = written.Path
+			}
+		}
+	}
+
+	err := runCommands(r, s.Commands, vars)
+	if err != nil {
+		return undo(r, err, s.OnFailure, vars)
+	}
+	return nil
+}
+
+// undo runs failure commands after err, keeping err as the cause.
+func undo(r CommandRunner, err error, commands []Command, vars map[string]string) error {
+	if len(commands) == 0 {
+		return err
+	}
+	if failureErr := runCommands(r, commands, vars); failureErr != nil {
+		return fmt.Errorf("%w, then failed to undo it: %s", err, failureErr.Error())
+	}
+	return err
+}
+
+func runCommands(r CommandRunner, commands []Command, vars map[string]string) error {
+	for _, command := range commands {
+		command = command.expand(vars)
+		var err error
+		if command.Endpoint != nil {
+			checker, ok := r.(EndpointChecker)
+			if !ok {
+				return fmt.Errorf("%T can't check endpoints", r)
+			}
+			err = checker.CheckEndpoint(*command.Endpoint)
+		} else if command.Check != nil || command.Vars != nil {
+			var output string
+			output, err = command.output(r)
+			if err == nil && command.Check != nil && !skipsChecks(r) {
+				err = command.Check.Check(output)
+			}
+			if err == nil && command.Vars != nil {
+				for key, value := range command.Vars.Vars(output) {
+					vars[key] = value
+				}
+			}
+		} else {
+			err = command.run(r)
+		}
 
 		if err != nil {
-			return err
+			return undo(r, err, command.OnFailure, vars)
 		}
 	}
 
 	return nil
 }
 
+func (c Command) options() ExecOptions {
+	return ExecOptions{Env: c.Env, Dir: c.Dir, ExpandArgs: c.ExpandArgs}
+}
+
+// run runs the command with its env and working directory, which only an ExecCommandRunner can apply.
+func (c Command) run(r CommandRunner) error {
+	options := c.options()
+	if options.IsEmpty() {
+		return r.Run(c.Name, c.Arguments...)
+	}
+	execRunner, err := options.runner(r)
+	if err != nil {
+		return err
+	}
+	return execRunner.RunWith(options, c.Name, c.Arguments...)
+}
+
+func (c Command) output(r CommandRunner) (string, error) {
+	options := c.options()
+	if options.IsEmpty() {
+		return r.Output(c.Name, c.Arguments...)
+	}
+	execRunner, err := options.runner(r)
+	if err != nil {
+		return "", err
+	}
+	return execRunner.OutputWith(options, c.Name, c.Arguments...)
+}
+
 func (s SideEffects) Add(commands ...Command) SideEffects {
 	s.Commands = append(s.Commands, commands...)
 	return s
 }
 
+func (s SideEffects) AddOnFailure(commands ...Command) SideEffects {
+	s.OnFailure = append(s.OnFailure, commands...)
+	return s
+}
+
+func (s SideEffects) AddFile(files ...File) SideEffects {
+	s.Files = append(s.Files, files...)
+	return s
+}
+
+// File is a YAML file written for commands to read. $VAR references in its string values
+// are expanded when it's written, so that secrets aren't passed on the command line.
+type File struct {
+	// Path is where the file is written. With Var, it's a pattern for a new temporary file, as for os.CreateTemp
+	Path string
+	// Var names the variable later commands reference a temporary file's path with, as $NAME
+	Var     string
+	Content string
+}
+
+// Resolve expands references in the file's string values. Values which weren't explicitly tagged
+// are re-typed after expansion, the same way Helm types --set values.
+func (f File) Resolve(getenv func(string) string) (string, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(f.Content), &document)
+	if err != nil {
+		return "", err
+	}
+
+	var expand func(node *yaml.Node)
+	expand = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!str" {
+			node.Value = expandEnv(node.Value, getenv)
+			if node.Style&yaml.TaggedStyle == 0 {
+				node.Tag = ""
+				node.Style = 0
+			}
+		}
+		for _, child := range node.Content {
+			expand(child)
+		}
+	}
+	expand(&document)
+
+	content, err := yaml.Marshal(&document)
+	return string(content), err
+}
+
+// ExecOptions are how a command is run, beyond its arguments.
+type ExecOptions struct {
+	// Env is added to the command's environment, with $VAR references in values expanded
+	Env map[string]string
+	// Dir is the working directory of the command, when it isn't the current directory
+	Dir string
+	// ExpandArgs expands $VAR references in arguments from the environment
+	ExpandArgs bool
+}
+
+func (o ExecOptions) IsEmpty() bool {
+	return len(o.Env) == 0 && o.Dir == "" && !o.ExpandArgs
+}
+
+func (o ExecOptions) runner(r CommandRunner) (ExecCommandRunner, error) {
+	execRunner, ok := r.(ExecCommandRunner)
+	switch {
+	case ok:
+		return execRunner, nil
+	case len(o.Env) > 0:
+		return nil, fmt.Errorf("%T can't run commands with env", r)
+	case o.Dir != "":
+		return nil, fmt.Errorf("%T can't run commands in a directory", r)
+	default:
+		return nil, fmt.Errorf("%T can't expand command arguments", r)
+	}
+}
+
+// ExecCommandRunner is implemented by runners able to apply side effects with command env, a working directory
+// or expanded arguments.
+type ExecCommandRunner interface {
+	RunWith(options ExecOptions, name string, args ...string) error
+	OutputWith(options ExecOptions, name string, args ...string) (string, error)
+}
+
+// EndpointChecker is implemented by runners able to apply side effects with HTTP endpoint checks.
+type EndpointChecker interface {
+	CheckEndpoint(check EndpointCheck) error
+}
+
+// CheckSkipper is implemented by runners which don't run commands, so there's no output to check.
+type CheckSkipper interface {
+	SkipsChecks() bool
+}
+
+func skipsChecks(r CommandRunner) bool {
+	skipper, ok := r.(CheckSkipper)
+	return ok && skipper.SkipsChecks()
+}
+
+// FileWriter is implemented by runners able to apply side effects with files.
+type FileWriter interface {
+	// WriteFile writes the file, returning it with the path it was written to
+	WriteFile(file File) (File, error)
+	RemoveFile(file File) error
+}
+
 type CommandRunner interface {
 	Run(name string, args ...string) error
 	RunSilent(name string, args ...string) error
 	Output(name string, args ...string) (string, error)
 }
 
+// escapeDollars keeps a literal value as it is when it's expanded, since $$ expands to $.
+func escapeDollars(value string) string {
+	return strings.ReplaceAll(value, "$", "$$")
+}
+
+// expandEnv expands $VAR references with getenv, and $$ to $.
+func expandEnv(value string, getenv func(string) string) string {
+	return os.Expand(value, func(key string) string {
+		if key == "$" {
+			return "$"
+		}
+		return getenv(key)
+	})
+}
+
+// ResolveArgs expands $VAR references in arguments using the current environment,
+// since commands are not run through a shell. Only commands which ExpandArgs are resolved, so that values
+// such as secrets stay out of the arguments of every other command.
+func ResolveArgs(args []string) []string {
+	var results []string
+	for _, arg := range args {
+		results = append(results, expandEnv(arg, os.Getenv))
+	}
+	return results
+}
+
+// ReferencedEnv returns the environment variables referenced by arguments along with their current values.
+func ReferencedEnv(args []string) map[string]string {
+	env := map[string]string{}
+	for _, arg := range args {
+		os.Expand(arg, func(key string) string {
+			if key != "$" {
+				env[key] = os.Getenv(key)
+			}
+			return ""
+		})
+	}
+	return env
+}
+
 type ShellCommandRunner struct{}
 
 func (c ShellCommandRunner) Run(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
+	return c.RunWith(ExecOptions{}, name, args...)
+}
+
+func (c ShellCommandRunner) RunWith(options ExecOptions, name string, args ...string) error {
+	cmd := c.command(options, name, args)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
+func (c ShellCommandRunner) OutputWith(options ExecOptions, name string, args ...string) (string, error) {
+	raw, err := c.command(options, name, args).Output()
+	result := strings.TrimSpace(string(raw))
+	return result, err
+}
+
+func (c ShellCommandRunner) command(options ExecOptions, name string, args []string) *exec.Cmd {
+	if options.ExpandArgs {
+		args = ResolveArgs(args)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = options.Dir
+	if len(options.Env) > 0 {
+		cmd.Env = os.Environ()
+		for _, key := range sortedKeys(options.Env) {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, expandEnv(options.Env[key], os.Getenv)))
+		}
+	}
+	return cmd
+}
+
+func (c ShellCommandRunner) CheckEndpoint(check EndpointCheck) error {
+	return check.Run()
+}
+
+// WriteFile writes temporary files with os.CreateTemp, so that their paths can't be predicted.
+func (c ShellCommandRunner) WriteFile(file File) (File, error) {
+	content, err := file.Resolve(os.Getenv)
+	if err != nil {
+		return File{}, err
+	}
+	if file.Var == "" {
+		return file, os.WriteFile(file.Path, []byte(content), 0600)
+	}
+
+	temp, err := os.CreateTemp("", file.Path)
+	if err != nil {
+		return File{}, err
+	}
+	file.Path = temp.Name()
+	if _, err = temp.WriteString(content); err != nil {
+		temp.Close()
+		os.Remove(file.Path)
+		return File{}, err
+	}
+	return file, temp.Close()
+}
+
+func (c ShellCommandRunner) RemoveFile(file File) error {
+	return os.Remove(file.Path)
+}
+
 func (c ShellCommandRunner) RunSilent(name string, args ...string) error {
 	cmd := exec.Command(name, args...)
 	_, err := cmd.Output()
@@ -72,8 +460,213 @@ func (c ShellCommandRunner) RunSilent(name string, args ...string) error {
 }
 
 func (c ShellCommandRunner) Output(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	raw, err := cmd.Output()
-	result := strings.TrimSpace(string(raw))
-	return result, err
+	return c.OutputWith(ExecOptions{}, name, args...)
+}
+
+// RecordedFile is a file with its content resolved, as it would be written.
+type RecordedFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type RecordedCommand struct {
+	Name      string            `json:"name"`
+	Arguments []string          `json:"arguments"`
+	Env       map[string]string `json:"env,omitempty"`
+	Dir       string            `json:"dir,omitempty"`
+}
+
+func (r RecordedCommand) String() string {
+	builder := &strings.Builder{}
+	if r.Dir != "" {
+		builder.WriteString(fmt.Sprintf("(cd %s && ", shellQuote(r.Dir)))
+	}
+	builder.WriteString(shellQuote(r.Name))
+	for _, arg := range r.Arguments {
+		builder.WriteString(" ")
+		builder.WriteString(shellQuote(arg))
+	}
+	if r.Dir != "" {
+		builder.WriteString(")")
+	}
+	return builder.String()
+}
+
+// redacted replaces recorded env values which come from secrets.
+const redacted = "***"
+
+// RecordingCommandRunner records commands instead of running them, along with the env they'd be given.
+// Arguments are recorded as they are, and env read from secrets is redacted.
+type RecordingCommandRunner struct {
+	lock     *sync.Mutex
+	commands *[]RecordedCommand
+	files    *[]RecordedFile
+	secrets  map[string]bool
+}
+
+func NewRecordingCommandRunner() RecordingCommandRunner {
+	return RecordingCommandRunner{
+		lock:     &sync.Mutex{},
+		commands: &[]RecordedCommand{},
+		files:    &[]RecordedFile{},
+		secrets:  map[string]bool{},
+	}
+}
+
+// Redact records the environment variables as *** wherever they're referenced.
+func (r RecordingCommandRunner) Redact(keys ...string) RecordingCommandRunner {
+	secrets := map[string]bool{}
+	for key := range r.secrets {
+		secrets[key] = true
+	}
+	for _, key := range keys {
+		secrets[key] = true
+	}
+	r.secrets = secrets
+	return r
+}
+
+func (r RecordingCommandRunner) Run(name string, args ...string) error {
+	return r.RunWith(ExecOptions{}, name, args...)
+}
+
+// RunWith records the command's env along with the variables its arguments reference, when they're expanded.
+func (r RecordingCommandRunner) RunWith(options ExecOptions, name string, args ...string) error {
+	var referenced map[string]string
+	if options.ExpandArgs {
+		referenced = ReferencedEnv(args)
+	}
+	return r.record(options.Dir, referenced, options.Env, name, args)
+}
+
+func (r RecordingCommandRunner) OutputWith(options ExecOptions, name string, args ...string) (string, error) {
+	return "", r.RunWith(options, name, args...)
+}
+
+func (r RecordingCommandRunner) record(dir string, referenced map[string]string, env map[string]string, name string, args []string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	command := RecordedCommand{
+		Name:      name,
+		Arguments: args,
+		Dir:       dir,
+	}
+	recordedEnv := map[string]string{}
+	for key := range referenced {
+		recordedEnv[key] = r.resolve("${" + key + "}")
+	}
+	for key, value := range env {
+		recordedEnv[key] = r.resolve(value)
+	}
+	if len(recordedEnv) > 0 {
+		command.Env = recordedEnv
+	}
+	*r.commands = append(*r.commands, command)
+	return nil
+}
+
+// resolve expands references in an env value, redacting it entirely if it references a secret.
+func (r RecordingCommandRunner) resolve(value string) string {
+	secret := false
+	resolved := expandEnv(value, func(key string) string {
+		secret = secret || r.secrets[key]
+		return os.Getenv(key)
+	})
+	if secret {
+		return redacted
+	}
+	return resolved
+}
+
+// CheckEndpoint records the request as a GET command, with the response it expects.
+func (r RecordingCommandRunner) CheckEndpoint(check EndpointCheck) error {
+	return r.record("", nil, nil, "GET", check.args())
+}
+
+// SkipsChecks is true, since recorded commands produce no output.
+func (r RecordingCommandRunner) SkipsChecks() bool {
+	return true
+}
+
+// WriteFile records the file with its content resolved, redacting values read from secrets.
+// Temporary files are recorded by the variable commands reference them with.
+func (r RecordingCommandRunner) WriteFile(file File) (File, error) {
+	if file.Var != "" {
+		file.Path = "$" + file.Var
+	}
+	content, err := file.Resolve(func(key string) string {
+		if r.secrets[key] {
+			return redacted
+		}
+		return os.Getenv(key)
+	})
+	if err != nil {
+		return File{}, err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	*r.files = append(*r.files, RecordedFile{Path: file.Path, Content: content})
+	return file, nil
+}
+
+func (r RecordingCommandRunner) RemoveFile(file File) error {
+	return nil
+}
+
+func (r RecordingCommandRunner) RunSilent(name string, args ...string) error {
+	return r.Run(name, args...)
+}
+
+func (r RecordingCommandRunner) Output(name string, args ...string) (string, error) {
+	return "", r.Run(name, args...)
+}
+
+func (r RecordingCommandRunner) Commands() []RecordedCommand {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RecordedCommand{}, *r.commands...)
+}
+
+func (r RecordingCommandRunner) Files() []RecordedFile {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var files []RecordedFile
+	return append(files, *r.files...)
+}
+
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(arg, " \t\n'\"$`\\|&;<>(){}*?[]#~") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// withEnv prefixes a shell command with runtime args passed through `env`, since keys aren't always valid shell identifiers.
+// Values are inserted as is, so they must already be quoted.
+func withEnv(runtimeArgs []RuntimeArg, command string) string {
+	parts := []string{"env"}
+	for _, arg := range runtimeArgs {
+		if arg.IsEnv() {
+			parts = append(parts, fmt.Sprintf("%s=%s", shellQuote(arg.EnvKey()), arg.Value))
+		}
+	}
+	if len(parts) == 1 {
+		return command
+	}
+	parts = append(parts, command)
+	return strings.Join(parts, " \\\n  ")
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
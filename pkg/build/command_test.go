@@ -2,6 +2,9 @@ package build
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/itura/fun/pkg/build/mocks"
@@ -69,3 +72,106 @@ func TestApplySideEffectsReturnsFirstError(t *testing.T) {
 	runner.AssertExpectations(t)
 
 }
+
+func TestApplySideEffectsWithFilesRequiresFileWriter(t *testing.T) {
+	runner := new(mocks.CommandRunner)
+	sideEffects := NewSideEffects(NewCommand("name", "arg1")).
+		AddFile(File{Path: "values.yaml", Content: "key: $VALUE\n"})
+
+	err := sideEffects.Apply(runner)
+
+	assert.EqualError(t, err, "*mocks.CommandRunner can't write files")
+	runner.AssertNotCalled(t, "Run")
+	runner.AssertExpectations(t)
+}
+
+func TestShellCommandRunnerWritesTemporaryFiles(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("VALUE", "cool-api")
+	runner := ShellCommandRunner{}
+
+	written, err := runner.WriteFile(File{Path: "api-values-*.yaml", Var: "API_VALUES_FILE", Content: "key: $VALUE\n"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, os.TempDir(), filepath.Dir(written.Path))
+	assert.True(t, strings.HasPrefix(filepath.Base(written.Path), "api-values-"))
+	assert.NotEqual(t, "api-values-*.yaml", filepath.Base(written.Path))
+	content, err := os.ReadFile(written.Path)
+	assert.Nil(t, err)
+	assert.Equal(t, "key: cool-api\n", string(content))
+
+	assert.Nil(t, runner.RemoveFile(written))
+	_, err = os.Stat(written.Path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApplySideEffectsWithEnvRequiresExecCommandRunner(t *testing.T) {
+	runner := new(mocks.CommandRunner)
+	sideEffects := NewSideEffects(NewCommand("name", "arg1").SetEnv("TF_VAR_key", "$key"))
+
+	err := sideEffects.Apply(runner)
+
+	assert.EqualError(t, err, "*mocks.CommandRunner can't run commands with env")
+	runner.AssertNotCalled(t, "Run")
+	runner.AssertExpectations(t)
+}
+
+func TestApplySideEffectsWithDirRequiresExecCommandRunner(t *testing.T) {
+	runner := new(mocks.CommandRunner)
+	sideEffects := NewSideEffects(NewCommand("kustomize", "edit").SetDir("k8s"))
+
+	err := sideEffects.Apply(runner)
+
+	assert.EqualError(t, err, "*mocks.CommandRunner can't run commands in a directory")
+	runner.AssertNotCalled(t, "Run")
+	runner.AssertExpectations(t)
+}
+
+func TestApplySideEffectsKeepsEnvAndDirTogether(t *testing.T) {
+	runner := NewRecordingCommandRunner()
+	sideEffects := NewSideEffects(
+		NewCommand("terraform", "plan").SetEnv("TF_VAR_key", "value").SetDir("tf/main"),
+		NewCommand("terraform", "output", "-raw", "url").SetEnv("TF_VAR_key", "value").SetVars(DigestVars{Name: "URL"}),
+	)
+
+	err := sideEffects.Apply(runner)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []RecordedCommand{
+		{Name: "terraform", Arguments: []string{"plan"}, Env: map[string]string{"TF_VAR_key": "value"}, Dir: "tf/main"},
+		{Name: "terraform", Arguments: []string{"output", "-raw", "url"}, Env: map[string]string{"TF_VAR_key": "value"}},
+	}, runner.Commands())
+}
+
+func TestApplySideEffectsChecksOutputUnlessRecording(t *testing.T) {
+	sideEffects := NewSideEffects(
+		NewCommand("terraform", "show", "-json", "plan.out").SetCheck(PlanCheck{ApplicationId: "infra"}),
+	)
+
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "terraform", "show", "-json", "plan.out").Return("", nil)
+	err := sideEffects.Apply(runner)
+	assert.EqualError(t, err, "no plan to check for infra")
+	runner.AssertExpectations(t)
+
+	err = sideEffects.Apply(NewRecordingCommandRunner())
+	assert.Nil(t, err)
+}
+
+func TestApplySideEffectsRunsOnFailureCommands(t *testing.T) {
+	runner := new(mocks.CommandRunner)
+	sideEffects := NewSideEffects(
+		NewCommand("helm", "upgrade", "api-canary"),
+		NewCommand("sh", "-c", "exit 1"),
+		NewCommand("helm", "upgrade", "api"),
+	).AddOnFailure(NewCommand("helm", "uninstall", "api-canary"))
+
+	runner.On("Run", "helm", "upgrade", "api-canary").Return(nil)
+	runner.On("Run", "sh", "-c", "exit 1").Return(fmt.Errorf("exit status 1"))
+	runner.On("Run", "helm", "uninstall", "api-canary").Return(nil)
+
+	err := sideEffects.Apply(runner)
+	assert.EqualError(t, err, "exit status 1")
+	runner.AssertExpectations(t)
+	runner.AssertNotCalled(t, "Run", "helm", "upgrade", "api")
+}
@@ -1,7 +1,10 @@
 package build
 
 import (
+	"os"
+
 	"github.com/itura/fun/pkg/fun"
+	"gopkg.in/yaml.v3"
 )
 
 type Job interface {
@@ -14,6 +17,10 @@ type PipelineConfig struct {
 	Dependencies Dependencies
 	BuildName    string
 	Error        error
+	// ChangeDetection describes what changes were compared against
+	ChangeDetection string
+	Triggers        TriggersConfig
+	Environments    []Environment
 }
 
 func NewParsedConfig() PipelineConfig {
@@ -57,7 +64,34 @@ func (c PipelineConfig) SetBuildName(name string) PipelineConfig {
 	return c
 }
 
+func (c PipelineConfig) SetChangeDetection(description string) PipelineConfig {
+	c.ChangeDetection = description
+	return c
+}
+
+func (c PipelineConfig) SetEnvironments(environments []Environment) PipelineConfig {
+	c.Environments = environments
+	return c
+}
+
+func (c PipelineConfig) SetTriggers(triggers TriggersConfig) PipelineConfig {
+	c.Triggers = triggers
+	return c
+}
+
 func (c PipelineConfig) SetError(err error) PipelineConfig {
 	c.Error = err
 	return c
 }
+
+func writeYaml(path string, value interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := yaml.NewEncoder(file)
+	encoder.SetIndent(2)
+	return encoder.Encode(value)
+}
@@ -3,8 +3,12 @@ package build
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/itura/fun/pkg/fun"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,13 +29,27 @@ func parseConfig(args ActionArgs, cd ChangeDetection) PipelineConfig {
 		config.Resources.ArtifactRepository.Name,
 	)
 	dependencies := ParseDependencies(config)
+	if shared, ok := cd.(SharedChangeDetection); ok {
+		cd = shared.WithSharedWatch(config.WatchPatterns()...)
+	}
 	artifacts := CreateArtifacts(args, cd, config, artifactRepository)
-	applications, err := CreateApplications(args, cd, config, artifactRepository, dependencies)
+	environmentConfig, err := config.ForEnvironment(args.Environment)
+	if err != nil {
+		return FailedParse(config.Name, err)
+	}
+	applications, err := CreateApplications(args, cd, environmentConfig, artifactRepository, dependencies)
+	if err != nil {
+		return FailedParse(config.Name, err)
+	}
+	environments, err := CreateEnvironments(args, cd, config, artifactRepository, dependencies)
 	if err != nil {
 		return FailedParse(config.Name, err)
 	}
 
-	return SuccessfulParse(config.Name, artifacts, applications, dependencies)
+	return SuccessfulParse(config.Name, artifacts, applications, dependencies).
+		SetEnvironments(environments).
+		SetTriggers(config.Triggers).
+		SetChangeDetection(cd.Describe())
 }
 
 func readFile(configPath string) (PipelineConfigRaw, error) {
@@ -52,13 +70,146 @@ func readFile(configPath string) (PipelineConfigRaw, error) {
 type PipelineConfigRaw struct {
 	Name         string    `validate:"required"`
 	Resources    Resources `validate:"required"`
+	Triggers     TriggersConfig
+	Watch        []string
+	Environments EnvironmentConfigs
 	Artifacts    []ArtifactConfig
 	Applications []ApplicationConfig
 }
 
+// WatchPatterns are the shared watch patterns, .github/ unless the config sets its own.
+func (p PipelineConfigRaw) WatchPatterns() []string {
+	if len(p.Watch) == 0 {
+		return defaultSharedWatch
+	}
+	return p.Watch
+}
+
 type ArtifactConfig struct {
-	Id   string
-	Path string
+	Id    string
+	Path  string
+	Type  ArtifactType
+	Watch []string
+	// Platforms are the os/arch pairs a go-binary is cross-compiled for, defaulting to linux/amd64,
+	// or that an app image is built for with docker buildx
+	Platforms []string
+	Docker    DockerOptions
+}
+
+func (a ArtifactConfig) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if a.Type != artifactTypeGoBinary && a.Type != artifactTypeApp && len(a.Platforms) > 0 {
+		errs = errs.Put("platforms", fmt.Errorf("only applies to app and go-binary artifacts"))
+	}
+	if a.Type != artifactTypeApp && a.Type != artifactTypeLib && !a.Docker.IsEmpty() {
+		errs = errs.Put("docker", fmt.Errorf("only applies to app and lib artifacts"))
+	}
+	if a.Type == artifactTypeLib && (a.Docker.Sbom || a.Docker.Provenance || !a.Docker.Sign.IsEmpty()) {
+		errs = errs.PutChild(NewValidationErrors("docker").
+			Put("sign", fmt.Errorf("sbom, provenance and sign only apply to app artifacts, since lib artifacts aren't pushed")))
+	}
+	if a.Type == artifactTypeLib && a.Docker.Targets.SkipTest {
+		errs = errs.PutChild(NewValidationErrors("docker").
+			PutChild(NewValidationErrors("targets").
+				Put("skipTest", fmt.Errorf("lib artifacts only build the test target"))))
+	}
+	for _, platform := range a.Platforms {
+		os, arch, found := strings.Cut(platform, "/")
+		if !found || os == "" || arch == "" {
+			errs = errs.Put("platforms", fmt.Errorf("'%s' must be of the form <os>/<arch>", platform))
+		}
+	}
+	return errs.PutChild(a.Docker.Validate("docker"))
+}
+
+// WatchPatterns are the paths whose changes rebuild the artifact. Go binaries also depend on the root module's dependencies.
+func (a ArtifactConfig) WatchPatterns() []string {
+	patterns := []string{a.Path}
+	if a.Type == artifactTypeGoBinary {
+		patterns = append(patterns, "go.mod", "go.sum")
+	}
+	return append(patterns, a.Watch...)
+}
+
+// DockerOptions configure how the images of app and lib artifacts are built. Paths are relative to the repository root.
+type DockerOptions struct {
+	// Dockerfile defaults to the Dockerfile in the artifact path
+	Dockerfile string
+	// Context defaults to the artifact path
+	Context string
+	Targets DockerTargets
+	// BuildArgs are passed with --build-arg, except secretValue args, which are passed as BuildKit secrets with their key as the id
+	BuildArgs []RuntimeArg `yaml:"buildArgs"`
+	// Cache builds with buildx, importing and exporting layers of both targets to a registry ref or the GitHub Actions cache
+	Cache DockerCacheType
+	// Sbom and Provenance build with buildx, attaching an SBOM and SLSA provenance attestation to the pushed app image
+	Sbom       bool
+	Provenance bool
+	// Sign signs the pushed app image with cosign
+	Sign SignOptions
+}
+
+// UsesBuildx is true when images are built with buildx, even for the runner's platform only.
+func (d DockerOptions) UsesBuildx(platforms []string) bool {
+	return len(platforms) > 0 || d.Cache != dockerCacheNone || d.Sbom || d.Provenance
+}
+
+// attestationArgs attach the SBOM and provenance of the image, which BuildKit only attaches to pushed images.
+func (d DockerOptions) attestationArgs() []string {
+	var args []string
+	if d.Sbom {
+		args = append(args, "--sbom=true")
+	}
+	if d.Provenance {
+		args = append(args, "--provenance=mode=max")
+	}
+	return args
+}
+
+type DockerTargets struct {
+	// Test defaults to test
+	Test string
+	// App defaults to app
+	App string
+	// SkipTest builds and pushes the app image without building and running the test image
+	SkipTest bool `yaml:"skipTest"`
+}
+
+func (d DockerOptions) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors("buildArgs")
+	for i, arg := range d.BuildArgs {
+		itemErrs := arg.Validate(strconv.Itoa(i))
+		if arg.StepOutput != "" || arg.ArtifactImage != "" {
+			itemErrs = itemErrs.Put("value", fmt.Errorf("only value, envValue and secretValue apply to build args"))
+		}
+		errs = errs.PutChild(itemErrs)
+	}
+	return NewValidationErrors(key).PutChild(errs).PutChild(d.Sign.Validate("sign"))
+}
+
+// ResolveGitHub resolves build args for GitHub Actions. Artifacts aren't built per environment, so they can't interpolate it.
+func (d DockerOptions) ResolveGitHub() DockerOptions {
+	var buildArgs []RuntimeArg
+	for _, arg := range d.BuildArgs {
+		buildArgs = append(buildArgs, arg.ResolveGitHub(""))
+	}
+	d.BuildArgs = buildArgs
+	return d
+}
+
+// Resolve resolves build args for building at sha, interpolating literal values in-process.
+func (d DockerOptions) Resolve(sha string) DockerOptions {
+	var buildArgs []RuntimeArg
+	for _, arg := range d.BuildArgs {
+		buildArgs = append(buildArgs, arg.Resolve(sha, ""))
+	}
+	d.BuildArgs = buildArgs
+	return d
+}
+
+func (d DockerOptions) IsEmpty() bool {
+	return d.Dockerfile == "" && d.Context == "" && d.Targets == (DockerTargets{}) && len(d.BuildArgs) == 0 &&
+		d.Cache == dockerCacheNone && !d.Sbom && !d.Provenance && d.Sign.IsEmpty()
 }
 
 type ApplicationConfig struct {
@@ -67,13 +218,692 @@ type ApplicationConfig struct {
 	Namespace    string
 	Artifacts    []string
 	Values       []RuntimeArg
-	Secrets      []SecretConfig
-	Dependencies []string
-	Type         ApplicationType
+	SetString    []RuntimeArg `yaml:"setString"`
+	SetFile      []RuntimeArg `yaml:"setFile"`
+	ValuesFiles  []string     `yaml:"valuesFiles"`
+	RenderValues bool         `yaml:"renderValues"`
+	ImageValues  bool         `yaml:"imageValues"`
+	// RequireSignedImages refuses to deploy artifact images without a signature
+	RequireSignedImages bool `yaml:"requireSignedImages"`
+	Helm                HelmConfig
+	Terraform           TerraformOptions
+	Manifests           ManifestOptions
+	Verify              VerifyOptions
+	Secrets             []SecretConfig
+	Dependencies        []string
+	Type                ApplicationType
+	Watch               []string
+}
+
+const defaultHelmVersion = "v3.10.2"
+
+// HelmConfig configures the release of a Helm application.
+type HelmConfig struct {
+	// ReleaseName defaults to the application id
+	ReleaseName string `yaml:"releaseName"`
+	// Timeout is a duration such as 10m, passed to helm upgrade and helm test
+	Timeout         string
+	WaitForJobs     bool `yaml:"waitForJobs"`
+	HistoryMax      int  `yaml:"historyMax"`
+	CreateNamespace bool `yaml:"createNamespace"`
+	// Chart is a remote chart reference, either repo/chart or oci://, used instead of the application path
+	Chart string
+	// Repository is the URL of the chart repository for Chart
+	Repository string
+	// Version pins the chart version of Chart
+	Version string
+	// Test runs helm test after the release
+	Test bool
+	// HelmVersion is the version of the Helm client installed in CI
+	HelmVersion string `yaml:"helmVersion"`
+	// Strategy deploys progressively instead of upgrading the release in place
+	Strategy HelmStrategy
+	// PinDigests sets artifact image values to image@digest, resolved from the commit tag when deploying
+	PinDigests bool `yaml:"pinDigests"`
+}
+
+func (h HelmConfig) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if h.Timeout != "" {
+		if _, err := time.ParseDuration(h.Timeout); err != nil {
+			errs = errs.Put("timeout", fmt.Errorf("'%s' is not a duration", h.Timeout))
+		}
+	}
+	if h.HistoryMax < 0 {
+		errs = errs.Put("historyMax", fmt.Errorf("must not be negative"))
+	}
+	if h.Chart == "" && h.Version != "" {
+		errs = errs.Put("version", fmt.Errorf("only applies to a remote chart"))
+	}
+	if h.Chart == "" && h.Repository != "" {
+		errs = errs.Put("repository", fmt.Errorf("only applies to a remote chart"))
+	}
+	if strings.HasPrefix(h.Chart, "oci://") && h.Repository != "" {
+		errs = errs.Put("repository", fmt.Errorf("not used with an OCI chart"))
+	}
+	return errs.PutChild(h.Strategy.Validate("strategy"))
+}
+
+func (h HelmConfig) Release(id string) string {
+	if h.ReleaseName == "" {
+		return id
+	}
+	return h.ReleaseName
+}
+
+func (h HelmConfig) ClientVersion() string {
+	if h.HelmVersion == "" {
+		return defaultHelmVersion
+	}
+	return h.HelmVersion
+}
+
+const (
+	defaultCanaryWeight      = 10
+	defaultCanaryWeightValue = "canary.weight"
+	defaultSlotKey           = "slot"
+	defaultCheckRetries      = 5
+)
+
+// HelmStrategy configures a progressive deploy. A canary release is deployed alongside the stable release
+// with a share of traffic, and promoted once its check passes or uninstalled when it fails.
+// Blue/green keeps a release per slot, deploys the idle one, and flips the Service selector to it once its check passes.
+type HelmStrategy struct {
+	Type HelmStrategyType
+	// Weight is the percent of traffic sent to the canary release, set with the WeightValue chart value; 0 uses defaultCanaryWeight
+	Weight      int
+	WeightValue string `yaml:"weightValue"`
+	// Service is the Service whose selector is flipped between the blue and green releases
+	Service string
+	// SlotKey is both the chart value each slot's release is deployed with, and the label the Service selects on
+	SlotKey string `yaml:"slotKey"`
+	Check   HealthCheck
+}
+
+func (h HelmStrategy) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if h.Type != helmStrategyCanary {
+		if h.Weight != 0 {
+			errs = errs.Put("weight", fmt.Errorf("only applies to the canary strategy"))
+		}
+		if h.WeightValue != "" {
+			errs = errs.Put("weightValue", fmt.Errorf("only applies to the canary strategy"))
+		}
+	}
+	if h.Type != helmStrategyBlueGreen {
+		if h.Service != "" {
+			errs = errs.Put("service", fmt.Errorf("only applies to the blue-green strategy"))
+		}
+		if h.SlotKey != "" {
+			errs = errs.Put("slotKey", fmt.Errorf("only applies to the blue-green strategy"))
+		}
+	}
+
+	switch h.Type {
+	case helmStrategyInPlace:
+		if h.Check != (HealthCheck{}) {
+			errs = errs.Put("check", fmt.Errorf("only applies to the canary and blue-green strategies"))
+		}
+	case helmStrategyCanary:
+		if h.Weight < 0 || h.Weight > 100 {
+			errs = errs.Put("weight", fmt.Errorf("must be between 0 and 100, 0 uses the default of %d", defaultCanaryWeight))
+		}
+		if h.Check.IsEmpty() {
+			errs = errs.Put("check", fmt.Errorf("a canary can't be promoted without a check"))
+		}
+	case helmStrategyBlueGreen:
+		if h.Service == "" {
+			errs = errs.Put("service", eMissingRequiredField)
+		}
+	}
+	return errs.PutChild(h.Check.Validate("check"))
+}
+
+func (h HelmStrategy) CanaryWeight() int {
+	if h.Weight == 0 {
+		return defaultCanaryWeight
+	}
+	return h.Weight
+}
+
+func (h HelmStrategy) CanaryWeightValue() string {
+	if h.WeightValue == "" {
+		return defaultCanaryWeightValue
+	}
+	return h.WeightValue
+}
+
+func (h HelmStrategy) Slot() string {
+	if h.SlotKey == "" {
+		return defaultSlotKey
+	}
+	return h.SlotKey
+}
+
+// HealthCheck decides whether a new release is promoted, with either a command run through sh or an HTTP probe.
+type HealthCheck struct {
+	Command string
+	// Http is a URL which must respond successfully, retried while the release warms up
+	Http    string
+	Retries int
+}
+
+func (h HealthCheck) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if h.Command != "" && h.Http != "" {
+		errs = errs.Put("http", fmt.Errorf("only one of command and http may be set"))
+	}
+	if h.Retries < 0 {
+		errs = errs.Put("retries", fmt.Errorf("must not be negative"))
+	}
+	if h.Retries != 0 && h.Http == "" {
+		errs = errs.Put("retries", fmt.Errorf("only applies to http checks"))
+	}
+	return errs
+}
+
+func (h HealthCheck) IsEmpty() bool {
+	return h.Command == "" && h.Http == ""
+}
+
+// Commands checks the release, with $VAR references in the command or URL resolved when it's run.
+func (h HealthCheck) Commands() []Command {
+	switch {
+	case h.Command != "":
+		return []Command{NewCommand("sh", "-c", h.Command)}
+	case h.Http != "":
+		retries := h.Retries
+		if retries == 0 {
+			retries = defaultCheckRetries
+		}
+		return []Command{NewCommand("curl", "--fail", "--silent", "--show-error",
+			"--retry", strconv.Itoa(retries), "--retry-all-errors", "--retry-delay", "5", h.Http)}
+	default:
+		return nil
+	}
+}
+
+const defaultTerraformVersion = "1.5.7"
+
+// TerraformOptions configure how a Terraform application is planned and applied.
+// Workspace, backend config values, var files and the apply environment may interpolate {{ environment }}.
+type TerraformOptions struct {
+	// Workspace is selected, or created, before planning. It defaults to the environment name
+	Workspace     string
+	BackendConfig map[string]string `yaml:"backendConfig"`
+	// VarFiles are relative to the application path
+	VarFiles []string `yaml:"varFiles"`
+	// ApplyEnvironment splits generated workflows into plan and apply jobs, with apply waiting on approval of the GitHub environment
+	ApplyEnvironment string `yaml:"applyEnvironment"`
+	// TerraformVersion is the version of Terraform installed in CI
+	TerraformVersion string `yaml:"terraformVersion"`
+	// PreventDestroy refuses to apply plans which delete or replace resources, unless run with --allow-destroy
+	PreventDestroy bool `yaml:"preventDestroy"`
+}
+
+// ForEnvironment interpolates the environment name, and defaults the workspace to it.
+func (t TerraformOptions) ForEnvironment(environment string) TerraformOptions {
+	interpolate := func(value string) string {
+		return RuntimeArg{Value: value}.Interpolate("{{ sha }}", environment).Value
+	}
+	if t.Workspace == "" {
+		t.Workspace = environment
+	}
+	t.Workspace = interpolate(t.Workspace)
+	t.ApplyEnvironment = interpolate(t.ApplyEnvironment)
+
+	if t.BackendConfig != nil {
+		backendConfig := map[string]string{}
+		for key, value := range t.BackendConfig {
+			backendConfig[key] = interpolate(value)
+		}
+		t.BackendConfig = backendConfig
+	}
+
+	var varFiles []string
+	for _, path := range t.VarFiles {
+		varFiles = append(varFiles, interpolate(path))
+	}
+	t.VarFiles = varFiles
+	return t
+}
+
+func (t TerraformOptions) IsEmpty() bool {
+	return t.Workspace == "" && len(t.BackendConfig) == 0 && len(t.VarFiles) == 0 &&
+		t.ApplyEnvironment == "" && t.TerraformVersion == "" && !t.PreventDestroy
+}
+
+func (t TerraformOptions) ClientVersion() string {
+	if t.TerraformVersion == "" {
+		return defaultTerraformVersion
+	}
+	return t.TerraformVersion
+}
+
+const defaultKustomizeVersion = "5.1.1"
+
+// ManifestOptions configure how kustomize and kubectl applications are applied.
+type ManifestOptions struct {
+	// PruneLabels select the resources deleted when they're no longer in the manifests
+	PruneLabels map[string]string `yaml:"pruneLabels"`
+	// Rollouts are resources, such as deployment/api, whose rollout is waited for after applying
+	Rollouts []string
+	// Timeout is a duration such as 5m, passed to kubectl rollout status
+	Timeout string
+	// KustomizeVersion is the version of kustomize installed in CI
+	KustomizeVersion string `yaml:"kustomizeVersion"`
+}
+
+func (m ManifestOptions) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if m.Timeout != "" {
+		if _, err := time.ParseDuration(m.Timeout); err != nil {
+			errs = errs.Put("timeout", fmt.Errorf("'%s' is not a duration", m.Timeout))
+		}
+	}
+	return validateRollouts(errs, m.Rollouts)
+}
+
+// PruneSelector is the label selector for PruneLabels, or empty when nothing is pruned.
+func (m ManifestOptions) PruneSelector() string {
+	var labels []string
+	for _, key := range sortedKeys(m.PruneLabels) {
+		labels = append(labels, fmt.Sprintf("%s=%s", key, m.PruneLabels[key]))
+	}
+	return strings.Join(labels, ",")
+}
+
+func (m ManifestOptions) IsEmpty() bool {
+	return len(m.PruneLabels) == 0 && len(m.Rollouts) == 0 && m.Timeout == "" && m.KustomizeVersion == ""
+}
+
+func (m ManifestOptions) ClientVersion() string {
+	if m.KustomizeVersion == "" {
+		return defaultKustomizeVersion
+	}
+	return m.KustomizeVersion
+}
+
+// RuntimeValues returns values, setString values and setFile values, with their Helm flags.
+func (a ApplicationConfig) RuntimeValues() []RuntimeArg {
+	return runtimeValues(a.Values, a.SetString, a.SetFile)
+}
+
+// imageArtifacts are the ids of the Docker artifacts the application deploys images of.
+func (a ApplicationConfig) imageArtifacts(config PipelineConfigRaw) []string {
+	ids := dockerArtifacts(config, a.Artifacts)
+	for _, value := range a.RuntimeValues() {
+		if value.ArtifactImage != "" && !fun.Contains(ids, value.ArtifactImage) {
+			ids = append(ids, value.ArtifactImage)
+		}
+	}
+	return ids
+}
+
+// readsRegistry is true when deploying reads image digests from the artifact repository, to pin them,
+// pass them as image values, or verify their signatures.
+func (a ApplicationConfig) readsRegistry() bool {
+	return a.Helm.PinDigests || a.ImageValues || a.RequireSignedImages
+}
+
+func (a ApplicationConfig) hasArtifactImages() bool {
+	for _, value := range a.RuntimeValues() {
+		if value.ArtifactImage != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func runtimeValues(values []RuntimeArg, setString []RuntimeArg, setFile []RuntimeArg) []RuntimeArg {
+	results := append([]RuntimeArg{}, values...)
+	for _, arg := range setString {
+		arg.Flag = helmSetString
+		results = append(results, arg)
+	}
+	for _, arg := range setFile {
+		arg.Flag = helmSetFile
+		results = append(results, arg)
+	}
+	return results
 }
 
 func (p PipelineConfigRaw) Validate(key string) ValidationErrors {
-	return NewValidationErrors(key).Validate(p)
+	errs := ValidateDependencies(NewValidationErrors(key).Validate(p), p)
+	errs = ValidateArtifacts(errs, p)
+	errs = ValidateApplications(errs, p)
+	return ValidateEnvironments(errs, p)
+}
+
+// ForEnvironment returns the config with an environment's overrides applied. An empty name returns the config unchanged.
+func (p PipelineConfigRaw) ForEnvironment(name string) (PipelineConfigRaw, error) {
+	if name == "" {
+		return p, nil
+	}
+	environment, present := p.Environments.Get(name)
+	if !present {
+		return PipelineConfigRaw{}, fmt.Errorf("invalid environment %s", name)
+	}
+
+	if environment.KubernetesCluster != nil {
+		p.Resources.KubernetesCluster = *environment.KubernetesCluster
+	}
+	if len(environment.SecretProviders) > 0 {
+		p.Resources.SecretProviders = environment.SecretProviders
+	}
+
+	var applications []ApplicationConfig
+	for _, application := range p.Applications {
+		application = environment.Apply(application)
+		application.RequireSignedImages = application.RequireSignedImages || environment.RequireSignedImages
+		applications = append(applications, application)
+	}
+	p.Applications = applications
+	return p, nil
+}
+
+// TriggersConfig defines the events that start the generated workflow. Without any, it runs on push to trunk.
+type TriggersConfig struct {
+	Push             *EventTriggerConfig
+	PullRequest      *PullRequestTriggerConfig `yaml:"pullRequest"`
+	Schedule         ScheduleTriggerConfigs
+	WorkflowDispatch *WorkflowDispatchConfig `yaml:"workflowDispatch"`
+}
+
+func (t TriggersConfig) IsEmpty() bool {
+	return t.Push == nil && t.PullRequest == nil && len(t.Schedule) == 0 && t.WorkflowDispatch == nil
+}
+
+func (t TriggersConfig) Validate(key string) ValidationErrors {
+	return NewValidationErrors(key).Validate(t)
+}
+
+type EventTriggerConfig struct {
+	Branches []string
+	Tags     []string
+	Paths    []string
+}
+
+type PullRequestTriggerConfig struct {
+	EventTriggerConfig `yaml:",inline"`
+	// BuildApp also builds artifacts' app images when verifying pull requests
+	BuildApp bool `yaml:"buildApp"`
+}
+
+type ScheduleTriggerConfig struct {
+	Cron string `validate:"required"`
+	// Drift makes scheduled runs check Terraform applications for drift instead of deploying
+	Drift bool
+}
+
+type ScheduleTriggerConfigs []ScheduleTriggerConfig
+
+func (s ScheduleTriggerConfigs) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	for i, schedule := range s {
+		errs = errs.PutChild(NewValidationErrors(strconv.Itoa(i)).Validate(schedule))
+	}
+	return errs
+}
+
+type WorkflowDispatchConfig struct {
+	Inputs map[string]WorkflowDispatchInput
+}
+
+var workflowDispatchInputTypes = []string{"string", "boolean", "choice", "number", "environment"}
+
+func (w WorkflowDispatchConfig) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	inputErrs := NewValidationErrors("inputs")
+	for _, name := range sortedKeys(w.Inputs) {
+		input := w.Inputs[name]
+		if input.Type != "" && !fun.Contains(workflowDispatchInputTypes, input.Type) {
+			inputErrs = inputErrs.Put(name, InvalidInputType(input.Type))
+		}
+		if input.Type == "choice" && len(input.Options) == 0 {
+			inputErrs = inputErrs.Put(name, fmt.Errorf("choice input requires options"))
+		}
+	}
+	return errs.PutChild(inputErrs)
+}
+
+type WorkflowDispatchInput struct {
+	Description string   `yaml:"description,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Type        string   `yaml:"type,omitempty"`
+	Options     []string `yaml:"options,omitempty"`
+}
+
+type EnvironmentConfig struct {
+	Name              string                `validate:"required"`
+	KubernetesCluster *ClusterConfig        `yaml:"kubernetesCluster"`
+	SecretProviders   SecretProviderConfigs `yaml:"secretProviders"`
+	// RequireApproval gates deploys on approval of the GitHub environment with the same name
+	RequireApproval bool `yaml:"requireApproval"`
+	// RequireSignedImages refuses to deploy unsigned artifact images to the environment
+	RequireSignedImages bool `yaml:"requireSignedImages"`
+	Applications        map[string]EnvironmentApplicationConfig
+}
+
+// Apply overrides an application's namespace, and values and secrets with the same key.
+// Values files are layered after the application's own.
+func (e EnvironmentConfig) Apply(application ApplicationConfig) ApplicationConfig {
+	overrides, present := e.Applications[application.Id]
+	if !present {
+		return application
+	}
+
+	if overrides.Namespace != "" {
+		application.Namespace = overrides.Namespace
+	}
+	argKey := func(arg RuntimeArg) string {
+		return arg.Key
+	}
+	application.Values = mergeByKey(application.Values, overrides.Values, argKey)
+	application.SetString = mergeByKey(application.SetString, overrides.SetString, argKey)
+	application.SetFile = mergeByKey(application.SetFile, overrides.SetFile, argKey)
+	application.ValuesFiles = append(append([]string{}, application.ValuesFiles...), overrides.ValuesFiles...)
+	application.Secrets = mergeByKey(application.Secrets, overrides.Secrets, func(secret SecretConfig) string {
+		return secret.Key
+	})
+	return application
+}
+
+func mergeByKey[T any](values []T, overrides []T, key func(T) string) []T {
+	results := append([]T{}, values...)
+	for _, override := range overrides {
+		replaced := false
+		for i, value := range results {
+			if key(value) == key(override) {
+				results[i] = override
+				replaced = true
+			}
+		}
+		if !replaced {
+			results = append(results, override)
+		}
+	}
+	return results
+}
+
+type EnvironmentApplicationConfig struct {
+	Namespace   string
+	Values      []RuntimeArg
+	SetString   []RuntimeArg `yaml:"setString"`
+	SetFile     []RuntimeArg `yaml:"setFile"`
+	ValuesFiles []string     `yaml:"valuesFiles"`
+	Secrets     []SecretConfig
+}
+
+func (e EnvironmentApplicationConfig) RuntimeValues() []RuntimeArg {
+	return runtimeValues(e.Values, e.SetString, e.SetFile)
+}
+
+// EnvironmentConfigs are listed in order of promotion.
+type EnvironmentConfigs []EnvironmentConfig
+
+func (e EnvironmentConfigs) Get(name string) (EnvironmentConfig, bool) {
+	for _, environment := range e {
+		if environment.Name == name {
+			return environment, true
+		}
+	}
+	return EnvironmentConfig{}, false
+}
+
+func (e EnvironmentConfigs) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	for i, environment := range e {
+		errs = errs.PutChild(NewValidationErrors(strconv.Itoa(i)).Validate(environment))
+	}
+	return errs
+}
+
+var validEnvironmentName = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+// ValidateEnvironments checks environment names are unique and usable in job ids, and that overrides reference applications.
+func ValidateEnvironments(errs ValidationErrors, config PipelineConfigRaw) ValidationErrors {
+	applicationIds := map[string]bool{}
+	for _, application := range config.Applications {
+		applicationIds[application.Id] = true
+	}
+
+	names := map[string]bool{}
+	environmentErrs := NewValidationErrors("environments")
+	for i, environment := range config.Environments {
+		itemErrs := NewValidationErrors(strconv.Itoa(i))
+		if names[environment.Name] {
+			itemErrs = itemErrs.Put("name", fmt.Errorf("duplicate environment '%s'", environment.Name))
+		} else if environment.Name != "" && !validEnvironmentName.MatchString(environment.Name) {
+			itemErrs = itemErrs.Put("name", fmt.Errorf("'%s' may only contain letters, digits, '-' and '_'", environment.Name))
+		}
+		names[environment.Name] = true
+
+		for _, id := range sortedKeys(environment.Applications) {
+			if !applicationIds[id] {
+				itemErrs = itemErrs.Put("applications", fmt.Errorf("unknown application '%s'", id))
+			}
+		}
+		environmentErrs = environmentErrs.PutChild(itemErrs)
+	}
+
+	return errs.PutChild(environmentErrs)
+}
+
+func ValidateArtifacts(errs ValidationErrors, config PipelineConfigRaw) ValidationErrors {
+	artifactErrs := NewValidationErrors("artifacts")
+	for _, artifact := range config.Artifacts {
+		artifactErrs = artifactErrs.PutChild(artifact.Validate(artifact.Id))
+	}
+	return errs.PutChild(artifactErrs)
+}
+
+// ValidateApplications checks each application's Helm and manifest settings, and that each value, including environment overrides,
+// has a single source, and that artifact images reference Docker artifacts.
+func ValidateApplications(errs ValidationErrors, config PipelineConfigRaw) ValidationErrors {
+	artifactTypes := map[string]ArtifactType{}
+	artifactSigning := map[string]SignOptions{}
+	for _, artifact := range config.Artifacts {
+		artifactTypes[artifact.Id] = artifact.Type
+		artifactSigning[artifact.Id] = artifact.Docker.Sign
+	}
+	unsigned := func(application ApplicationConfig) []string {
+		var ids []string
+		for _, id := range application.imageArtifacts(config) {
+			if artifactSigning[id].IsEmpty() {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+
+	applicationErrs := NewValidationErrors("applications")
+	for _, application := range config.Applications {
+		itemErrs := NewValidationErrors(application.Id)
+		if application.Type != applicationTypeHelm && application.Helm != (HelmConfig{}) {
+			itemErrs = itemErrs.Put("helm", fmt.Errorf("only applies to helm applications"))
+		}
+		if application.Type != applicationTypeTerraform && !application.Terraform.IsEmpty() {
+			itemErrs = itemErrs.Put("terraform", fmt.Errorf("only applies to terraform applications"))
+		}
+		if !application.Type.HasManifests() && !application.Manifests.IsEmpty() {
+			itemErrs = itemErrs.Put("manifests", fmt.Errorf("only applies to kustomize and kubectl applications"))
+		}
+		if application.ImageValues && application.Type.HasManifests() {
+			itemErrs = itemErrs.Put("imageValues", fmt.Errorf("only applies to helm and terraform applications"))
+		}
+		if application.RequireSignedImages {
+			for _, id := range unsigned(application) {
+				itemErrs = itemErrs.Put("requireSignedImages", fmt.Errorf("artifact '%s' isn't signed", id))
+			}
+		}
+		if application.Helm.PinDigests && !application.hasArtifactImages() {
+			itemErrs = itemErrs.Put("helm", fmt.Errorf("pinDigests pins artifactImage values, so it requires one"))
+		}
+		if application.Type.HasManifests() && application.Verify.Rollback &&
+			len(application.Manifests.Rollouts) == 0 && len(application.Verify.Rollouts) == 0 {
+			itemErrs = itemErrs.Put("verify", fmt.Errorf("rollback undoes rollouts, so it requires rollouts"))
+		}
+		applicationErrs = applicationErrs.PutChild(itemErrs.
+			PutChild(application.Helm.Validate("helm")).
+			PutChild(application.Manifests.Validate("manifests")).
+			PutChild(application.Verify.Validate("verify", application.Type)).
+			PutChild(validateValues("values", application.Values, artifactTypes)).
+			PutChild(validateValues("setString", application.SetString, artifactTypes)).
+			PutChild(validateValues("setFile", application.SetFile, artifactTypes)))
+	}
+
+	environmentErrs := NewValidationErrors("environments")
+	for i, environment := range config.Environments {
+		itemErrs := NewValidationErrors(strconv.Itoa(i))
+		// an environment only requires signatures of the images it deploys, with its overrides applied.
+		// Those an application requires itself are reported under the application
+		if environment.RequireSignedImages {
+			for _, application := range config.Applications {
+				var reported []string
+				if application.RequireSignedImages {
+					reported = unsigned(application)
+				}
+				for _, id := range unsigned(environment.Apply(application)) {
+					if !fun.Contains(reported, id) {
+						itemErrs = itemErrs.Put("requireSignedImages",
+							fmt.Errorf("application '%s' deploys artifact '%s', which isn't signed", application.Id, id))
+					}
+				}
+			}
+		}
+		overrideErrs := NewValidationErrors("applications")
+		for _, id := range sortedKeys(environment.Applications) {
+			overrides := environment.Applications[id]
+			overrideErrs = overrideErrs.PutChild(NewValidationErrors(id).
+				PutChild(validateValues("values", overrides.Values, artifactTypes)).
+				PutChild(validateValues("setString", overrides.SetString, artifactTypes)).
+				PutChild(validateValues("setFile", overrides.SetFile, artifactTypes)))
+		}
+		environmentErrs = environmentErrs.PutChild(itemErrs.PutChild(overrideErrs))
+	}
+
+	return errs.
+		PutChild(applicationErrs).
+		PutChild(environmentErrs)
+}
+
+func validateValues(key string, values []RuntimeArg, artifactTypes map[string]ArtifactType) ValidationErrors {
+	errs := NewValidationErrors(key)
+	for i, value := range values {
+		itemErrs := value.Validate(strconv.Itoa(i))
+		if value.ArtifactImage != "" {
+			artifactType, present := artifactTypes[value.ArtifactImage]
+			if !present {
+				itemErrs = itemErrs.Put("artifactImage", fmt.Errorf("unknown artifact '%s'", value.ArtifactImage))
+			} else if artifactType != artifactTypeApp {
+				itemErrs = itemErrs.Put("artifactImage", fmt.Errorf("artifact '%s' isn't a docker image", value.ArtifactImage))
+			}
+		}
+		errs = errs.PutChild(itemErrs)
+	}
+	return errs
 }
 
 type Resources struct {
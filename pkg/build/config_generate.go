@@ -2,7 +2,6 @@ package build
 
 import (
 	"fmt"
-	"github.com/itura/fun/pkg/fun"
 )
 
 func validateConfig(config PipelineConfigRaw) ValidationErrors {
@@ -23,6 +22,10 @@ func validateConfig(config PipelineConfigRaw) ValidationErrors {
 			continue
 		}
 
+		// the steps setting up secrets are the only ones with ids in deploy jobs
+		applicationConfigErrors = validateStepOutputs(applicationConfigErrors, spec.RuntimeValues(),
+			secretProviders.ResolveSetupSteps(spec.Secrets))
+		allValidationErrors = allValidationErrors.PutChild(applicationConfigErrors)
 	}
 	return allValidationErrors
 }
@@ -37,19 +40,86 @@ func ReadConfigForGeneration(configPath string, cmd string) (WorkflowWriter, err
 }
 
 func ParseConfigForGeneration(config PipelineConfigRaw, configPath string, cmd string) (WorkflowWriter, error) {
+	return ParseConfigForTarget(config, configPath, cmd, ciTargetGithub)
+}
+
+func ParseConfigForTarget(config PipelineConfigRaw, configPath string, cmd string, target CiTarget) (WorkflowWriter, error) {
 	validationErrors := validateConfig(config)
 	if validationErrors.IsPresent() {
 		return nil, validationErrors
 	}
 
-	buildType := "github"
 	dependencies := ParseDependencies(config)
+	if len(config.Environments) > 0 && target != ciTargetGithub {
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("environments are not supported for target '%s'", value)
+	}
+	if usesApplyEnvironments(config) && target != ciTargetGithub {
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("terraform applyEnvironment is not supported for target '%s'", value)
+	}
+	if usesStepOutputs(config) && target != ciTargetGithub {
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("stepOutput values are not supported for target '%s'", value)
+	}
+	if usesKeylessSigning(config) && target != ciTargetGithub {
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("keyless signing is not supported for target '%s'", value)
+	}
+	if usesGhaCache(config) && target != ciTargetGithub {
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("gha docker cache is not supported for target '%s'", value)
+	}
+
+	switch target {
+	case ciTargetGithub:
+		return NewGithubActionsFactory(configPath, cmd, config, dependencies).Create()
+	case ciTargetGitlab:
+		return NewGitLabCiFactory(configPath, cmd, config, dependencies).Create(), nil
+	case ciTargetCircleci:
+		return NewCircleCiFactory(configPath, cmd, config, dependencies).Create(), nil
+	default:
+		value, _ := CiTargetEnum.ToString(target)
+		return nil, fmt.Errorf("unsupported target '%s'", value)
+	}
+}
+
+func usesStepOutputs(config PipelineConfigRaw) bool {
+	for _, application := range config.Applications {
+		for _, value := range application.RuntimeValues() {
+			if value.StepOutput != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func usesKeylessSigning(config PipelineConfigRaw) bool {
+	for _, artifact := range config.Artifacts {
+		if artifact.Docker.Sign.Keyless {
+			return true
+		}
+	}
+	return false
+}
 
-	if buildType == "github" {
-		return NewGithubActionsFactory(configPath, cmd, config, dependencies).Create(), nil
+func usesGhaCache(config PipelineConfigRaw) bool {
+	for _, artifact := range config.Artifacts {
+		if artifact.Docker.Cache == dockerCacheGha {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil, nil
+func usesApplyEnvironments(config PipelineConfigRaw) bool {
+	for _, application := range config.Applications {
+		if application.Terraform.ApplyEnvironment != "" {
+			return true
+		}
+	}
+	return false
 }
 
 func NewGithubActionsFactory(configPath string, cmd string, config PipelineConfigRaw, dependencies Dependencies) GithubActionsFactory {
@@ -66,6 +136,39 @@ type GithubActionsFactory struct {
 	configPath   string
 	cmd          string
 	dependencies Dependencies
+	// environment is set when generating jobs for one environment
+	environment string
+}
+
+func (g GithubActionsFactory) GetCloudProvider(config CloudProviderConfig) CloudProvider1[GitHubActionsStep] {
+	switch config.Type {
+	case cloudProviderTypeGcp:
+		return GCPCloudProvider{config.Config}
+	default:
+		return nil
+	}
+}
+
+func (g GithubActionsFactory) GetSecretProvider(config SecretProviderConfig) SecretProvider1[GitHubActionsStep] {
+	return config.Impl()
+}
+
+func (g GithubActionsFactory) GetArtifactRepositoryProvider(config ArtifactRepository) ArtifactRepositoryProvider[GitHubActionsStep] {
+	switch config.Type {
+	case artifactRepositoryTypeGcpDocker:
+		return GcpDockerRepository{config.Host}
+	default:
+		return nil
+	}
+}
+
+func (g GithubActionsFactory) GetKubernetesProvider(config ClusterConfig) KubernetesProvider[GitHubActionsStep] {
+	switch config.Type {
+	case "gke":
+		return GkeCluster{config}
+	default:
+		return nil
+	}
 }
 
 func (g GithubActionsFactory) getCommonSetupSteps() []GitHubActionsStep {
@@ -77,53 +180,84 @@ func (g GithubActionsFactory) getCommonSetupSteps() []GitHubActionsStep {
 	)
 	commonSetupSteps = append(
 		commonSetupSteps,
-		g.GetCloudProviderSteps()...,
+		ResolveCloudProviderSteps[GitHubActionsStep](g, g.config.Resources.CloudProvider)...,
 	)
 	return commonSetupSteps
 }
 
-func (g GithubActionsFactory) Create() GitHubActionsWorkflow {
-	workflow := NewGitHubActionsWorkflow(g.config.Name)
+func (g GithubActionsFactory) Create() (GitHubActionsWorkflow, error) {
+	workflow := NewGitHubActionsWorkflow(g.config.Name, g.config.Triggers)
 	for _, artifact := range g.config.Artifacts {
 		jobId := g.dependencies.GetJobId(artifact.Id)
 		workflow = workflow.SetJob(jobId, g.GetArtifactJob(artifact))
 	}
 
-	for _, application := range g.config.Applications {
-		jobId := g.dependencies.GetJobId(application.Id)
-		workflow = workflow.SetJob(jobId, g.GetApplicationJob(application))
+	if len(g.config.Environments) == 0 {
+		for _, application := range g.config.Applications {
+			jobId := g.dependencies.GetJobId(application.Id)
+			workflow = g.setApplicationJob(workflow, jobId, application, g.GetApplicationJob(application))
+		}
+		return workflow.RunAfterSkippedJobs(), nil
+	}
+
+	var environments []Environment
+	for _, environment := range g.config.Environments {
+		environments = append(environments, Environment{
+			Name:            environment.Name,
+			RequireApproval: environment.RequireApproval,
+		})
+	}
+	for _, job := range PlanEnvironmentJobs(environments, g.dependencies) {
+		if job.IsApproval() {
+			workflow = workflow.SetJob(job.JobId, NewApprovalJob(job.Environment, job.Needs...))
+			continue
+		}
+		environmentFactory, err := g.ForEnvironment(job.Environment)
+		if err != nil {
+			return GitHubActionsWorkflow{}, err
+		}
+		for _, application := range environmentFactory.config.Applications {
+			if application.Id != job.ApplicationId {
+				continue
+			}
+			githubJob := environmentFactory.GetApplicationJob(application)
+			githubJob.Name = fmt.Sprintf("Deploy %s to %s", application.Id, job.Environment)
+			githubJob.Environment = job.Environment
+			githubJob.Needs = job.Needs
+			workflow = environmentFactory.setApplicationJob(workflow, job.JobId, application, githubJob)
+		}
+	}
+	return workflow.RunAfterSkippedJobs(), nil
+}
+
+func (g GithubActionsFactory) setApplicationJob(workflow GitHubActionsWorkflow, jobId string, application ApplicationConfig, job GitHubActionsJob) GitHubActionsWorkflow {
+	options := application.Terraform.ForEnvironment(g.environment)
+	for splitJobId, splitJob := range TerraformJobs(jobId, job, application.Path, options, workflow.On) {
+		workflow = workflow.SetJob(splitJobId, splitJob)
 	}
 	return workflow
 }
 
-func (g GithubActionsFactory) GetCloudProviderSteps() []GitHubActionsStep {
-	providerConfig := g.config.Resources.CloudProvider
-	switch providerConfig.Type {
-	case cloudProviderTypeGcp:
-		return []GitHubActionsStep{GcpAuthStep(
-			formatSecretValue(providerConfig.Config["workloadIdentityProvider"]),
-			formatSecretValue(providerConfig.Config["serviceAccount"]),
-		)}
-	default:
-		panic("😎")
+// ForEnvironment returns a factory for the jobs deploying applications to an environment.
+func (g GithubActionsFactory) ForEnvironment(name string) (GithubActionsFactory, error) {
+	config, err := g.config.ForEnvironment(name)
+	if err != nil {
+		return GithubActionsFactory{}, err
 	}
+	g.config = config
+	g.environment = name
+	return g, nil
 }
 
 func (g GithubActionsFactory) GetArtifactJob(artifact ArtifactConfig) GitHubActionsJob {
 	steps := g.getCommonSetupSteps()
-
-	repoConfig := g.config.Resources.ArtifactRepository
-	switch repoConfig.Type {
-	case artifactRepositoryTypeGcpDocker:
-		steps = append(steps,
-			ConfigureGcloudCliStep(),
-			ConfigureGcloudDockerStep(repoConfig.Host),
-			BuildArtifactStep(artifact.Id, g.configPath, g.cmd),
-		)
-	default:
-		t, _ := ArtifactRepositoryTypeEnum.ToString(repoConfig.Type)
-		panic(t + "is not a valid type")
+	if artifact.Type.UsesRegistry(artifact.Docker.Cache) {
+		steps = append(steps, ResolveArtifactRepositorySteps[GitHubActionsStep](g, g.config.Resources.ArtifactRepository)...)
 	}
+	steps = append(steps, GetArtifactToolSteps(artifact.Type, artifact.Platforms, artifact.Docker)...)
+	buildStep := BuildArtifactStep(artifact.Id, g.configPath, g.cmd, NewGitHubActionsTriggers(g.config.Triggers).BuildArgs()...)
+	buildStep.Env = GetArtifactBuildEnv(artifact.Type, artifact.Docker.ResolveGitHub().BuildArgs)
+	steps = append(steps, buildStep)
 
 	return NewGitHubActionsJob("Build " + artifact.Id).
 		AddNeeds(g.dependencies.GetUpstreamJobIds(artifact.Id)...).
@@ -134,83 +268,41 @@ func (g GithubActionsFactory) GetApplicationJob(application ApplicationConfig) G
 	steps := g.getCommonSetupSteps()
 
 	var runTimeArgs []RuntimeArg
-	for _, arg := range application.Values {
-		runTimeArgs = append(runTimeArgs, RuntimeArg{
-			Key:   arg.Key,
-			Value: arg.Value,
-		})
+	for _, arg := range application.RuntimeValues() {
+		runTimeArgs = append(runTimeArgs, arg.ResolveGitHub(g.environment))
 	}
 
-	if len(application.Secrets) != 0 {
-
-		for _, secretProviderConfig := range g.config.Resources.SecretProviders {
-			secretNames := secretProviderConfig.SecretNames
-			id := secretProviderConfig.Id
-			project := secretProviderConfig.Config["project"]
-
-			if len(secretNames) == 0 {
-				continue
-			}
-
-			switch secretProviderConfig.Type {
-
-			case secretProviderTypeGcp:
-				var names []string
-				for _, secretConfig := range application.Secrets {
-					if fun.Contains(secretNames, secretConfig.SecretName) {
-						names = append(names, secretConfig.SecretName)
-						runTimeArgs = append(runTimeArgs, RuntimeArg{
-							Key:   secretConfig.Key,
-							Value: g.resolveStepOutput(fmt.Sprintf("secrets-%s", id), secretConfig.SecretName),
-						})
-					}
-				}
-
-				steps = append(steps, FetchGcpSecretsStep(id, project, names...))
-			case secretProviderTypeGithub:
-				for _, secretConfig := range application.Secrets {
-					if fun.Contains(secretNames, secretConfig.SecretName) {
-						runTimeArgs = append(runTimeArgs, RuntimeArg{
-							Key:   secretConfig.Key,
-							Value: g.resolveSecret(secretConfig.SecretName),
-						})
-					}
-				}
-				continue
-
-			default:
-				panic("👺")
-			}
-		}
-	}
+	secretSteps, secretArgs := ResolveSecrets[GitHubActionsStep](g, g.config.Resources.SecretProviders, application.Secrets)
+	steps = append(steps, secretSteps...)
+	runTimeArgs = append(runTimeArgs, secretArgs...)
 
 	switch application.Type {
 	case applicationTypeHelm:
-		switch g.config.Resources.KubernetesCluster.Type {
-		case "gke":
-			steps = append(steps, GetSetupGkeStep(g.config.Resources.KubernetesCluster))
-		default:
-			panic("🍕")
-		}
-
-		steps = append(steps, GetSetupHelmStep())
+		steps = append(steps, ResolveKubernetesSteps[GitHubActionsStep](g, g.config.Resources.KubernetesCluster)...)
+		steps = append(steps, GetSetupHelmStep(application.Helm.ClientVersion()))
 	case applicationTypeTerraform:
-		steps = append(steps, GetSetupTerraformStep())
+		steps = append(steps, GetSetupTerraformStep(application.Terraform.ClientVersion()))
+	case applicationTypeKustomize, applicationTypeKubectl:
+		steps = append(steps, ResolveKubernetesSteps[GitHubActionsStep](g, g.config.Resources.KubernetesCluster)...)
+		steps = append(steps, GetSetupKustomizeStep(application.Manifests.ClientVersion()))
 	default:
 		panic("😅")
 	}
+	if application.readsRegistry() {
+		steps = append(steps, ResolveArtifactRepositorySteps[GitHubActionsStep](g, g.config.Resources.ArtifactRepository)...)
+		steps = append(steps, GetSetupOrasStep())
+	}
+	if application.RequireSignedImages {
+		steps = append(steps, GetSetupCosignStep())
+	}
 
-	steps = append(steps, GetDeployStep(application.Id, runTimeArgs, GetDeployRunCommand(application.Id, g.cmd, g.configPath)))
+	deployArgs := NewGitHubActionsTriggers(g.config.Triggers).DeployArgs()
+	if g.environment != "" {
+		deployArgs = append(deployArgs, "--environment "+g.environment)
+	}
+	steps = append(steps, GetDeployStep(application.Id, runTimeArgs, GetDeployRunCommand(application.Id, g.cmd, g.configPath, deployArgs...)))
 
 	return NewGitHubActionsJob("Deploy " + application.Id).
 		AddNeeds(g.dependencies.GetUpstreamJobIds(application.Id)...).
 		AddSteps(steps...)
 }
-
-func (g GithubActionsFactory) resolveStepOutput(stepId string, outputId string) string {
-	return fmt.Sprintf("${{ steps.%s.outputs.%s }}", stepId, outputId)
-}
-
-func (g GithubActionsFactory) resolveSecret(secretName string) string {
-	return fmt.Sprintf("${{ secrets.%s }}", secretName)
-}
@@ -56,6 +56,28 @@ func TestParseConfig(t *testing.T) {
 				),
 			),
 		},
+		{
+			name: "InvalidDependencies",
+			args: TestArgs("test_fixtures/invalid_dependencies.yaml"),
+			expected: FailedParse("My Build", NewValidationErrors("").
+				PutChild(NewValidationErrors("artifacts").
+					PutChild(NewValidationErrors("api").
+						Put("id", DuplicateId("api")),
+					),
+				).
+				PutChild(NewValidationErrors("applications").
+					PutChild(NewValidationErrors("infra").
+						Put("dependencies", fmt.Errorf("cycle detected: infra -> website -> db -> infra")),
+					).
+					PutChild(NewValidationErrors("db").
+						Put("id", DuplicateId("db")).
+						Put("artifacts", fmt.Errorf("'infra' is an application, list it under dependencies")).
+						Put("artifacts", fmt.Errorf("unknown artifact 'cache'")).
+						Put("dependencies", fmt.Errorf("unknown artifact or application 'queue'")),
+					),
+				),
+			),
+		},
 		{
 			name:     "InvalidSecretProviderType",
 			args:     TestArgs("test_fixtures/invalid_secret_provider_type.yaml"),
@@ -130,7 +152,7 @@ func TestGithubActionsGeneration1(t *testing.T) {
 					},
 				}},
 			},
-			expected: NewGitHubActionsWorkflow("my build").
+			expected: NewGitHubActionsWorkflow("my build", TriggersConfig{}).
 				SetJob("build-api", NewGitHubActionsJob("Build api").
 					AddSteps(
 						CheckoutRepoStep(),
@@ -138,6 +160,7 @@ func TestGithubActionsGeneration1(t *testing.T) {
 						GcpAuthStep("${{ secrets.WORKLOAD_IDENTITY_PROVIDER }}", "${{ secrets.SERVICE_ACCOUNT }}"),
 						ConfigureGcloudCliStep(),
 						ConfigureGcloudDockerStep("us"),
+						GetSetupOrasStep(),
 						BuildArtifactStep("api", configPath, cmd),
 					),
 				).
@@ -146,7 +169,7 @@ func TestGithubActionsGeneration1(t *testing.T) {
 						CheckoutRepoStep(),
 						SetupGoStep(),
 						GcpAuthStep("${{ secrets.WORKLOAD_IDENTITY_PROVIDER }}", "${{ secrets.SERVICE_ACCOUNT }}"),
-						GetSetupTerraformStep(),
+						GetSetupTerraformStep(defaultTerraformVersion),
 						GetDeployStep("infra", nil, GetDeployRunCommand("infra", cmd, configPath)),
 					),
 				).
@@ -162,13 +185,14 @@ func TestGithubActionsGeneration1(t *testing.T) {
 							Location: "new zealand",
 							Type:     "gke",
 						}),
-						GetSetupHelmStep(),
+						GetSetupHelmStep(defaultHelmVersion),
 						GetDeployStep(
 							"website",
 							[]RuntimeArg{
 								{Key: "domain", Value: "http://yeehaw.com"},
-								{Key: "postgres.password", Value: "${{ secrets.pg-password }}"},
-								{Key: "postgres.adminPassword", Value: "${{ steps.secrets-gcp-cool-proj.outputs.pg-admin-password }}"},
+								{Key: "postgres.password", SecretValue: "pg-password", Value: "${{ secrets.pg-password }}"},
+								{Key: "postgres.adminPassword", SecretValue: "pg-admin-password",
+									Value: "${{ steps.secrets-gcp-cool-proj.outputs.pg-admin-password }}"},
 							},
 							GetDeployRunCommand("website", cmd, configPath),
 						),
@@ -272,6 +296,90 @@ func TestResourcesValidation(t *testing.T) {
 	fmt.Println(errs.Error())
 }
 
+func TestTriggersValidation(t *testing.T) {
+	triggers := TriggersConfig{
+		Schedule: ScheduleTriggerConfigs{{Cron: "0 6 * * 1"}, {}},
+		WorkflowDispatch: &WorkflowDispatchConfig{
+			Inputs: map[string]WorkflowDispatchInput{
+				"reason": {Type: "string"},
+				"env":    {Type: "choice"},
+				"count":  {Type: "int"},
+			},
+		},
+	}
+
+	errs := triggers.Validate("triggers")
+	assert.Equal(t,
+		NewValidationErrors("triggers").
+			PutChild(NewValidationErrors("schedule").
+				PutChild(NewValidationErrors("1").
+					Put("cron", eMissingRequiredField))).
+			PutChild(NewValidationErrors("workflowDispatch").
+				PutChild(NewValidationErrors("inputs").
+					Put("count", InvalidInputType("int")).
+					Put("env", fmt.Errorf("choice input requires options")))),
+		errs,
+	)
+}
+
+func TestHelmConfigValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		helm     HelmConfig
+		expected ValidationErrors
+	}{
+		{
+			name:     "Valid",
+			helm:     HelmConfig{Chart: "bitnami/redis", Repository: "https://charts.bitnami.com/bitnami", Version: "18.1.0", Timeout: "5m30s"},
+			expected: NewValidationErrors("helm"),
+		},
+		{
+			name: "Invalid",
+			helm: HelmConfig{Timeout: "soon", HistoryMax: -1, Version: "1.0.0", Repository: "https://charts.example.com"},
+			expected: NewValidationErrors("helm").
+				Put("timeout", fmt.Errorf("'soon' is not a duration")).
+				Put("historyMax", fmt.Errorf("must not be negative")).
+				Put("version", fmt.Errorf("only applies to a remote chart")).
+				Put("repository", fmt.Errorf("only applies to a remote chart")),
+		},
+		{
+			name:     "CanaryStrategy",
+			helm:     HelmConfig{Strategy: HelmStrategy{Type: helmStrategyCanary, Check: HealthCheck{Http: "https://api.example.com/healthz", Retries: 10}}},
+			expected: NewValidationErrors("helm"),
+		},
+		{
+			name: "InvalidStrategy",
+			helm: HelmConfig{Strategy: HelmStrategy{Type: helmStrategyCanary, Weight: 120, Service: "api", Check: HealthCheck{Retries: 3}}},
+			expected: NewValidationErrors("helm").PutChild(
+				NewValidationErrors("strategy").
+					Put("service", fmt.Errorf("only applies to the blue-green strategy")).
+					Put("weight", fmt.Errorf("must be between 0 and 100, 0 uses the default of %d", defaultCanaryWeight)).
+					Put("check", fmt.Errorf("a canary can't be promoted without a check")).
+					PutChild(NewValidationErrors("check").Put("retries", fmt.Errorf("only applies to http checks"))),
+			),
+		},
+		{
+			name: "BlueGreenStrategy",
+			helm: HelmConfig{Strategy: HelmStrategy{Type: helmStrategyBlueGreen}},
+			expected: NewValidationErrors("helm").PutChild(
+				NewValidationErrors("strategy").Put("service", eMissingRequiredField),
+			),
+		},
+		{
+			name: "OciRepository",
+			helm: HelmConfig{Chart: "oci://registry-1.docker.io/bitnamicharts/redis", Repository: "https://charts.bitnami.com/bitnami"},
+			expected: NewValidationErrors("helm").
+				Put("repository", fmt.Errorf("not used with an OCI chart")),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.helm.Validate("helm"))
+		})
+	}
+}
+
 func TestValidateTags(t *testing.T) {
 
 }
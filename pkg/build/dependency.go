@@ -2,12 +2,16 @@ package build
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/itura/fun/pkg/fun"
 )
 
 type Dependency struct {
 	id         string
 	path       string
+	watch      []string
 	isArtifact bool
 	upstreams  []string
 }
@@ -34,6 +38,16 @@ func (d Dependency) DependsOn(upstream ...string) Dependency {
 	return d
 }
 
+// Watch adds PathFilter patterns considered by change detection, in addition to the path.
+func (d Dependency) Watch(patterns ...string) Dependency {
+	d.watch = append(d.watch, patterns...)
+	return d
+}
+
+func (d Dependency) patterns() []string {
+	return append([]string{d.path}, d.watch...)
+}
+
 type Dependencies struct {
 	deps fun.Config[Dependency]
 }
@@ -52,22 +66,171 @@ func (d Dependencies) Set(id string, dep Dependency) Dependencies {
 func ParseDependencies(config PipelineConfigRaw) Dependencies {
 	d := Dependencies{deps: fun.NewConfig[Dependency]()}
 	for _, artifact := range config.Artifacts {
-		d.deps[artifact.Id] = NewArtifactDependency(artifact.Id, artifact.Path)
+		d.deps[artifact.Id] = NewArtifactDependency(artifact.Id, artifact.Path).
+			Watch(artifact.Watch...)
 	}
 	for _, application := range config.Applications {
-		dep := NewApplicationDependency(application.Id, application.Path)
+		dep := NewApplicationDependency(application.Id, application.Path).
+			Watch(application.Watch...)
 		for _, upstream := range application.Artifacts {
 			dep = dep.DependsOn(upstream)
 		}
 		for _, upstream := range application.Dependencies {
 			dep = dep.DependsOn(upstream)
 		}
+		for _, upstream := range valueArtifacts(config, application.Id) {
+			if !fun.Contains(dep.upstreams, upstream) {
+				dep = dep.DependsOn(upstream)
+			}
+		}
 		d.deps[application.Id] = dep
 	}
-	// TODO add validations
 	return d
 }
 
+// valueArtifacts returns the artifacts whose images are referenced by an application's values, in any environment.
+func valueArtifacts(config PipelineConfigRaw, id string) []string {
+	var values []RuntimeArg
+	for _, application := range config.Applications {
+		if application.Id == id {
+			values = append(values, application.RuntimeValues()...)
+		}
+	}
+	for _, environment := range config.Environments {
+		values = append(values, environment.Applications[id].RuntimeValues()...)
+	}
+
+	var results []string
+	for _, value := range values {
+		if value.ArtifactImage != "" && !fun.Contains(results, value.ArtifactImage) {
+			results = append(results, value.ArtifactImage)
+		}
+	}
+	return results
+}
+
+// ValidateDependencies reports ids that are duplicated or unknown, artifact references to applications,
+// and cycles between applications. Errors are keyed by id, and reported once for ids which are duplicated.
+func ValidateDependencies(errs ValidationErrors, config PipelineConfigRaw) ValidationErrors {
+	artifactIds := map[string]bool{}
+	applicationIds := map[string]bool{}
+	duplicateIds := map[string]bool{}
+
+	artifactErrs := NewValidationErrors("artifacts")
+	for _, artifact := range config.Artifacts {
+		if artifactIds[artifact.Id] && !duplicateIds[artifact.Id] {
+			artifactErrs = artifactErrs.PutChild(NewValidationErrors(artifact.Id).
+				Put("id", DuplicateId(artifact.Id)))
+			duplicateIds[artifact.Id] = true
+		}
+		artifactIds[artifact.Id] = true
+	}
+
+	var ids []string
+	for _, application := range config.Applications {
+		if artifactIds[application.Id] || applicationIds[application.Id] {
+			duplicateIds[application.Id] = true
+		}
+		if !applicationIds[application.Id] {
+			ids = append(ids, application.Id)
+		}
+		applicationIds[application.Id] = true
+	}
+
+	cycles := map[string][]string{}
+	for _, cycle := range findCycles(config) {
+		cycles[cycle[0]] = append(cycles[cycle[0]], strings.Join(cycle, " -> "))
+	}
+
+	errsById := map[string]ValidationErrors{}
+	for _, id := range ids {
+		errsById[id] = NewValidationErrors(id)
+		if duplicateIds[id] {
+			errsById[id] = errsById[id].Put("id", DuplicateId(id))
+		}
+	}
+	for _, application := range config.Applications {
+		upstreamErrs := errsById[application.Id]
+		for _, upstream := range application.Artifacts {
+			if applicationIds[upstream] {
+				upstreamErrs = upstreamErrs.Put("artifacts", fmt.Errorf("'%s' is an application, list it under dependencies", upstream))
+			} else if !artifactIds[upstream] {
+				upstreamErrs = upstreamErrs.Put("artifacts", fmt.Errorf("unknown artifact '%s'", upstream))
+			}
+		}
+		for _, upstream := range application.Dependencies {
+			if !artifactIds[upstream] && !applicationIds[upstream] {
+				upstreamErrs = upstreamErrs.Put("dependencies", fmt.Errorf("unknown artifact or application '%s'", upstream))
+			}
+		}
+		errsById[application.Id] = upstreamErrs
+	}
+
+	applicationErrs := NewValidationErrors("applications")
+	for _, id := range ids {
+		itemErrs := errsById[id]
+		for _, cycle := range cycles[id] {
+			itemErrs = itemErrs.Put("dependencies", fmt.Errorf("cycle detected: %s", cycle))
+		}
+		applicationErrs = applicationErrs.PutChild(itemErrs)
+	}
+
+	return errs.
+		PutChild(artifactErrs).
+		PutChild(applicationErrs)
+}
+
+// findCycles returns each cycle between applications once, as the path of ids starting and ending with the same id.
+func findCycles(config PipelineConfigRaw) [][]string {
+	upstreams := map[string][]string{}
+	var ids []string
+	for _, application := range config.Applications {
+		if _, ok := upstreams[application.Id]; !ok {
+			ids = append(ids, application.Id)
+		}
+		upstreams[application.Id] = append(upstreams[application.Id], application.Dependencies...)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var cycles [][]string
+	var path []string
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, upstream := range upstreams[id] {
+			switch state[upstream] {
+			case unvisited:
+				if _, ok := upstreams[upstream]; ok {
+					visit(upstream)
+				}
+			case visiting:
+				start := len(path) - 1
+				for path[start] != upstream {
+					start -= 1
+				}
+				cycle := append([]string{}, path[start:]...)
+				cycles = append(cycles, append(cycle, upstream))
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+
+	for _, id := range ids {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+	return cycles
+}
+
 func (d Dependencies) GetUpstreamJobIds(id string) []string {
 	dep, ok := d.deps[id]
 	if !ok {
@@ -80,6 +243,20 @@ func (d Dependencies) GetUpstreamJobIds(id string) []string {
 	return results
 }
 
+// GetDepth returns the length of the longest chain of upstreams above id.
+func (d Dependencies) GetDepth(id string) int {
+	depth := 0
+	for _, upstream := range d.GetUpstreamIds(id) {
+		if _, ok := d.deps[upstream]; !ok {
+			continue
+		}
+		if upstreamDepth := d.GetDepth(upstream) + 1; upstreamDepth > depth {
+			depth = upstreamDepth
+		}
+	}
+	return depth
+}
+
 func (d Dependencies) GetJobId(id string) string {
 	dep, ok := d.deps[id]
 	if !ok {
@@ -92,75 +269,138 @@ func (d Dependencies) GetJobId(id string) string {
 	}
 }
 
-func (d Dependencies) GetAllPaths(id string) []string {
-	results := d.getAllPaths(id)
-	return fun.RemoveDuplicate(results)
+// GetEnvironmentJobId returns the job id of id in an environment. Artifacts are built once for every environment.
+func (d Dependencies) GetEnvironmentJobId(id string, environment string) string {
+	jobId := d.GetJobId(id)
+	if environment == "" || jobId == "" || d.IsArtifact(id) {
+		return jobId
+	}
+	return fmt.Sprintf("%s-%s", jobId, environment)
 }
 
-func (d Dependencies) getAllPaths(id string) []string {
+func (d Dependencies) GetUpstreamEnvironmentJobIds(id string, environment string) []string {
 	dep, ok := d.deps[id]
 	if !ok {
 		return nil
 	}
-
-	results := []string{dep.path}
-	for _, upstreamId := range dep.upstreams {
-		if _, ok := d.deps[upstreamId]; ok {
-			results = append(results, d.getAllPaths(upstreamId)...)
-		}
+	var results []string
+	for _, upstream := range dep.upstreams {
+		results = append(results, d.GetEnvironmentJobId(upstream, environment))
 	}
 	return results
 }
 
-type ChangeDetection interface {
-	HasChanged(paths ...string) bool
+func (d Dependencies) Ids() []string {
+	var ids []string
+	for id := range d.deps {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
 }
 
-type StaticChangeDetection struct {
-	hasChanged bool
+func (d Dependencies) IsArtifact(id string) bool {
+	return d.deps[id].isArtifact
 }
 
-func NewAlwaysChanged() ChangeDetection {
-	return StaticChangeDetection{hasChanged: true}
+func (d Dependencies) GetUpstreamIds(id string) []string {
+	dep, ok := d.deps[id]
+	if !ok {
+		return nil
+	}
+	return dep.upstreams
 }
 
-func NewNeverChanged() ChangeDetection {
-	return StaticChangeDetection{hasChanged: false}
+func (d Dependencies) GetDownstreamIds(id string) []string {
+	var results []string
+	for _, other := range d.Ids() {
+		if fun.Contains(d.deps[other].upstreams, id) {
+			results = append(results, other)
+		}
+	}
+	return results
 }
 
-func (c StaticChangeDetection) HasChanged(...string) bool {
-	return c.hasChanged
-}
+// TopologicalOrder returns every id such that each one comes after all of its upstreams.
+// Ids with no ordering constraint between them are sorted alphabetically.
+func (d Dependencies) TopologicalOrder() ([]string, error) {
+	remaining := map[string]int{}
+	for _, id := range d.Ids() {
+		remaining[id] = len(fun.RemoveDuplicate(d.deps[id].upstreams))
+		for _, upstream := range d.deps[id].upstreams {
+			if _, ok := d.deps[upstream]; !ok {
+				return nil, fmt.Errorf("%s depends on unknown id %s", id, upstream)
+			}
+		}
+	}
 
-type GitChangeDetection struct {
-	previousSha string
-	paths       []string
-	runner      CommandRunner
+	var ready []string
+	for _, id := range d.Ids() {
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	var results []string
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		results = append(results, id)
+		for _, downstream := range d.GetDownstreamIds(id) {
+			remaining[downstream] -= 1
+			if remaining[downstream] == 0 {
+				ready = append(ready, downstream)
+				sort.Strings(ready)
+			}
+		}
+	}
+
+	if len(results) != len(d.deps) {
+		return nil, fmt.Errorf("dependency cycle detected")
+	}
+	return results, nil
 }
 
-func NewGitChangeDetection(runner CommandRunner) (GitChangeDetection, error) {
-	previousSha, err := runner.Output("git", "rev-list", "-n", "1", "HEAD~1")
-	return GitChangeDetection{
-		previousSha: previousSha,
-		runner:      runner,
-		paths: []string{
-			".github/",
-		},
-	}, err
+func (d Dependencies) GetAllPaths(id string) []string {
+	results := d.getAllPaths(id)
+	return fun.RemoveDuplicate(results)
 }
 
-func (g GitChangeDetection) HasChanged(paths ...string) bool {
-	hasChanged := false
-	for _, path := range append(g.paths, paths...) {
-		hasChanged = hasChanged || g.sourceHasChanged(path, g.previousSha)
+func (d Dependencies) getAllPaths(id string) []string {
+	dep, ok := d.deps[id]
+	if !ok {
+		return nil
 	}
-	return hasChanged
+
+	results := dep.patterns()
+	for _, upstreamId := range dep.upstreams {
+		if _, ok := d.deps[upstreamId]; ok {
+			results = append(results, d.getAllPaths(upstreamId)...)
+		}
+	}
+	return results
 }
 
-func (g GitChangeDetection) sourceHasChanged(path string, ref string) bool {
-	err := g.runner.RunSilent("git", "diff", "--quiet", "HEAD", ref, "--", path)
-	if err != nil {
-		return true
+// HasChanged checks each of id and its upstreams separately,
+// so that exclusions only apply to the patterns they were declared with.
+func (d Dependencies) HasChanged(cd ChangeDetection, id string) bool {
+	for _, upstreamId := range d.getAllIds(id) {
+		if cd.HasChanged(d.deps[upstreamId].patterns()...) {
+			return true
+		}
 	}
 	return false
 }
+
+func (d Dependencies) getAllIds(id string) []string {
+	dep, ok := d.deps[id]
+	if !ok {
+		return nil
+	}
+
+	results := []string{id}
+	for _, upstreamId := range dep.upstreams {
+		results = append(results, d.getAllIds(upstreamId)...)
+	}
+	return fun.RemoveDuplicate(results)
+}
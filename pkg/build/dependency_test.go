@@ -1,9 +1,15 @@
 package build
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
 	"github.com/itura/fun/pkg/fun"
 	"github.com/stretchr/testify/suite"
-	"testing"
 )
 
 func TestCd(t *testing.T) {
@@ -42,4 +48,159 @@ func (s *CdSuite) TestDependency() {
 	s.Equal([]string{"tf/main"}, deps.GetAllPaths("infra"))
 	s.Equal([]string{"helm/website", "packages/client", "packages/api", "tf/main", "helm/db"}, deps.GetAllPaths("website"))
 	s.Equal([]string{"packages/api"}, deps.GetAllPaths("api"))
+
+	order, err := deps.TopologicalOrder()
+	s.Nil(err)
+	s.Equal([]string{"api", "client", "infra", "db", "website"}, order)
+	s.Equal([]string{"db", "website"}, deps.GetDownstreamIds("infra"))
+}
+
+func (s *CdSuite) TestTopologicalOrderDetectsCycle() {
+	deps := NewDependencies().
+		Set("a", NewApplicationDependency("a", "a", "b")).
+		Set("b", NewApplicationDependency("b", "b", "a"))
+
+	_, err := deps.TopologicalOrder()
+	s.NotNil(err)
+}
+
+func (s *CdSuite) TestGitChangeDetectionDefaultsToPreviousCommit() {
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "git", "rev-list", "-n", "1", "HEAD~1").Return("previous", nil)
+	runner.On("RunSilent", "git", "cat-file", "-e", "previous^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "previous", "HEAD").Return("pkg/api/main.go\x00README.md\x00", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{})
+
+	s.Equal("changes since previous (previous commit)", cd.Describe())
+	s.True(cd.HasChanged("pkg/api"))
+	s.False(cd.HasChanged("pkg/client"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionUsesPushEventBefore() {
+	eventPath := filepath.Join(s.T().TempDir(), "event.json")
+	s.Nil(os.WriteFile(eventPath, []byte(`{"before": "before", "after": "after"}`), 0644))
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "before^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "before", "HEAD").Return("pkg/api/README.md", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{EventPath: eventPath})
+
+	s.True(cd.HasChanged("pkg/api"))
+	s.False(cd.HasChanged("pkg/api", "!**/*.md"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionAlwaysWatchesWorkflows() {
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return(".github/workflows/ci.yaml", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "base"})
+
+	s.True(cd.HasChanged("pkg/api"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionWatchesSharedPatterns() {
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").
+		Return(".github/workflows/ci.yaml\x00.github/CODEOWNERS\x00", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "base"})
+	config := PipelineConfigRaw{Watch: []string{".github/workflows/", "!**/*.yaml"}}
+
+	s.Equal([]string{".github/"}, PipelineConfigRaw{}.WatchPatterns())
+	s.False(cd.WithSharedWatch(config.WatchPatterns()...).HasChanged("pkg/api"))
+	s.True(cd.WithSharedWatch(".github/").HasChanged("pkg/api"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionKeepsPathsWithSpaces() {
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return("docs/release notes.md\x00", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "base"})
+
+	s.True(cd.HasChanged("docs/release notes.md"))
+	s.False(cd.HasChanged("docs/release"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionUsesMergeBaseOverEvent() {
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "git", "merge-base", "HEAD", "origin/trunk").Return("base", nil)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return("", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{Branch: "trunk", EventPath: "does-not-exist"})
+
+	s.Equal("changes since base (merge base with trunk)", cd.Describe())
+	s.False(cd.HasChanged("pkg/api"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionUsesMergeBaseOverNullSha() {
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "git", "merge-base", "HEAD", "origin/main").Return("base", nil)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return("pkg/api/main.go", nil)
+
+	// a GitLab merge request pipeline
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: strings.Repeat("0", 40), Branch: "main"})
+
+	s.Equal("changes since base (merge base with main)", cd.Describe())
+	s.True(cd.HasChanged("pkg/api"))
+	s.False(cd.HasChanged("pkg/client"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionFallsBackToEverythingChanged() {
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "force-pushed^{commit}").Return(fmt.Errorf("missing"))
+
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "force-pushed"})
+	s.True(cd.HasChanged("pkg/api"))
+	s.Equal("everything changed, could not resolve --base-sha", cd.Describe())
+
+	cd = NewGitChangeDetection(runner, BaseRef{Sha: strings.Repeat("0", 40)})
+	s.True(cd.HasChanged("pkg/api"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestGitChangeDetectionSinceGreen() {
+	greenSha := strings.Repeat("a", 40)
+	artifact := NewTestBuilder().Artifact("api", "pkg/api")
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return("pkg/api/main.go", nil)
+	runner.On("Output", "gcloud", "container", "images", "list-tags", artifact.AppImageBase(),
+		"--filter=tags:latest-green",
+		"--format=value(tags)",
+	).Return(greenSha+";latest-green", nil)
+	runner.On("RunSilent", "git", "cat-file", "-e", greenSha+"^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", greenSha, "HEAD").Return("", nil)
+
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "base", SinceGreen: true}).ForArtifact(artifact)
+
+	s.Equal(fmt.Sprintf("changes since %s (latest-green)", greenSha), cd.Describe())
+	s.False(cd.HasChanged("pkg/api"))
+	runner.AssertExpectations(s.T())
+}
+
+func (s *CdSuite) TestDependenciesHasChangedChecksUpstreamsSeparately() {
+	deps := NewDependencies().
+		Set("api", NewArtifactDependency("api", "pkg/api").Watch("go.mod")).
+		Set("website", NewApplicationDependency("website", "helm/website", "api").Watch("!**/*.md"))
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "git", "cat-file", "-e", "base^{commit}").Return(nil)
+	runner.On("Output", "git", "diff", "--name-only", "-z", "base", "HEAD").Return("go.mod\x00helm/website/README.md\x00", nil)
+	cd := NewGitChangeDetection(runner, BaseRef{Sha: "base"})
+
+	s.True(deps.HasChanged(cd, "website"))
+	s.Equal([]string{"helm/website", "!**/*.md", "pkg/api", "go.mod"}, deps.GetAllPaths("website"))
+	runner.AssertExpectations(s.T())
 }
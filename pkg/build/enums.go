@@ -11,19 +11,96 @@ const (
 	applicationTypeNil ApplicationType = iota
 	applicationTypeHelm
 	applicationTypeTerraform
+	applicationTypeKustomize
+	applicationTypeKubectl
 )
 
 var ApplicationTypeEnum = NewEnum[ApplicationType](map[ApplicationType]string{
 	applicationTypeHelm:      "helm",
 	applicationTypeTerraform: "terraform",
+	applicationTypeKustomize: "kustomize",
+	applicationTypeKubectl:   "kubectl",
 })
 
+// HasManifests is true for applications deployed by applying Kubernetes manifests with kubectl.
+func (s ApplicationType) HasManifests() bool {
+	return s == applicationTypeKustomize || s == applicationTypeKubectl
+}
+
 func (s *ApplicationType) UnmarshalYAML(
 	unmarshal func(interface{}) error,
 ) error {
 	return ApplicationTypeEnum.Unmarshal(unmarshal, s)
 }
 
+type ArtifactType uint
+
+const (
+	// artifactTypeApp is the default, so artifacts without a type are Docker images with verify and app targets
+	artifactTypeApp ArtifactType = iota
+	artifactTypeLib
+	artifactTypeGoBinary
+	artifactTypeHelmChart
+	artifactTypeNpm
+)
+
+var ArtifactTypeEnum = NewEnum[ArtifactType](map[ArtifactType]string{
+	artifactTypeApp:       "app",
+	artifactTypeLib:       "lib",
+	artifactTypeGoBinary:  "go-binary",
+	artifactTypeHelmChart: "helm-chart",
+	artifactTypeNpm:       "npm",
+})
+
+func (s *ArtifactType) UnmarshalYAML(
+	unmarshal func(interface{}) error,
+) error {
+	return ArtifactTypeEnum.Unmarshal(unmarshal, s)
+}
+
+// UsesRegistry is true for artifacts pushed to the artifact repository, or which cache layers there.
+func (s ArtifactType) UsesRegistry(cache DockerCacheType) bool {
+	return s == artifactTypeApp || s == artifactTypeGoBinary || s == artifactTypeHelmChart || cache == dockerCacheRegistry
+}
+
+type DockerCacheType uint
+
+const (
+	dockerCacheNone DockerCacheType = iota
+	dockerCacheRegistry
+	dockerCacheGha
+)
+
+var DockerCacheTypeEnum = NewEnum[DockerCacheType](map[DockerCacheType]string{
+	dockerCacheRegistry: "registry",
+	dockerCacheGha:      "gha",
+})
+
+func (s *DockerCacheType) UnmarshalYAML(
+	unmarshal func(interface{}) error,
+) error {
+	return DockerCacheTypeEnum.Unmarshal(unmarshal, s)
+}
+
+type HelmStrategyType uint
+
+const (
+	helmStrategyInPlace HelmStrategyType = iota
+	helmStrategyCanary
+	helmStrategyBlueGreen
+)
+
+var HelmStrategyTypeEnum = NewEnum[HelmStrategyType](map[HelmStrategyType]string{
+	helmStrategyCanary:    "canary",
+	helmStrategyBlueGreen: "blue-green",
+})
+
+func (s *HelmStrategyType) UnmarshalYAML(
+	unmarshal func(interface{}) error,
+) error {
+	return HelmStrategyTypeEnum.Unmarshal(unmarshal, s)
+}
+
 type CloudProviderType uint
 
 const (
@@ -83,6 +160,37 @@ func (s *ArtifactRepositoryType) UnmarshalYAML(
 	return ArtifactRepositoryTypeEnum.Unmarshal(unmarshal, s)
 }
 
+type CiTarget uint
+
+const (
+	ciTargetNil CiTarget = iota
+	ciTargetGithub
+	ciTargetGitlab
+	ciTargetCircleci
+)
+
+var (
+	CiTargetEnum = NewEnum[CiTarget](map[CiTarget]string{
+		ciTargetGithub:   "github",
+		ciTargetGitlab:   "gitlab",
+		ciTargetCircleci: "circleci",
+	})
+)
+
+func (s *CiTarget) UnmarshalText(text []byte) error {
+	target, present := CiTargetEnum.FromString(string(text))
+	if !present {
+		return CiTargetEnum.InvalidEnumValue(string(text))
+	}
+	*s = target
+	return nil
+}
+
+func (s CiTarget) MarshalText() ([]byte, error) {
+	value, _ := CiTargetEnum.ToString(s)
+	return []byte(value), nil
+}
+
 type Enum[T comparable] struct {
 	keyToValue map[T]string
 	valueToKey map[string]T
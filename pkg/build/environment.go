@@ -0,0 +1,111 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/itura/fun/pkg/fun"
+)
+
+// Environment is a deploy target for every application, with its overrides applied.
+type Environment struct {
+	Name            string
+	RequireApproval bool
+	Applications    fun.Config[Application]
+}
+
+func CreateEnvironments(
+	args ActionArgs,
+	cd ChangeDetection,
+	config PipelineConfigRaw,
+	artifactRepository string,
+	dependencies Dependencies,
+) ([]Environment, error) {
+	var environments []Environment
+	for _, environmentConfig := range config.Environments {
+		environmentRaw, err := config.ForEnvironment(environmentConfig.Name)
+		if err != nil {
+			return nil, err
+		}
+		args.Environment = environmentConfig.Name
+		applications, err := CreateApplications(args, cd, environmentRaw, artifactRepository, dependencies)
+		if err != nil {
+			return nil, err
+		}
+		environments = append(environments, Environment{
+			Name:            environmentConfig.Name,
+			RequireApproval: environmentConfig.RequireApproval,
+			Applications:    applications,
+		})
+	}
+	return environments, nil
+}
+
+func ApprovalJobId(environment string) string {
+	return fmt.Sprintf("approve-%s", environment)
+}
+
+// EnvironmentJob is a job in a pipeline with environments,
+// either deploying an application to an environment or waiting for approval of an environment.
+type EnvironmentJob struct {
+	JobId         string
+	ApplicationId string
+	Environment   string
+	Needs         []string
+}
+
+func (e EnvironmentJob) IsApproval() bool {
+	return e.ApplicationId == ""
+}
+
+// PlanEnvironmentJobs chains environments in order. Each application's job needs its upstreams in the same environment,
+// and either the approval of its environment or its own job in the previous environment.
+// Approval of an environment needs every job in the previous environment, or every artifact for the first one.
+func PlanEnvironmentJobs(environments []Environment, dependencies Dependencies) []EnvironmentJob {
+	var previousJobIds []string
+	var applicationIds []string
+	for _, id := range dependencies.Ids() {
+		if dependencies.IsArtifact(id) {
+			previousJobIds = append(previousJobIds, dependencies.GetJobId(id))
+		} else {
+			applicationIds = append(applicationIds, id)
+		}
+	}
+
+	var jobs []EnvironmentJob
+	previous := ""
+	for _, environment := range environments {
+		approval := ""
+		if environment.RequireApproval {
+			approval = ApprovalJobId(environment.Name)
+			jobs = append(jobs, EnvironmentJob{
+				JobId:       approval,
+				Environment: environment.Name,
+				Needs:       previousJobIds,
+			})
+		}
+
+		var jobIds []string
+		for _, id := range applicationIds {
+			needs := dependencies.GetUpstreamEnvironmentJobIds(id, environment.Name)
+			if approval != "" {
+				needs = append(needs, approval)
+			} else if previous != "" {
+				needs = append(needs, dependencies.GetEnvironmentJobId(id, previous))
+			}
+			jobId := dependencies.GetEnvironmentJobId(id, environment.Name)
+			jobs = append(jobs, EnvironmentJob{
+				JobId:         jobId,
+				ApplicationId: id,
+				Environment:   environment.Name,
+				Needs:         needs,
+			})
+			jobIds = append(jobIds, jobId)
+		}
+
+		sort.Strings(jobIds)
+		previousJobIds = jobIds
+		previous = environment.Name
+	}
+	return jobs
+}
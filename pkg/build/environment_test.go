@@ -0,0 +1,123 @@
+package build
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const environmentsConfigPath = "test_fixtures/environments_pipeline_config.yaml"
+
+func TestDeployApplicationToEnvironment(t *testing.T) {
+	args := TestArgs(environmentsConfigPath)
+	args.Environment = "prod"
+	pipeline, err := ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+
+	sideEffects, err := pipeline.DeployApplication("api-chart")
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewCommand("helm", "upgrade", "api-chart", "helm/api",
+		"--install",
+		"--atomic",
+		"--namespace", "api",
+		"--set", "repo=us-central1-docker.pkg.dev/gcp-project/repo-name",
+		"--set", "tag=currentSha",
+		"--set", "replicas=5",
+		"--set", "logLevel=debug",
+		"--set", "api.key=$api_key",
+	).SetExpandArgs(true), sideEffects.Commands[1])
+
+	application := pipeline.config.Applications["api-chart"]
+	assert.Equal(t, "prod", application.Environment)
+	assert.Equal(t, "prod-cluster", application.KubernetesCluster.Name)
+	assert.Equal(t, []RuntimeArg{
+		{Key: "replicas", Value: "5"},
+		{Key: "logLevel", Value: "debug"},
+		{Key: "api.key", Value: "${{ secrets.prod-api-key }}", SecretValue: "prod-api-key"},
+	}, application.RuntimeArgs)
+}
+
+func TestDeployApplicationToUnknownEnvironment(t *testing.T) {
+	args := TestArgs(environmentsConfigPath)
+	args.Environment = "qa"
+
+	_, err := ParsePipeline(args, NewAlwaysChanged())
+
+	assert.EqualError(t, err, "invalid environment qa")
+}
+
+func TestEnvironmentWorkflowGeneration(t *testing.T) {
+	pipeline, err := ParsePipeline(TestArgs(environmentsConfigPath), NewAlwaysChanged())
+	assert.Nil(t, err)
+
+	workflow := pipeline.ToGitHubWorkflow()
+
+	needs := map[string][]string{}
+	for jobId, job := range workflow.Jobs {
+		needs[jobId] = job.Needs
+	}
+	assert.Equal(t, map[string][]string{
+		"build-api":                nil,
+		"deploy-infra-dev":         nil,
+		"deploy-api-chart-dev":     {"build-api", "deploy-infra-dev"},
+		"deploy-infra-staging":     {"deploy-infra-dev"},
+		"deploy-api-chart-staging": {"build-api", "deploy-infra-staging", "deploy-api-chart-dev"},
+		"approve-prod":             {"deploy-api-chart-staging", "deploy-infra-staging"},
+		"deploy-infra-prod":        {"approve-prod"},
+		"deploy-api-chart-prod":    {"build-api", "deploy-infra-prod", "approve-prod"},
+	}, needs)
+
+	environments := map[string]string{}
+	for jobId, job := range workflow.Jobs {
+		environments[jobId] = job.Environment
+	}
+	assert.Equal(t, map[string]string{
+		"build-api":                "",
+		"deploy-infra-dev":         "dev",
+		"deploy-api-chart-dev":     "dev",
+		"deploy-infra-staging":     "staging",
+		"deploy-api-chart-staging": "staging",
+		"approve-prod":             "prod",
+		"deploy-infra-prod":        "prod",
+		"deploy-api-chart-prod":    "prod",
+	}, environments)
+	assert.Equal(t, "Deploy api-chart to staging", workflow.Jobs["deploy-api-chart-staging"].Name)
+	deploySteps := workflow.Jobs["deploy-api-chart-prod"].Steps
+	assert.Contains(t, deploySteps[len(deploySteps)-1].Run, "--environment prod")
+	assert.Equal(t, "${{ secrets.prod-api-key }}", deploySteps[len(deploySteps)-1].Env["api_key"])
+
+	config, err := readFile(environmentsConfigPath)
+	assert.Nil(t, err)
+	generated, err := ParseConfigForTarget(config, environmentsConfigPath, pipeline.Cmd, ciTargetGithub)
+	assert.Nil(t, err)
+	assert.Equal(t, workflow, generated)
+}
+
+func TestEnvironmentValidation(t *testing.T) {
+	config, err := readFile(environmentsConfigPath)
+	assert.Nil(t, err)
+	config.Environments = append(config.Environments,
+		EnvironmentConfig{
+			Name: "dev",
+		},
+		EnvironmentConfig{
+			Name:         "qa env",
+			Applications: map[string]EnvironmentApplicationConfig{"website": {}},
+		},
+	)
+
+	errs := ValidateEnvironments(NewValidationErrors(""), config)
+
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("environments").
+				PutChild(NewValidationErrors("3").
+					Put("name", fmt.Errorf("duplicate environment 'dev'"))).
+				PutChild(NewValidationErrors("4").
+					Put("name", fmt.Errorf("'qa env' may only contain letters, digits, '-' and '_'")).
+					Put("applications", fmt.Errorf("unknown application 'website'")))),
+		errs,
+	)
+}
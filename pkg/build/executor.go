@@ -0,0 +1,122 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/itura/fun/pkg/fun"
+	"github.com/itura/fun/pkg/fun/result"
+)
+
+// Executor runs the side effects of every artifact and application in a Pipeline,
+// in parallel where the dependency graph allows it.
+type Executor struct {
+	pipeline Pipeline
+	runner   CommandRunner
+	workers  int
+}
+
+func NewExecutor(pipeline Pipeline, runner CommandRunner, workers int) Executor {
+	if workers < 1 {
+		workers = 1
+	}
+	return Executor{
+		pipeline: pipeline,
+		runner:   runner,
+		workers:  workers,
+	}
+}
+
+type ExecutionReport struct {
+	Succeeded []string
+	Failed    map[string]error
+	Skipped   []string
+}
+
+func (r ExecutionReport) Error() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	var failed []string
+	for id, err := range r.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %s", id, err.Error()))
+	}
+	sort.Strings(failed)
+	return fmt.Errorf(
+		"%d failed, %d skipped\n%s",
+		len(r.Failed),
+		len(r.Skipped),
+		strings.Join(failed, "\n"),
+	)
+}
+
+func (e Executor) Run() (ExecutionReport, error) {
+	report := ExecutionReport{Failed: map[string]error{}}
+	dependencies := e.pipeline.config.Dependencies
+	order, err := dependencies.TopologicalOrder()
+	if err != nil {
+		return report, err
+	}
+
+	remaining := map[string]int{}
+	var ready []string
+	for _, id := range order {
+		remaining[id] = len(fun.RemoveDuplicate(dependencies.GetUpstreamIds(id)))
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	workers := fun.NewWorkers[string](e.workers)
+	defer workers.Stop()
+
+	inFlight := 0
+	for len(ready) > 0 || inFlight > 0 {
+		for len(ready) > 0 && inFlight < e.workers {
+			id := ready[0]
+			ready = ready[1:]
+			fmt.Printf("--- starting %s\n", dependencies.GetJobId(id))
+			workers.Submit(e.task(id))
+			inFlight += 1
+		}
+
+		done := <-workers.Listen()
+		inFlight -= 1
+		id := done.Value
+		if !done.Ok() {
+			fmt.Printf("--- %s failed: %s\n", dependencies.GetJobId(id), done.Err.Error())
+			report.Failed[id] = done.Err
+			continue
+		}
+
+		fmt.Printf("--- %s succeeded\n", dependencies.GetJobId(id))
+		report.Succeeded = append(report.Succeeded, id)
+		for _, downstream := range dependencies.GetDownstreamIds(id) {
+			remaining[downstream] -= 1
+			if remaining[downstream] == 0 {
+				ready = append(ready, downstream)
+			}
+		}
+	}
+
+	// anything that never became ready has a failed upstream
+	for _, id := range order {
+		_, failed := report.Failed[id]
+		if !failed && !fun.Contains(report.Succeeded, id) {
+			report.Skipped = append(report.Skipped, id)
+		}
+	}
+
+	return report, report.Error()
+}
+
+func (e Executor) task(id string) fun.Task[string] {
+	return func() result.Result[string] {
+		sideEffects, err := e.pipeline.SideEffects(id)
+		if err == nil {
+			err = sideEffects.Apply(e.runner)
+		}
+		return result.Result[string]{Value: id, Err: err}
+	}
+}
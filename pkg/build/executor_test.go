@@ -0,0 +1,92 @@
+package build
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+// execRunner runs commands with options through the mock, so that commands with env or expanded arguments
+// are expected like any other.
+type execRunner struct {
+	*mocks.CommandRunner
+}
+
+func (r execRunner) RunWith(options ExecOptions, name string, args ...string) error {
+	return r.Run(name, args...)
+}
+
+func (r execRunner) OutputWith(options ExecOptions, name string, args ...string) (string, error) {
+	return r.Output(name, args...)
+}
+
+// expectSideEffects expects each command the id runs to be run once.
+func expectSideEffects(t *testing.T, runner *mocks.CommandRunner, pipeline Pipeline, id string) {
+	sideEffects, err := pipeline.SideEffects(id)
+	assert.Nil(t, err)
+	for _, command := range sideEffects.Commands {
+		runner.On("Run", commandArgs(command)...).Return(nil).Once()
+	}
+}
+
+func commandArgs(command Command) []interface{} {
+	args := []interface{}{command.Name}
+	for _, arg := range command.Arguments {
+		args = append(args, arg)
+	}
+	return args
+}
+
+func TestExecutorRunsInDependencyOrder(t *testing.T) {
+	builder := NewTestBuilder()
+	pipeline := NewPipeline(ValidPipelineConfig(builder), "pipeline.yaml", "cmd")
+	runner := new(mocks.CommandRunner)
+	for _, id := range []string{"api", "client", "db", "website"} {
+		expectSideEffects(t, runner, pipeline, id)
+	}
+	runner.On("Run", "terraform", "-chdir=tf/main", "init").Return(nil).Once()
+	runner.On("Run", "terraform", "-chdir=tf/main", "plan", "-out=plan.out").Return(nil).Once()
+	runner.On("Output", "terraform", "-chdir=tf/main", "show", "-json", "plan.out").Return(`{"resource_changes": []}`, nil).Twice()
+	runner.On("Run", commandArgs(WritePlanSummary().expand(map[string]string{
+		terraformPlanSummaryVar: PlanSummary{}.Markdown("infra"),
+	}))...).Return(nil).Once()
+	runner.On("Run", "terraform", "-chdir=tf/main", "apply", "plan.out").Return(nil).Once()
+
+	report, err := NewExecutor(pipeline, execRunner{runner}, 1).Run()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"api", "client", "infra", "db", "website"}, report.Succeeded)
+	assert.Empty(t, report.Failed)
+	assert.Empty(t, report.Skipped)
+	runner.AssertExpectations(t)
+}
+
+func TestExecutorSkipsDownstreamOfFailure(t *testing.T) {
+	builder := NewTestBuilder()
+	pipeline := NewPipeline(ValidPipelineConfig(builder), "pipeline.yaml", "cmd")
+	runner := new(mocks.CommandRunner)
+	for _, id := range []string{"api", "client"} {
+		expectSideEffects(t, runner, pipeline, id)
+	}
+	runner.On("Run", "terraform", "-chdir=tf/main", "init").Return(nil).Once()
+	runner.On("Run", "terraform", "-chdir=tf/main", "plan", "-out=plan.out").Return(fmt.Errorf("terraform failed")).Once()
+
+	report, err := NewExecutor(pipeline, execRunner{runner}, 3).Run()
+
+	assert.NotNil(t, err)
+	assert.ElementsMatch(t, []string{"api", "client"}, report.Succeeded)
+	assert.Equal(t, []string{"infra"}, keys(report.Failed))
+	assert.Equal(t, []string{"db", "website"}, report.Skipped)
+	runner.AssertNotCalled(t, "Run", "helm", "dep", "update", "helm/db")
+	runner.AssertExpectations(t)
+}
+
+func keys[T any](m map[string]T) []string {
+	var results []string
+	for k := range m {
+		results = append(results, k)
+	}
+	return results
+}
@@ -14,18 +14,14 @@ type WorkflowWriter interface {
 
 type GitHubActionsWorkflow struct {
 	Name string
-	On   map[string]GitHubActionsTriggerEvent // v cool https://stackoverflow.com/questions/70849190/golang-how-to-avoid-double-quoted-on-key-on-when-marshaling-struct-to-yaml
+	On   GitHubActionsTriggers // v cool https://stackoverflow.com/questions/70849190/golang-how-to-avoid-double-quoted-on-key-on-when-marshaling-struct-to-yaml
 	Jobs map[string]GitHubActionsJob
 }
 
-func NewGitHubActionsWorkflow(name string) GitHubActionsWorkflow {
+func NewGitHubActionsWorkflow(name string, triggers TriggersConfig) GitHubActionsWorkflow {
 	return GitHubActionsWorkflow{
 		Name: name,
-		On: map[string]GitHubActionsTriggerEvent{
-			"push": {
-				Branches: []string{"trunk"},
-			},
-		},
+		On:   NewGitHubActionsTriggers(triggers),
 		Jobs: map[string]GitHubActionsJob{},
 	}
 }
@@ -35,6 +31,24 @@ func (g GitHubActionsWorkflow) SetJob(id string, job GitHubActionsJob) GitHubAct
 	return g
 }
 
+// RunAfterSkippedJobs lets jobs needing a job with its own condition, like a Terraform apply job skipped for check-only
+// events, run when that job is skipped, as long as nothing they need failed or was cancelled.
+func (g GitHubActionsWorkflow) RunAfterSkippedJobs() GitHubActionsWorkflow {
+	for id, job := range g.Jobs {
+		if job.If != "" {
+			continue
+		}
+		for _, need := range job.Needs {
+			if g.Jobs[need].If != "" {
+				job.If = "${{ !failure() && !cancelled() }}"
+				g.Jobs[id] = job
+				break
+			}
+		}
+	}
+	return g
+}
+
 func (g GitHubActionsWorkflow) WriteYaml(path string) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -50,6 +64,8 @@ func (g GitHubActionsWorkflow) WriteYaml(path string) error {
 type GitHubActionsJob struct {
 	Name        string
 	RunsOn      string `yaml:"runs-on"`
+	Environment string `yaml:"environment,omitempty"`
+	If          string `yaml:"if,omitempty"`
 	Permissions map[string]string
 	Needs       []string `yaml:"needs,omitempty"`
 	Steps       []GitHubActionsStep
@@ -66,6 +82,79 @@ func NewGitHubActionsJob(name string) GitHubActionsJob {
 	}
 }
 
+// NewApprovalJob waits for the protection rules of a GitHub environment, such as required reviewers.
+func NewApprovalJob(environment string, needs ...string) GitHubActionsJob {
+	job := NewGitHubActionsJob("Approve " + environment)
+	job.Environment = environment
+	job.Permissions = map[string]string{
+		"contents": "read",
+	}
+	return job.
+		AddNeeds(needs...).
+		AddSteps(GitHubActionsStep{
+			Name: "Approved",
+			Run:  fmt.Sprintf("echo 'Deploys to %s approved'", environment),
+		})
+}
+
+// TerraformJobs splits a Terraform deploy job with an apply environment into a job saving the plan as a workflow artifact,
+// and a job applying it once the environment is approved. The apply job keeps the job id, so downstream jobs wait for it.
+// Pull requests and drift checks only plan, so the apply job is skipped for them, and RunAfterSkippedJobs keeps
+// downstream jobs running.
+func TerraformJobs(jobId string, job GitHubActionsJob, path string, options TerraformOptions, triggers GitHubActionsTriggers) map[string]GitHubActionsJob {
+	if options.ApplyEnvironment == "" {
+		return map[string]GitHubActionsJob{jobId: job}
+	}
+
+	planJobId := "plan-" + strings.TrimPrefix(jobId, "deploy-")
+	last := len(job.Steps) - 1
+	deployStep := job.Steps[last]
+	setupSteps := job.Steps[:last]
+
+	planJob := job
+	planJob.Name = "Plan " + strings.TrimPrefix(job.Name, "Deploy ")
+	planStep := deployStep
+	planStep.Run += " \\\n  --phase " + terraformPhasePlan
+	planJob.Steps = append(append([]GitHubActionsStep{}, setupSteps...),
+		planStep,
+		GitHubActionsStep{
+			Name: "Upload plan",
+			Uses: "actions/upload-artifact@v3",
+			With: map[string]interface{}{
+				"name": planJobId,
+				"path": fmt.Sprintf("%s/%s", path, terraformPlanFile),
+			},
+		},
+	)
+
+	applyJob := job
+	applyJob.Environment = options.ApplyEnvironment
+	applyJob.Needs = []string{planJobId}
+	var conditions []string
+	for _, event := range triggers.CheckOnlyEvents() {
+		conditions = append(conditions, fmt.Sprintf("github.event_name != '%s'", event))
+	}
+	applyJob.If = strings.Join(conditions, " && ")
+	applyStep := deployStep
+	applyStep.Run += " \\\n  --phase " + terraformPhaseApply
+	applyJob.Steps = append(append([]GitHubActionsStep{}, setupSteps...),
+		GitHubActionsStep{
+			Name: "Download plan",
+			Uses: "actions/download-artifact@v3",
+			With: map[string]interface{}{
+				"name": planJobId,
+				"path": path,
+			},
+		},
+		applyStep,
+	)
+
+	return map[string]GitHubActionsJob{
+		planJobId: planJob,
+		jobId:     applyJob,
+	}
+}
+
 func (g GitHubActionsJob) AddNeeds(needs ...string) GitHubActionsJob {
 	g.Needs = append(g.Needs, needs...)
 	return g
@@ -85,16 +174,114 @@ type GitHubActionsStep struct {
 	Run  string                 `yaml:"run,omitempty"`
 }
 
+type GitHubActionsTriggers struct {
+	Push             *GitHubActionsTriggerEvent     `yaml:"push,omitempty"`
+	PullRequest      *GitHubActionsTriggerEvent     `yaml:"pull_request,omitempty"`
+	Schedule         []GitHubActionsSchedule        `yaml:"schedule,omitempty"`
+	WorkflowDispatch *GitHubActionsWorkflowDispatch `yaml:"workflow_dispatch,omitempty"`
+	verifyBuildApp   bool
+	drift            bool
+}
+
+// NewGitHubActionsTriggers maps configured triggers to workflow events, defaulting to push on trunk.
+func NewGitHubActionsTriggers(config TriggersConfig) GitHubActionsTriggers {
+	if config.IsEmpty() {
+		return GitHubActionsTriggers{
+			Push: &GitHubActionsTriggerEvent{
+				Branches: []string{"trunk"},
+			},
+		}
+	}
+
+	triggers := GitHubActionsTriggers{
+		Push: newGitHubActionsTriggerEvent(config.Push),
+	}
+	if config.PullRequest != nil {
+		triggers.PullRequest = newGitHubActionsTriggerEvent(&config.PullRequest.EventTriggerConfig)
+		triggers.verifyBuildApp = config.PullRequest.BuildApp
+	}
+	for _, schedule := range config.Schedule {
+		triggers.Schedule = append(triggers.Schedule, GitHubActionsSchedule{Cron: schedule.Cron})
+		triggers.drift = triggers.drift || schedule.Drift
+	}
+	if config.WorkflowDispatch != nil {
+		triggers.WorkflowDispatch = &GitHubActionsWorkflowDispatch{
+			Inputs: config.WorkflowDispatch.Inputs,
+		}
+	}
+	return triggers
+}
+
+func newGitHubActionsTriggerEvent(config *EventTriggerConfig) *GitHubActionsTriggerEvent {
+	if config == nil {
+		return nil
+	}
+	return &GitHubActionsTriggerEvent{
+		Branches: config.Branches,
+		Tags:     config.Tags,
+		Paths:    config.Paths,
+	}
+}
+
+// DeployArgs returns extra arguments for deploy-application, making pull request runs verify only,
+// and scheduled runs check for drift when enabled.
+func (g GitHubActionsTriggers) DeployArgs() []string {
+	args := g.verifyArgs()
+	if g.drift {
+		args = append(args, "--drift=${{ github.event_name == 'schedule' }}")
+	}
+	return args
+}
+
+// CheckOnlyEvents returns the events for which deploys only check applications.
+func (g GitHubActionsTriggers) CheckOnlyEvents() []string {
+	var events []string
+	if g.PullRequest != nil {
+		events = append(events, "pull_request")
+	}
+	if g.drift {
+		events = append(events, "schedule")
+	}
+	return events
+}
+
+func (g GitHubActionsTriggers) verifyArgs() []string {
+	if g.PullRequest == nil {
+		return nil
+	}
+	return []string{"--verify=${{ github.event_name == 'pull_request' }}"}
+}
+
+// BuildArgs returns extra arguments for build-artifact, making pull request runs verify only.
+func (g GitHubActionsTriggers) BuildArgs() []string {
+	args := g.verifyArgs()
+	if g.verifyBuildApp {
+		args = append(args, "--verify-build-app")
+	}
+	return args
+}
+
 type GitHubActionsTriggerEvent struct {
-	Branches []string
+	Branches []string `yaml:"branches,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`
+	Paths    []string `yaml:"paths,omitempty"`
+}
+
+type GitHubActionsSchedule struct {
+	Cron string `yaml:"cron"`
+}
+
+type GitHubActionsWorkflowDispatch struct {
+	Inputs map[string]WorkflowDispatchInput `yaml:"inputs,omitempty"`
 }
 
+// CheckoutRepoStep fetches full history so change detection can compare against any base.
 func CheckoutRepoStep() GitHubActionsStep {
 	return GitHubActionsStep{
 		Name: "Checkout Repo",
 		Uses: "actions/checkout@v3",
 		With: map[string]interface{}{
-			"fetch-depth": 2,
+			"fetch-depth": 0,
 		},
 	}
 }
@@ -153,15 +340,15 @@ func ConfigureGcloudDockerStep(host string) GitHubActionsStep {
 	}
 }
 
-func BuildArtifactStep(id string, configPath string, cmd string) GitHubActionsStep {
+func BuildArtifactStep(id string, configPath string, cmd string, extraArgs ...string) GitHubActionsStep {
 	return GitHubActionsStep{
 		Name: fmt.Sprintf("Build %s", id),
 		Run: strings.Join(
-			[]string{
+			append([]string{
 				fmt.Sprintf("go run %s build-artifact %s", cmd, id),
 				fmt.Sprintf("--config %s", configPath),
 				"--current-sha $GITHUB_SHA",
-			}, " \\\n  ",
+			}, extraArgs...), " \\\n  ",
 		),
 	}
 }
@@ -0,0 +1,544 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/itura/fun/pkg/fun"
+	"gopkg.in/yaml.v3"
+)
+
+// GitLabCiConfig is a .gitlab-ci.yml definition. Jobs are written as top level keys after the global settings.
+type GitLabCiConfig struct {
+	Variables map[string]string
+	Stages    []string
+	Jobs      map[string]GitLabCiJob
+}
+
+func NewGitLabCiConfig() GitLabCiConfig {
+	return GitLabCiConfig{
+		Variables: map[string]string{
+			// full history for change detection
+			"GIT_DEPTH": "0",
+		},
+		Jobs: map[string]GitLabCiJob{},
+	}
+}
+
+func (g GitLabCiConfig) SetJob(id string, job GitLabCiJob) GitLabCiConfig {
+	g.Jobs[id] = job
+	if !fun.Contains(g.Stages, job.Stage) {
+		g.Stages = append(g.Stages, job.Stage)
+		sort.SliceStable(g.Stages, func(i, j int) bool {
+			return stageDepth(g.Stages[i]) < stageDepth(g.Stages[j])
+		})
+	}
+	return g
+}
+
+func (g GitLabCiConfig) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	put := func(key string, value interface{}) error {
+		valueNode := &yaml.Node{}
+		err := valueNode.Encode(value)
+		if err != nil {
+			return err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, valueNode)
+		return nil
+	}
+
+	if err := put("variables", g.Variables); err != nil {
+		return nil, err
+	}
+	if err := put("stages", g.Stages); err != nil {
+		return nil, err
+	}
+	for _, id := range sortedKeys(g.Jobs) {
+		if err := put(id, g.Jobs[id]); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (g GitLabCiConfig) WriteYaml(path string) error {
+	return writeYaml(path, g)
+}
+
+type GitLabCiJob struct {
+	Stage     string                     `yaml:"stage"`
+	Image     string                     `yaml:"image"`
+	Services  []string                   `yaml:"services,omitempty"`
+	Variables map[string]string          `yaml:"variables,omitempty"`
+	IdTokens  map[string]GitLabCiIdToken `yaml:"id_tokens,omitempty"`
+	Needs     []string                   `yaml:"needs"`
+	Script    []string                   `yaml:"script"`
+}
+
+func NewGitLabCiJob(stage string) GitLabCiJob {
+	return GitLabCiJob{
+		Stage: stage,
+		Image: "golang:1.19",
+		Needs: []string{},
+	}
+}
+
+func (g GitLabCiJob) AddNeeds(needs ...string) GitLabCiJob {
+	g.Needs = append(g.Needs, needs...)
+	return g
+}
+
+func (g GitLabCiJob) AddSteps(steps ...GitLabCiStep) GitLabCiJob {
+	for _, step := range steps {
+		g.Script = append(g.Script, step.Script...)
+		for _, service := range step.Services {
+			if !fun.Contains(g.Services, service) {
+				g.Services = append(g.Services, service)
+			}
+		}
+		if len(step.Variables) > 0 && g.Variables == nil {
+			g.Variables = map[string]string{}
+		}
+		for k, v := range step.Variables {
+			g.Variables[k] = v
+		}
+		if len(step.IdTokens) > 0 && g.IdTokens == nil {
+			g.IdTokens = map[string]GitLabCiIdToken{}
+		}
+		for k, v := range step.IdTokens {
+			g.IdTokens[k] = v
+		}
+	}
+	return g
+}
+
+type GitLabCiIdToken struct {
+	Aud string `yaml:"aud"`
+}
+
+// GitLabCiStep is a fragment of a job. GitLab jobs have a single script, so steps are concatenated.
+type GitLabCiStep struct {
+	Script    []string
+	Services  []string
+	Variables map[string]string
+	IdTokens  map[string]GitLabCiIdToken
+}
+
+var invalidVariableCharacters = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// formatVariableName maps a name to a valid CI/CD variable name, replacing other characters with underscores.
+func formatVariableName(name string) string {
+	return invalidVariableCharacters.ReplaceAllString(name, "_")
+}
+
+func GitLabSetupGcloudStep() GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"curl -sSL https://sdk.cloud.google.com | bash -s -- --disable-prompts --install-dir=/opt > /dev/null",
+			"export PATH=/opt/google-cloud-sdk/bin:$PATH",
+		},
+	}
+}
+
+func GitLabGcpAuthStep(workloadIdentityProvider, serviceAccount string) GitLabCiStep {
+	return GitLabCiStep{
+		IdTokens: map[string]GitLabCiIdToken{
+			"GCP_ID_TOKEN": {Aud: "https://iam.googleapis.com/" + workloadIdentityProvider},
+		},
+		Script: []string{
+			`echo "$GCP_ID_TOKEN" > .gcp_id_token`,
+			fmt.Sprintf(
+				"gcloud iam workload-identity-pools create-cred-config %s --service-account=%s --credential-source-file=.gcp_id_token --output-file=.gcp_credentials.json",
+				workloadIdentityProvider,
+				serviceAccount,
+			),
+			"gcloud auth login --cred-file=.gcp_credentials.json",
+		},
+	}
+}
+
+func GitLabConfigureDockerStep(host string) GitLabCiStep {
+	return GitLabCiStep{
+		Services: []string{"docker:24-dind"},
+		Variables: map[string]string{
+			"DOCKER_HOST":        "tcp://docker:2375",
+			"DOCKER_TLS_CERTDIR": "",
+		},
+		Script: []string{
+			"apt-get update -qq && apt-get install -qq -y docker.io > /dev/null",
+			fmt.Sprintf("gcloud --quiet auth configure-docker %s", host),
+		},
+	}
+}
+
+func GitLabSetupGkeStep(cluster ClusterConfig) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"gcloud --quiet components install gke-gcloud-auth-plugin kubectl",
+			fmt.Sprintf("gcloud container clusters get-credentials %s --location %s", cluster.Name, cluster.Location),
+		},
+	}
+}
+
+func GitLabSetupHelmStep(version string) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"curl -fsSL https://raw.githubusercontent.com/helm/helm/main/scripts/get-helm-3 | DESIRED_VERSION=" + version + " bash",
+		},
+	}
+}
+
+// GitLabSetupBuildxStep emulates other platforms, and creates a builder able to build for them.
+func GitLabSetupBuildxStep() GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"apt-get update -qq && apt-get install -qq -y docker-buildx > /dev/null",
+			"docker run --privileged --rm tonistiigi/binfmt --install all",
+			"docker buildx create --use",
+		},
+	}
+}
+
+func GitLabSetupOrasStep() GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{orasInstallCommand(defaultOrasVersion)},
+	}
+}
+
+func GitLabSetupCosignStep() GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{cosignInstallCommand(defaultCosignVersion)},
+	}
+}
+
+func GitLabSetupNodeStep() GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"apt-get update -qq && apt-get install -qq -y nodejs npm > /dev/null",
+		},
+	}
+}
+
+// orasInstallCommand installs a release of the oras CLI, which pushes artifacts and tags them in the registry.
+func orasInstallCommand(version string) string {
+	return fmt.Sprintf("curl -fsSL https://github.com/oras-project/oras/releases/download/v%s/oras_%s_linux_amd64.tar.gz | tar -xz -C /usr/local/bin oras", version, version)
+}
+
+// kustomizeInstallCommand installs a release of kustomize from its release archive, on images without a package for it.
+func kustomizeInstallCommand(version string) string {
+	version = "v" + strings.TrimPrefix(version, "v")
+	return fmt.Sprintf("curl -fsSL https://github.com/kubernetes-sigs/kustomize/releases/download/kustomize%%2F%s/kustomize_%s_linux_amd64.tar.gz | tar -xz -C /usr/local/bin kustomize", version, version)
+}
+
+func GitLabSetupKustomizeStep(version string) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{kustomizeInstallCommand(version)},
+	}
+}
+
+func GitLabSetupTerraformStep(version string) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			"apt-get update -qq && apt-get install -qq -y unzip > /dev/null",
+			fmt.Sprintf("curl -fsSL -o /tmp/terraform.zip https://releases.hashicorp.com/terraform/%s/terraform_%s_linux_amd64.zip", version, version),
+			"unzip -o -q /tmp/terraform.zip -d /usr/local/bin",
+		},
+	}
+}
+
+func GitLabBuildArtifactStep(id string, buildArgs []RuntimeArg, configPath string, cmd string) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			withEnv(buildArgs, gitLabRunCommand(cmd, "build-artifact", id, configPath)),
+		},
+	}
+}
+
+func GitLabDeployStep(id string, runtimeArgs []RuntimeArg, configPath string, cmd string) GitLabCiStep {
+	return GitLabCiStep{
+		Script: []string{
+			withEnv(runtimeArgs, gitLabRunCommand(cmd, "deploy-application", id, configPath)),
+		},
+	}
+}
+
+func gitLabRunCommand(cmd string, subcommand string, id string, configPath string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("go run %s %s %s", cmd, subcommand, id),
+		"--config " + configPath,
+		"--current-sha $CI_COMMIT_SHA",
+		"--base-sha $CI_COMMIT_BEFORE_SHA",
+		`--base-branch "$CI_MERGE_REQUEST_TARGET_BRANCH_NAME"`,
+	}, " \\\n  ")
+}
+
+type GitLabGcpCloudProvider struct {
+	Config map[string]string
+}
+
+func (g GitLabGcpCloudProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []GitLabCiStep {
+	return []GitLabCiStep{
+		GitLabSetupGcloudStep(),
+		GitLabGcpAuthStep(
+			"$"+formatVariableName(g.Config["workloadIdentityProvider"]),
+			"$"+formatVariableName(g.Config["serviceAccount"]),
+		),
+	}
+}
+
+func (g GitLabGcpCloudProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type GitLabGcpDockerRepository struct {
+	Host string
+}
+
+func (g GitLabGcpDockerRepository) ResolveSetupSteps(secretConfigs []SecretConfig) []GitLabCiStep {
+	return []GitLabCiStep{GitLabConfigureDockerStep(g.Host)}
+}
+
+func (g GitLabGcpDockerRepository) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type GitLabGkeCluster struct {
+	Cluster ClusterConfig
+}
+
+func (g GitLabGkeCluster) ResolveSetupSteps(secretConfigs []SecretConfig) []GitLabCiStep {
+	return []GitLabCiStep{GitLabSetupGkeStep(g.Cluster)}
+}
+
+func (g GitLabGkeCluster) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+// GitLabCiVariableSecretProvider reads secrets from CI/CD variables.
+// It's used for `github-actions` secret providers, so the same config works for both.
+type GitLabCiVariableSecretProvider struct {
+	secretNames []string
+}
+
+func (g GitLabCiVariableSecretProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []GitLabCiStep {
+	return []GitLabCiStep{}
+}
+
+func (g GitLabCiVariableSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretConfig) []RuntimeArg {
+	var runtimeArgs []RuntimeArg
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(g.secretNames, secretConfig.SecretName) {
+			runtimeArgs = append(runtimeArgs, RuntimeArg{
+				Key:         secretConfig.Key,
+				Value:       fmt.Sprintf(`"${%s}"`, formatVariableName(secretConfig.SecretName)),
+				SecretValue: secretConfig.SecretName,
+			})
+		}
+	}
+	return runtimeArgs
+}
+
+func (g GitLabCiVariableSecretProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+type GitLabGcpSecretProvider struct {
+	id          string
+	project     string
+	secretNames []string
+}
+
+func (g GitLabGcpSecretProvider) ResolveSetupSteps(secretConfigs []SecretConfig) []GitLabCiStep {
+	var script []string
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(g.secretNames, secretConfig.SecretName) {
+			script = append(script, fmt.Sprintf(
+				`%s="$(gcloud secrets versions access latest --secret=%s --project=%s)"`,
+				g.variable(secretConfig.SecretName),
+				secretConfig.SecretName,
+				g.project,
+			))
+		}
+	}
+	if len(script) == 0 {
+		return []GitLabCiStep{}
+	}
+	return []GitLabCiStep{{Script: script}}
+}
+
+func (g GitLabGcpSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretConfig) []RuntimeArg {
+	var runtimeArgs []RuntimeArg
+	for _, secretConfig := range secretConfigs {
+		if fun.Contains(g.secretNames, secretConfig.SecretName) {
+			runtimeArgs = append(runtimeArgs, RuntimeArg{
+				Key:         secretConfig.Key,
+				Value:       fmt.Sprintf(`"${%s}"`, g.variable(secretConfig.SecretName)),
+				SecretValue: secretConfig.SecretName,
+			})
+		}
+	}
+	return runtimeArgs
+}
+
+func (g GitLabGcpSecretProvider) Validate(errs ValidationErrors) ValidationErrors {
+	return errs
+}
+
+func (g GitLabGcpSecretProvider) variable(secretName string) string {
+	return formatVariableName(fmt.Sprintf("secrets_%s_%s", g.id, secretName))
+}
+
+func NewGitLabCiFactory(configPath string, cmd string, config PipelineConfigRaw, dependencies Dependencies) GitLabCiFactory {
+	return GitLabCiFactory{
+		config:       config,
+		configPath:   configPath,
+		cmd:          cmd,
+		dependencies: dependencies,
+	}
+}
+
+type GitLabCiFactory struct {
+	config       PipelineConfigRaw
+	configPath   string
+	cmd          string
+	dependencies Dependencies
+}
+
+func (g GitLabCiFactory) GetCloudProvider(config CloudProviderConfig) CloudProvider1[GitLabCiStep] {
+	switch config.Type {
+	case cloudProviderTypeGcp:
+		return GitLabGcpCloudProvider{config.Config}
+	default:
+		return nil
+	}
+}
+
+func (g GitLabCiFactory) GetSecretProvider(config SecretProviderConfig) SecretProvider1[GitLabCiStep] {
+	switch config.Type {
+	case secretProviderTypeGithub:
+		return GitLabCiVariableSecretProvider{secretNames: config.SecretNames}
+	case secretProviderTypeGcp:
+		return GitLabGcpSecretProvider{
+			id:          config.Id,
+			project:     config.Config["project"],
+			secretNames: config.SecretNames,
+		}
+	default:
+		return nil
+	}
+}
+
+func (g GitLabCiFactory) GetArtifactRepositoryProvider(config ArtifactRepository) ArtifactRepositoryProvider[GitLabCiStep] {
+	switch config.Type {
+	case artifactRepositoryTypeGcpDocker:
+		return GitLabGcpDockerRepository{config.Host}
+	default:
+		return nil
+	}
+}
+
+func (g GitLabCiFactory) GetKubernetesProvider(config ClusterConfig) KubernetesProvider[GitLabCiStep] {
+	switch config.Type {
+	case "gke":
+		return GitLabGkeCluster{config}
+	default:
+		return nil
+	}
+}
+
+func (g GitLabCiFactory) Create() GitLabCiConfig {
+	config := NewGitLabCiConfig()
+	for _, artifact := range g.config.Artifacts {
+		config = config.SetJob(g.dependencies.GetJobId(artifact.Id), g.GetArtifactJob(artifact))
+	}
+	for _, application := range g.config.Applications {
+		config = config.SetJob(g.dependencies.GetJobId(application.Id), g.GetApplicationJob(application))
+	}
+	return config
+}
+
+func (g GitLabCiFactory) stage(id string) string {
+	return fmt.Sprintf("stage-%d", g.dependencies.GetDepth(id))
+}
+
+// stageDepth orders stages by depth, so stage-10 runs after stage-2.
+func stageDepth(stage string) int {
+	depth, _ := strconv.Atoi(strings.TrimPrefix(stage, "stage-"))
+	return depth
+}
+
+func (g GitLabCiFactory) GetArtifactJob(artifact ArtifactConfig) GitLabCiJob {
+	job := NewGitLabCiJob(g.stage(artifact.Id)).
+		AddNeeds(g.dependencies.GetUpstreamJobIds(artifact.Id)...).
+		AddSteps(ResolveCloudProviderSteps[GitLabCiStep](g, g.config.Resources.CloudProvider)...)
+	if artifact.Type.UsesRegistry(artifact.Docker.Cache) {
+		job = job.AddSteps(ResolveArtifactRepositorySteps[GitLabCiStep](g, g.config.Resources.ArtifactRepository)...)
+	}
+
+	switch artifact.Type {
+	case artifactTypeApp, artifactTypeLib:
+		if artifact.Type == artifactTypeApp {
+			job = job.AddSteps(GitLabSetupOrasStep())
+		}
+		if artifact.Docker.UsesBuildx(artifact.Platforms) {
+			job = job.AddSteps(GitLabSetupBuildxStep())
+		}
+		if !artifact.Docker.Sign.IsEmpty() {
+			job = job.AddSteps(GitLabSetupCosignStep())
+		}
+	case artifactTypeGoBinary:
+		job = job.AddSteps(GitLabSetupOrasStep())
+	case artifactTypeHelmChart:
+		job = job.AddSteps(GitLabSetupHelmStep(defaultHelmVersion), GitLabSetupOrasStep())
+	case artifactTypeNpm:
+		job = job.AddSteps(GitLabSetupNodeStep())
+	}
+
+	var buildArgs []RuntimeArg
+	for _, arg := range artifact.Docker.BuildArgs {
+		buildArgs = append(buildArgs, arg.ResolveShell("", "CI_COMMIT_SHA"))
+	}
+	return job.AddSteps(GitLabBuildArtifactStep(artifact.Id, buildArgs, g.configPath, g.cmd))
+}
+
+func (g GitLabCiFactory) GetApplicationJob(application ApplicationConfig) GitLabCiJob {
+	job := NewGitLabCiJob(g.stage(application.Id)).
+		AddNeeds(g.dependencies.GetUpstreamJobIds(application.Id)...).
+		AddSteps(ResolveCloudProviderSteps[GitLabCiStep](g, g.config.Resources.CloudProvider)...)
+
+	var runtimeArgs []RuntimeArg
+	for _, arg := range application.RuntimeValues() {
+		runtimeArgs = append(runtimeArgs, arg.ResolveShell("", "CI_COMMIT_SHA"))
+	}
+	secretSteps, secretArgs := ResolveSecrets[GitLabCiStep](g, g.config.Resources.SecretProviders, application.Secrets)
+	job = job.AddSteps(secretSteps...)
+	runtimeArgs = append(runtimeArgs, secretArgs...)
+
+	switch application.Type {
+	case applicationTypeHelm:
+		job = job.
+			AddSteps(ResolveKubernetesSteps[GitLabCiStep](g, g.config.Resources.KubernetesCluster)...).
+			AddSteps(GitLabSetupHelmStep(application.Helm.ClientVersion()))
+	case applicationTypeTerraform:
+		job = job.AddSteps(GitLabSetupTerraformStep(application.Terraform.ClientVersion()))
+	case applicationTypeKustomize, applicationTypeKubectl:
+		job = job.
+			AddSteps(ResolveKubernetesSteps[GitLabCiStep](g, g.config.Resources.KubernetesCluster)...).
+			AddSteps(GitLabSetupKustomizeStep(application.Manifests.ClientVersion()))
+	default:
+		panic("😅")
+	}
+	if application.readsRegistry() {
+		job = job.AddSteps(ResolveArtifactRepositorySteps[GitLabCiStep](g, g.config.Resources.ArtifactRepository)...)
+		job = job.AddSteps(GitLabSetupOrasStep())
+	}
+	if application.RequireSignedImages {
+		job = job.AddSteps(GitLabSetupCosignStep())
+	}
+
+	return job.AddSteps(GitLabDeployStep(application.Id, runtimeArgs, g.configPath, g.cmd))
+}
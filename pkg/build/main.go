@@ -12,6 +12,8 @@ type argv struct {
 	BuildArtifact     *BuildArtifactCommand     `arg:"subcommand:build-artifact"`
 	DeployApplication *DeployApplicationCommand `arg:"subcommand:deploy-application"`
 	Generate          *GenerateCommand          `arg:"subcommand:generate"`
+	Run               *RunCommand               `arg:"subcommand:run"`
+	Rollback          *RollbackCommand          `arg:"subcommand:rollback"`
 }
 
 func (a argv) Version() string {
@@ -36,14 +38,15 @@ func Run() int {
 	if err != nil {
 		return bail(err)
 	}
-	fmt.Printf("fun/build %s using %s\n", args.Version(), data)
+	// keep stdout clean for output meant to be consumed, like dry run plans
+	fmt.Fprintf(os.Stderr, "fun/build %s using %s\n", args.Version(), data)
 
 	err = command.Run()
 	if err != nil {
 		return bail(err)
 	}
 
-	fmt.Printf("😎\n")
+	fmt.Fprintf(os.Stderr, "😎\n")
 	return 0
 }
 
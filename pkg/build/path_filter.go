@@ -0,0 +1,92 @@
+package build
+
+import (
+	"path"
+	"strings"
+)
+
+// PathFilter matches repo-relative file paths against include and exclude patterns.
+// Patterns prefixed with `!` exclude files, and exclusions win over inclusions.
+// Patterns without glob characters match a file or everything below a directory,
+// `*` and `?` match within a path segment, and `**` matches any number of segments.
+type PathFilter struct {
+	include []string
+	exclude []string
+}
+
+func NewPathFilter(patterns ...string) PathFilter {
+	filter := PathFilter{}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			filter.exclude = append(filter.exclude, strings.TrimPrefix(pattern, "!"))
+		} else {
+			filter.include = append(filter.include, pattern)
+		}
+	}
+	return filter
+}
+
+func (p PathFilter) Match(file string) bool {
+	for _, pattern := range p.exclude {
+		if matchPattern(pattern, file) {
+			return false
+		}
+	}
+	for _, pattern := range p.include {
+		if matchPattern(pattern, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p PathFilter) MatchAny(files []string) bool {
+	for _, file := range files {
+		if p.Match(file) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern string, file string) bool {
+	pattern = strings.TrimPrefix(path.Clean(pattern), "./")
+	if pattern == "." {
+		return true
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return file == pattern || strings.HasPrefix(file, pattern+"/")
+	}
+
+	patternSegments := strings.Split(pattern, "/")
+	fileSegments := strings.Split(file, "/")
+	// a pattern matching a directory matches everything below it
+	for i := len(fileSegments); i > 0; i-- {
+		if matchSegments(patternSegments, fileSegments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern []string, file []string) bool {
+	if len(pattern) == 0 {
+		return len(file) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(file); i++ {
+			if matchSegments(pattern[1:], file[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(file) == 0 {
+		return false
+	}
+	matched, err := path.Match(pattern[0], file[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], file[1:])
+}
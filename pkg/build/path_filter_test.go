@@ -0,0 +1,37 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathFilter(t *testing.T) {
+	cases := []struct {
+		name     string
+		patterns []string
+		file     string
+		expected bool
+	}{
+		{"directory", []string{"pkg/api"}, "pkg/api/main.go", true},
+		{"directory with slash", []string{"pkg/api/"}, "pkg/api/main.go", true},
+		{"directory prefix is not a match", []string{"pkg/api"}, "pkg/api-client/main.go", false},
+		{"file", []string{"go.mod"}, "go.mod", true},
+		{"repo root", []string{"."}, "README.md", true},
+		{"single segment glob", []string{"pkg/*/main.go"}, "pkg/api/main.go", true},
+		{"single segment glob does not cross directories", []string{"pkg/*.go"}, "pkg/api/main.go", false},
+		{"recursive glob", []string{"**/*.go"}, "pkg/api/main.go", true},
+		{"recursive glob at root", []string{"**/*.go"}, "main.go", true},
+		{"recursive glob in the middle", []string{"pkg/**/main.go"}, "pkg/a/b/main.go", true},
+		{"glob matching a directory", []string{"pkg/a*"}, "pkg/api/main.go", true},
+		{"exclude", []string{"pkg/api", "!**/*_test.md"}, "pkg/api/docs/x_test.md", false},
+		{"exclude other files", []string{"pkg/api", "!**/*_test.md"}, "pkg/api/docs/x.md", true},
+		{"only excludes", []string{"!**/*.md"}, "pkg/api/main.go", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, NewPathFilter(tc.patterns...).Match(tc.file))
+		})
+	}
+}
@@ -64,33 +64,68 @@ func (p Pipeline) DeployApplication(id string) (SideEffects, error) {
 	}
 	build := application.PrepareBuild()
 
-	return build.Build()
+	sideEffects, err := build.Build()
+	if err != nil {
+		return SideEffects{}, err
+	}
+	sideEffects.Commands = append(application.SignatureChecks(), sideEffects.Commands...)
+	return sideEffects.Add(application.PostDeployChecks()...), nil
+}
+
+// SideEffects returns the side effects for either an artifact or an application.
+func (p Pipeline) SideEffects(id string) (SideEffects, error) {
+	if p.config.Dependencies.IsArtifact(id) {
+		return p.BuildArtifact(id)
+	}
+	return p.DeployApplication(id)
 }
 
 func (p Pipeline) ToGitHubWorkflow() GitHubActionsWorkflow {
 	jobs := map[string]GitHubActionsJob{}
+	triggers := NewGitHubActionsTriggers(p.config.Triggers)
 
 	dependencies := p.config.Dependencies
 	for id, artifact := range p.config.Artifacts {
 		jobId := dependencies.GetJobId(id)
-		jobs[jobId] = artifact.ToGitHubActionsJob(p.Cmd, p.ConfigPath)
+		jobs[jobId] = artifact.ToGitHubActionsJob(p.Cmd, p.ConfigPath, triggers.BuildArgs()...)
 	}
-	for id, app := range p.config.Applications {
-		jobId := dependencies.GetJobId(id)
-		jobs[jobId] = app.ToGitHubActionsJob(p.Cmd, p.ConfigPath, dependencies)
+	if len(p.config.Environments) == 0 {
+		for id, app := range p.config.Applications {
+			jobId := dependencies.GetJobId(id)
+			githubJob := app.ToGitHubActionsJob(p.Cmd, p.ConfigPath, dependencies, triggers.DeployArgs()...)
+			for splitJobId, splitJob := range TerraformJobs(jobId, githubJob, app.Path, app.Terraform, triggers) {
+				jobs[splitJobId] = splitJob
+			}
+		}
+	}
+
+	environments := map[string]Environment{}
+	for _, environment := range p.config.Environments {
+		environments[environment.Name] = environment
+	}
+	for _, job := range PlanEnvironmentJobs(p.config.Environments, dependencies) {
+		if job.IsApproval() {
+			jobs[job.JobId] = NewApprovalJob(job.Environment, job.Needs...)
+			continue
+		}
+		app := environments[job.Environment].Applications[job.ApplicationId]
+		deployArgs := append(triggers.DeployArgs(), "--environment "+job.Environment)
+		githubJob := app.ToGitHubActionsJob(p.Cmd, p.ConfigPath, dependencies, deployArgs...)
+		githubJob.Name = fmt.Sprintf("Deploy %s to %s", job.ApplicationId, job.Environment)
+		githubJob.Environment = job.Environment
+		githubJob.Needs = job.Needs
+		for splitJobId, splitJob := range TerraformJobs(job.JobId, githubJob, app.Path, app.Terraform, triggers) {
+			jobs[splitJobId] = splitJob
+		}
 	}
 
 	workflow := GitHubActionsWorkflow{
 		Name: p.Name,
-		On: map[string]GitHubActionsTriggerEvent{
-			"push": {
-				Branches: []string{"trunk"},
-			},
-		},
+		On:   triggers,
 		Jobs: jobs,
 	}
 
-	return workflow
+	return workflow.RunAfterSkippedJobs()
 }
 
 func resolveKey(value RuntimeArg) string {
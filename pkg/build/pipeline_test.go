@@ -1,12 +1,15 @@
 package build
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/itura/fun/pkg/build/mocks"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -39,6 +42,31 @@ func TestWorkflowGenerationE2e(t *testing.T) {
 	assert.Equal(t, expectedWorkflow, pipeline.ToGitHubWorkflow())
 }
 
+func TestGitLabCiGeneration(t *testing.T) {
+	expectedYamlBytes, err := os.ReadFile("test_fixtures/valid_gitlab_ci.yaml")
+	assert.Nil(t, err)
+
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	writer, err := ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.23", ciTargetGitlab)
+	assert.Nil(t, err)
+
+	outputPath := t.TempDir() + "/.gitlab-ci.yml"
+	assert.Nil(t, writer.WriteYaml(outputPath))
+	actualYamlBytes, err := os.ReadFile(outputPath)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expectedYamlBytes), string(actualYamlBytes))
+}
+
+func TestGitLabCiStagesOrderedByDepth(t *testing.T) {
+	config := NewGitLabCiConfig()
+	for _, stage := range []string{"stage-10", "stage-2", "stage-0", "stage-1"} {
+		config = config.SetJob("job-"+stage, NewGitLabCiJob(stage))
+	}
+
+	assert.Equal(t, []string{"stage-0", "stage-1", "stage-2", "stage-10"}, config.Stages)
+}
+
 func TestDeployTerraformApplication(t *testing.T) {
 	builder := NewTestBuilder()
 
@@ -70,6 +98,27 @@ func TestDeployTerraformApplication(t *testing.T) {
 				"plan",
 				"-out=plan.out",
 			}},
+		{
+			Name: "terraform",
+			Arguments: []string{
+				"-chdir=terraform/main",
+				"show",
+				"-json",
+				"plan.out",
+			},
+			Vars: PlanCheck{ApplicationId: "infra"},
+		},
+		WritePlanSummary(),
+		{
+			Name: "terraform",
+			Arguments: []string{
+				"-chdir=terraform/main",
+				"show",
+				"-json",
+				"plan.out",
+			},
+			Check: PlanCheck{ApplicationId: "infra"},
+		},
 		{
 			Name: "terraform",
 			Arguments: []string{
@@ -81,6 +130,116 @@ func TestDeployTerraformApplication(t *testing.T) {
 
 }
 
+const terraformConfigPath = "test_fixtures/terraform_pipeline_config.yaml"
+
+func TestDeployTerraformApplicationWithOptions(t *testing.T) {
+	args := TestArgs(terraformConfigPath)
+	args.Environment = "prod"
+	pipeline, err := ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+
+	sideEffects, err := pipeline.DeployApplication("infra")
+
+	assert.Nil(t, err)
+	init := NewCommand("terraform", "-chdir=tf/main", "init",
+		"-backend-config=bucket=tf-state",
+		"-backend-config=prefix=infra/prod",
+	)
+	workspace := NewCommand("terraform", "-chdir=tf/main", "workspace", "select", "-or-create", "prod")
+	plan := NewCommand("terraform", "-chdir=tf/main", "plan", "-out=plan.out", "-var-file=prod.tfvars").
+		SetEnv("TF_VAR_region", "us-central1").
+		SetEnv("TF_VAR_db_password", "$db_password")
+	show := NewCommand("terraform", "-chdir=tf/main", "show", "-json", "plan.out")
+	check := PlanCheck{ApplicationId: "infra", PreventDestroy: true}
+	summary := show.SetVars(check)
+	show = show.SetCheck(check)
+	apply := NewCommand("terraform", "-chdir=tf/main", "apply", "plan.out")
+	assert.Equal(t, []Command{init, workspace, plan, summary, WritePlanSummary(), show, apply}, sideEffects.Commands)
+
+	args.Phase = terraformPhasePlan
+	pipeline, err = ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	sideEffects, err = pipeline.DeployApplication("infra")
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{init, workspace, plan, summary, WritePlanSummary(), show}, sideEffects.Commands)
+
+	args.Phase = terraformPhaseApply
+	pipeline, err = ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	sideEffects, err = pipeline.DeployApplication("infra")
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{init, workspace, summary, WritePlanSummary(), show, apply}, sideEffects.Commands)
+
+	args.Phase = ""
+	args.AllowDestroy = true
+	pipeline, err = ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	sideEffects, err = pipeline.DeployApplication("infra")
+	assert.Nil(t, err)
+	assert.Equal(t, PlanCheck{ApplicationId: "infra"}, sideEffects.Commands[5].Check)
+
+	args.Drift = true
+	pipeline, err = ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	sideEffects, err = pipeline.DeployApplication("infra")
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		init,
+		workspace,
+		NewCommand("terraform", "-chdir=tf/main", "plan", "-lock=false", "-out=plan.out", "-var-file=prod.tfvars").
+			SetEnv("TF_VAR_region", "us-central1").
+			SetEnv("TF_VAR_db_password", "$db_password"),
+		NewCommand("terraform", "-chdir=tf/main", "show", "-json", "plan.out").
+			SetVars(PlanCheck{ApplicationId: "infra", Drift: true}),
+		WritePlanSummary(),
+		NewCommand("terraform", "-chdir=tf/main", "show", "-json", "plan.out").
+			SetCheck(PlanCheck{ApplicationId: "infra", Drift: true}),
+	}, sideEffects.Commands)
+
+	args.Drift = false
+	args.Phase = "destroy"
+	pipeline, err = ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	_, err = pipeline.DeployApplication("infra")
+	assert.EqualError(t, err, "unknown phase destroy")
+}
+
+func TestTerraformPlanApprovalWorkflow(t *testing.T) {
+	pipeline, err := ParsePipeline(TestArgs(terraformConfigPath), NewAlwaysChanged())
+	assert.Nil(t, err)
+
+	workflow := pipeline.ToGitHubWorkflow()
+
+	planJob := workflow.Jobs["plan-infra-prod"]
+	applyJob := workflow.Jobs["deploy-infra-prod"]
+	assert.Equal(t, "Plan infra to prod", planJob.Name)
+	assert.Equal(t, []string{"deploy-infra-staging"}, planJob.Needs)
+	assert.Equal(t, GetSetupTerraformStep("1.6.0"), planJob.Steps[len(planJob.Steps)-3])
+	assert.Contains(t, planJob.Steps[len(planJob.Steps)-2].Run, "--environment prod \\\n  --phase plan")
+	assert.Equal(t, GitHubActionsStep{
+		Name: "Upload plan",
+		Uses: "actions/upload-artifact@v3",
+		With: map[string]interface{}{"name": "plan-infra-prod", "path": "tf/main/plan.out"},
+	}, planJob.Steps[len(planJob.Steps)-1])
+
+	assert.Equal(t, "prod-infra", applyJob.Environment)
+	assert.Equal(t, "github.event_name != 'pull_request'", applyJob.If)
+	assert.Equal(t, []string{"plan-infra-prod"}, applyJob.Needs)
+	assert.Equal(t, "Download plan", applyJob.Steps[len(applyJob.Steps)-2].Name)
+	assert.Contains(t, applyJob.Steps[len(applyJob.Steps)-1].Run, "--phase apply")
+	assert.Equal(t, []string{"deploy-infra-prod", "deploy-api-chart-staging"}, workflow.Jobs["deploy-api-chart-prod"].Needs)
+
+	config, err := readFile(terraformConfigPath)
+	assert.Nil(t, err)
+	generated, err := ParseConfigForTarget(config, terraformConfigPath, pipeline.Cmd, ciTargetGithub)
+	assert.Nil(t, err)
+	assert.Equal(t, workflow, generated)
+
+	config.Environments = nil
+	_, err = ParseConfigForTarget(config, terraformConfigPath, pipeline.Cmd, ciTargetCircleci)
+	assert.EqualError(t, err, "terraform applyEnvironment is not supported for target 'circleci'")
+}
+
 func TestDeployHelmApplication(t *testing.T) {
 	builder := NewTestBuilder()
 
@@ -104,6 +263,7 @@ func TestDeployHelmApplication(t *testing.T) {
 			Arguments: []string{
 				"dep",
 				"update",
+				"helm/db",
 			},
 		},
 		{
@@ -121,14 +281,319 @@ func TestDeployHelmApplication(t *testing.T) {
 				"--set",
 				"tag=currentSha",
 				"--set",
-				"postgresql.dbName=$postgresql_dbName",
+				"postgresql.dbName=my-db",
 				"--set",
 				"postgresql.auth.password=$postgresql_auth_password",
 				"--set",
 				"postgresql.auth.username=$postgresql_auth_username",
-			}},
+			},
+			ExpandArgs: true,
+		},
+	}, sideEffects.Commands)
+
+}
+
+func TestDeployHelmApplicationWithReleaseOptions(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.Helm = HelmConfig{
+		ReleaseName:     "api",
+		Timeout:         "10m",
+		WaitForJobs:     true,
+		HistoryMax:      5,
+		CreateNamespace: true,
+		Test:            true,
+		HelmVersion:     "v3.12.0",
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "dep", "update", "helm/api"),
+		NewCommand("helm", "upgrade", "api", "helm/api",
+			"--install",
+			"--atomic",
+			"--timeout", "10m",
+			"--wait-for-jobs",
+			"--history-max", "5",
+			"--create-namespace",
+			"--namespace", "api",
+			"--set", "repo="+builder.repository(),
+			"--set", "tag="+builder.currentSha,
+		),
+		NewCommand("helm", "test", "api", "--namespace", "api", "--timeout", "10m"),
+	}, sideEffects.Commands)
+
+	steps := application.GetSteps("github.com/itura/fun/cmd/build@v0.1.19", "pipeline.yaml")
+	assert.Contains(t, steps, GetSetupHelmStep("v3.12.0"))
+}
+
+func TestDeployRemoteHelmChart(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("redis", "helm/redis", applicationTypeHelm).
+		SetNamespace("redis")
+	application.Helm = HelmConfig{
+		Chart:   "oci://registry-1.docker.io/bitnamicharts/redis",
+		Version: "18.1.0",
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "upgrade", "redis", "oci://registry-1.docker.io/bitnamicharts/redis",
+			"--version", "18.1.0",
+			"--install",
+			"--atomic",
+			"--namespace", "redis",
+			"--set", "repo="+builder.repository(),
+			"--set", "tag="+builder.currentSha,
+		),
+	}, sideEffects.Commands)
+
+	application.Verify = true
+	sideEffects, err = application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "template", "redis", "oci://registry-1.docker.io/bitnamicharts/redis",
+			"--version", "18.1.0",
+			"--namespace", "redis",
+			"--set", "repo="+builder.repository(),
+			"--set", "tag="+builder.currentSha,
+		),
+	}, sideEffects.Commands)
+}
+
+func TestDeployCanaryHelmApplication(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.Helm = HelmConfig{
+		ReleaseName: "api",
+		Test:        true,
+		Strategy: HelmStrategy{
+			Type:   helmStrategyCanary,
+			Weight: 20,
+			Check:  HealthCheck{Http: "https://api.example.com/healthz"},
+		},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+
+	assert.Nil(t, err)
+	values := []string{
+		"--namespace", "api",
+		"--set", "repo=" + builder.repository(),
+		"--set", "tag=" + builder.currentSha,
+	}
+	assert.Equal(t, []Command{
+		NewCommand("helm", "dep", "update", "helm/api"),
+		NewCommand("helm", "upgrade", "api-canary", "helm/api", "--install", "--atomic").
+			Add(values...).
+			Add("--set", "canary.weight=20"),
+		NewCommand("helm", "test", "api-canary", "--namespace", "api"),
+		NewCommand("curl", "--fail", "--silent", "--show-error", "--retry", "5", "--retry-all-errors", "--retry-delay", "5",
+			"https://api.example.com/healthz"),
+		NewCommand("helm", "upgrade", "api", "helm/api", "--install", "--atomic").Add(values...),
+		NewCommand("helm", "uninstall", "api-canary", "--namespace", "api"),
+	}, sideEffects.Commands)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "uninstall", "api-canary", "--namespace", "api"),
+	}, sideEffects.OnFailure)
+}
+
+func TestDeployBlueGreenHelmApplication(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.Helm = HelmConfig{
+		ReleaseName: "api",
+		Strategy: HelmStrategy{
+			Type:    helmStrategyBlueGreen,
+			Service: "api",
+			Check:   HealthCheck{Command: "./scripts/smoke.sh http://api-$IDLE_SLOT"},
+		},
+	}
+
+	sideEffects, err := application.PrepareBuild().Build()
+	assert.Nil(t, err)
+
+	runner := new(mocks.CommandRunner)
+	runner.On("Run", "helm", "dep", "update", "helm/api").Return(nil)
+	runner.On("Output", "kubectl", "get", "service", "api", "--namespace", "api",
+		"--ignore-not-found", "--output", "name").Return("service/api", nil)
+	runner.On("Output", "kubectl", "get", "service", "api", "--namespace", "api",
+		"--output", "jsonpath={.spec.selector.slot}").Return("blue", nil)
+	runner.On("Run", "helm", "upgrade", "api-green", "helm/api", "--install", "--atomic",
+		"--namespace", "api",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"--set", "slot=green",
+	).Return(nil)
+	runner.On("Run", "sh", "-c", "./scripts/smoke.sh http://api-green").Return(nil)
+	runner.On("Run", "kubectl", "patch", "service", "api", "--namespace", "api",
+		"--type", "merge", "--patch", `{"spec":{"selector":{"slot":"green"}}}`).Return(nil)
+
+	assert.Nil(t, sideEffects.Apply(runner))
+	runner.AssertExpectations(t)
+}
+
+func TestFirstBlueGreenDeploy(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.Helm = HelmConfig{
+		ReleaseName: "api",
+		Strategy:    HelmStrategy{Type: helmStrategyBlueGreen, Service: "api"},
+	}
+	sideEffects, err := application.PrepareBuild().Build()
+	assert.Nil(t, err)
+
+	// the Service exists, but doesn't select a slot yet
+	runner := new(mocks.CommandRunner)
+	runner.On("Run", "helm", "dep", "update", "helm/api").Return(nil)
+	runner.On("Output", "kubectl", "get", "service", "api", "--namespace", "api",
+		"--ignore-not-found", "--output", "name").Return("service/api", nil)
+	runner.On("Output", "kubectl", "get", "service", "api", "--namespace", "api",
+		"--output", "jsonpath={.spec.selector.slot}").Return("", nil)
+	runner.On("Run", "helm", "upgrade", "api-blue", "helm/api", "--install", "--atomic",
+		"--namespace", "api",
+		"--set", "repo="+builder.repository(),
+		"--set", "tag="+builder.currentSha,
+		"--set", "slot=blue",
+	).Return(nil)
+	runner.On("Run", "kubectl", "patch", "service", "api", "--namespace", "api",
+		"--type", "merge", "--patch", `{"spec":{"selector":{"slot":"blue"}}}`).Return(nil)
+
+	assert.Nil(t, sideEffects.Apply(runner))
+	runner.AssertExpectations(t)
+
+	// the Service is missing
+	runner = new(mocks.CommandRunner)
+	runner.On("Run", "helm", "dep", "update", "helm/api").Return(nil)
+	runner.On("Output", "kubectl", "get", "service", "api", "--namespace", "api",
+		"--ignore-not-found", "--output", "name").Return("", nil)
+
+	assert.EqualError(t, sideEffects.Apply(runner), "service api must exist in namespace api before the first blue/green deploy")
+	runner.AssertExpectations(t)
+}
+
+func TestVerifyHelmApplication(t *testing.T) {
+	builder := NewTestBuilder()
+
+	dbApp := PostgresHelmChart(builder)
+	dbApp.Verify = true
+	parsedConfig := SuccessfulParse(
+		"My Build",
+		map[string]Artifact{},
+		map[string]Application{
+			"db": dbApp,
+		},
+		NewDependencies(),
+	)
+	pipeline := NewPipeline(parsedConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.19")
+
+	sideEffects, err := pipeline.DeployApplication("db")
+
+	values := []string{
+		"--namespace",
+		"db-namespace",
+		"--set",
+		fmt.Sprintf("repo=%s", builder.repository()),
+		"--set",
+		"tag=currentSha",
+		"--set",
+		"postgresql.dbName=my-db",
+		"--set",
+		"postgresql.auth.password=$postgresql_auth_password",
+		"--set",
+		"postgresql.auth.username=$postgresql_auth_username",
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "dep", "update", "helm/db"),
+		NewCommand("helm", "lint", "helm/db").Add(values...).SetExpandArgs(true),
+		NewCommand("helm", "template", "db", "helm/db").Add(values...).SetExpandArgs(true),
+	}, sideEffects.Commands)
+}
+
+func TestVerifyTerraformApplication(t *testing.T) {
+	builder := NewTestBuilder()
+
+	terraformApp := builder.Application("infra", "terraform/main", applicationTypeTerraform)
+	terraformApp.Verify = true
+	parsedConfig := SuccessfulParse(
+		"My Build",
+		map[string]Artifact{},
+		map[string]Application{
+			"infra": terraformApp,
+		}, NewDependencies(),
+	)
+	pipeline := NewPipeline(parsedConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.19")
+
+	sideEffects, err := pipeline.DeployApplication("infra")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("terraform", "-chdir=terraform/main", "init"),
+		NewCommand("terraform", "-chdir=terraform/main", "plan", "-lock=false", "-out=plan.out"),
+		NewCommand("terraform", "-chdir=terraform/main", "show", "-json", "plan.out").
+			SetVars(PlanCheck{ApplicationId: "infra"}),
+		WritePlanSummary(),
+		NewCommand("terraform", "-chdir=terraform/main", "show", "-json", "plan.out").
+			SetCheck(PlanCheck{ApplicationId: "infra"}),
 	}, sideEffects.Commands)
+}
 
+func TestWorkflowTriggers(t *testing.T) {
+	builder := NewTestBuilder()
+	pipelineConfig := ValidPipelineConfig(builder).SetTriggers(TriggersConfig{
+		Push: &EventTriggerConfig{
+			Branches: []string{"trunk"},
+			Tags:     []string{"v*"},
+		},
+		PullRequest: &PullRequestTriggerConfig{
+			EventTriggerConfig: EventTriggerConfig{
+				Paths: []string{"pkg/**"},
+			},
+			BuildApp: true,
+		},
+		Schedule: ScheduleTriggerConfigs{{Cron: "0 6 * * 1"}},
+		WorkflowDispatch: &WorkflowDispatchConfig{
+			Inputs: map[string]WorkflowDispatchInput{
+				"reason": {Description: "why", Type: "string"},
+			},
+		},
+	})
+	pipeline := NewPipeline(pipelineConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.23")
+
+	workflow := pipeline.ToGitHubWorkflow()
+	bytes, err := yaml.Marshal(workflow.On)
+	assert.Nil(t, err)
+	assert.Equal(t, `push:
+    branches:
+        - trunk
+    tags:
+        - v*
+pull_request:
+    paths:
+        - pkg/**
+schedule:
+    - cron: 0 6 * * 1
+workflow_dispatch:
+    inputs:
+        reason:
+            description: why
+            type: string
+`, string(bytes))
+
+	deployStep := workflow.Jobs["deploy-db"].Steps[len(workflow.Jobs["deploy-db"].Steps)-1]
+	assert.True(t, strings.HasSuffix(deployStep.Run, "--verify=${{ github.event_name == 'pull_request' }}"))
+	buildStep := workflow.Jobs["build-api"].Steps[len(workflow.Jobs["build-api"].Steps)-1]
+	assert.True(t, strings.HasSuffix(buildStep.Run, "--verify=${{ github.event_name == 'pull_request' }} \\\n  --verify-build-app"))
 }
 
 func TestBuildChangedApplicationArtifact(t *testing.T) {
@@ -216,26 +681,145 @@ func TestBuildUnchangedApplicationArtifact(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []Command{
 		{
-			Name: "docker",
+			Name: "oras",
 			Arguments: []string{
-				"pull",
+				"resolve",
 				"us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:latest-green",
 			},
+			Vars: DigestVars{Name: "CLIENT_DIGEST"},
 		},
 		{
-			Name: "docker",
+			Name: "oras",
 			Arguments: []string{
 				"tag",
-				"us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:latest-green",
-				"us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:currentSha",
+				"us-central1-docker.pkg.dev/gcp-project/repo-name/client-app@$CLIENT_DIGEST",
+				"currentSha",
 			},
 		},
-		{
-			Name: "docker",
-			Arguments: []string{
-				"push",
-				"us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:currentSha",
-			},
+	}, sideEffects.Commands)
+}
+
+func TestVerifyChangedApplicationArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+
+	clientArtifact := builder.Artifact("client", "pkgs/client")
+	clientArtifact.Verify = true
+	parsedConfig := SuccessfulParse(
+		"My Build",
+		map[string]Artifact{
+			"client": clientArtifact,
 		},
+		map[string]Application{},
+		NewDependencies(),
+	)
+	pipeline := NewPipeline(parsedConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.19")
+
+	sideEffects, err := pipeline.BuildArtifact("client")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("docker", "build",
+			"-f", "pkgs/client/Dockerfile",
+			"-t", "us-central1-docker.pkg.dev/gcp-project/repo-name/client-test:currentSha",
+			"--target", "test",
+			"pkgs/client",
+		),
+		NewCommand("docker", "run", "--rm", "us-central1-docker.pkg.dev/gcp-project/repo-name/client-test:currentSha"),
 	}, sideEffects.Commands)
+
+	clientArtifact.VerifyBuildApp = true
+	sideEffects, err = NewDockerImage(clientArtifact).Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(sideEffects.Commands))
+	assert.Equal(t, NewCommand("docker", "build",
+		"-f", "pkgs/client/Dockerfile",
+		"-t", "us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:currentSha",
+		"--target", "app",
+		"pkgs/client",
+	), sideEffects.Commands[2])
+}
+
+func TestVerifyUnchangedApplicationArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+
+	clientArtifact := builder.Artifact("client", "pkgs/client")
+	clientArtifact.hasChanged = false
+	clientArtifact.Verify = true
+
+	sideEffects, err := NewDockerImage(clientArtifact).Build()
+
+	assert.Nil(t, err)
+	assert.Empty(t, sideEffects.Commands)
+}
+
+func TestPlanRecordsResolvedCommands(t *testing.T) {
+	t.Setenv("postgresql_auth_password", "hunter2")
+	builder := NewTestBuilder()
+	dbApp := PostgresHelmChart(builder)
+	dbApp.hasChanged = false
+	parsedConfig := SuccessfulParse(
+		"My Build",
+		map[string]Artifact{},
+		map[string]Application{
+			"db": dbApp,
+		},
+		builder.deps,
+	)
+	pipeline := NewPipeline(parsedConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.19")
+
+	plan, err := pipeline.Plan("db")
+
+	assert.Nil(t, err)
+	assert.Len(t, plan.Steps, 1)
+	step := plan.Steps[0]
+	assert.Equal(t, "deploy-db", step.JobId)
+	assert.Equal(t, false, step.Changed)
+	assert.Equal(t, []string{"helm/db"}, step.Paths)
+	assert.Equal(t, RecordedCommand{Name: "helm", Arguments: []string{"dep", "update", "helm/db"}}, step.Commands[0])
+	assert.Contains(t, step.Commands[1].Arguments, "postgresql.dbName=my-db")
+	assert.NotContains(t, step.Commands[1].Env, "postgresql_dbName")
+	assert.Equal(t, "***", step.Commands[1].Env["postgresql_auth_password"])
+
+	text := &strings.Builder{}
+	assert.Nil(t, plan.WriteText(text))
+	assert.Contains(t, text.String(), "deploy-db (unchanged in helm/db)\n  $ helm dep update helm/db\n")
+	assert.Contains(t, text.String(), "    postgresql_auth_password=***\n")
+	assert.NotContains(t, text.String(), "hunter2")
+
+	data := &strings.Builder{}
+	assert.Nil(t, plan.WriteJson(data))
+	var decoded Plan
+	assert.Nil(t, json.Unmarshal([]byte(data.String()), &decoded))
+	assert.Equal(t, plan, decoded)
+}
+
+func TestPlanRecordsRenderedValues(t *testing.T) {
+	t.Setenv("postgresql_auth_password", "hunter2")
+	t.Setenv("postgresql_auth_username", "admin")
+	builder := NewTestBuilder()
+	dbApp := PostgresHelmChart(builder)
+	dbApp.RenderValues = true
+	parsedConfig := SuccessfulParse("My Build", map[string]Artifact{}, map[string]Application{"db": dbApp}, builder.deps)
+	pipeline := NewPipeline(parsedConfig, "test_fixtures/valid_pipeline_config.yaml", "github.com/itura/fun/cmd/build@v0.1.19")
+
+	plan, err := pipeline.Plan("db")
+
+	assert.Nil(t, err)
+	step := plan.Steps[0]
+	assert.Equal(t, []RecordedFile{{
+		Path: "$DB_VALUES_FILE",
+		Content: `postgresql:
+    dbName: my-db
+    auth:
+        password: '***'
+        username: '***'
+`,
+	}}, step.Files)
+	assert.Contains(t, step.Commands[1].Arguments, "$DB_VALUES_FILE")
+
+	text := &strings.Builder{}
+	assert.Nil(t, plan.WriteText(text))
+	assert.Contains(t, text.String(), "  > $DB_VALUES_FILE\n      postgresql:\n          dbName: my-db\n")
+	assert.NotContains(t, text.String(), "hunter2")
 }
@@ -0,0 +1,172 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Plan describes what running a set of artifacts and applications would do, without doing it.
+type Plan struct {
+	ChangeDetection string     `json:"changeDetection"`
+	Steps           []PlanStep `json:"steps"`
+}
+
+type PlanStep struct {
+	Id      string   `json:"id"`
+	JobId   string   `json:"jobId"`
+	Changed bool     `json:"changed"`
+	Paths   []string `json:"paths"`
+	// Files are written before running the commands, with their content resolved as it would be
+	Files    []RecordedFile    `json:"files,omitempty"`
+	Commands []RecordedCommand `json:"commands"`
+	// OnFailure are the commands which would undo partial changes if a command failed
+	OnFailure []RecordedCommand `json:"onFailure,omitempty"`
+}
+
+func (s PlanStep) Decision() string {
+	if s.Changed {
+		return "changed"
+	}
+	return "unchanged"
+}
+
+func (p Pipeline) Plan(ids ...string) (Plan, error) {
+	plan := Plan{ChangeDetection: p.config.ChangeDetection}
+	for _, id := range ids {
+		sideEffects, err := p.SideEffects(id)
+		if err != nil {
+			return Plan{}, err
+		}
+
+		step, err := p.planStep(id, sideEffects)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+	return plan, nil
+}
+
+// PlanSideEffects describes side effects prepared for an id outside of a pipeline run, like a rollback.
+func (p Pipeline) PlanSideEffects(id string, sideEffects SideEffects) (Plan, error) {
+	step, err := p.planStep(id, sideEffects)
+	if err != nil {
+		return Plan{}, err
+	}
+	return Plan{Steps: []PlanStep{step}}, nil
+}
+
+func (p Pipeline) planStep(id string, sideEffects SideEffects) (PlanStep, error) {
+	runner := NewRecordingCommandRunner().Redact(p.secretEnv(id)...)
+	err := sideEffects.Apply(runner)
+	if err != nil {
+		return PlanStep{}, err
+	}
+	step := PlanStep{
+		Id:       id,
+		JobId:    p.config.Dependencies.GetJobId(id),
+		Changed:  p.hasChanged(id),
+		Paths:    p.config.Dependencies.GetAllPaths(id),
+		Files:    runner.Files(),
+		Commands: runner.Commands(),
+	}
+
+	onFailure := append([]Command{}, sideEffects.OnFailure...)
+	for _, command := range sideEffects.Commands {
+		for _, failureCommand := range command.OnFailure {
+			if !containsCommand(onFailure, failureCommand) {
+				onFailure = append(onFailure, failureCommand)
+			}
+		}
+	}
+	if len(onFailure) > 0 {
+		failureRunner := NewRecordingCommandRunner().Redact(p.secretEnv(id)...)
+		err = NewSideEffects(onFailure...).Apply(failureRunner)
+		if err != nil {
+			return PlanStep{}, err
+		}
+		step.OnFailure = failureRunner.Commands()
+	}
+	return step, nil
+}
+
+// secretEnv are the environment variables holding secret values for an id, which plans redact.
+func (p Pipeline) secretEnv(id string) []string {
+	args := p.config.Applications[id].RuntimeArgs
+	if artifact, ok := p.config.Artifacts[id]; ok {
+		args = artifact.Docker.BuildArgs
+	}
+	var keys []string
+	for _, arg := range args {
+		if arg.SecretValue != "" {
+			keys = append(keys, arg.EnvKey())
+		}
+	}
+	return keys
+}
+
+func (p Pipeline) hasChanged(id string) bool {
+	if artifact, ok := p.config.Artifacts[id]; ok {
+		return artifact.hasChanged
+	}
+	return p.config.Applications[id].hasChanged
+}
+
+func (p Plan) WriteText(w io.Writer) error {
+	builder := &strings.Builder{}
+	if p.ChangeDetection != "" {
+		builder.WriteString(fmt.Sprintf("change detection: %s\n", p.ChangeDetection))
+	}
+	for _, step := range p.Steps {
+		builder.WriteString(fmt.Sprintf(
+			"%s (%s in %s)\n",
+			step.JobId,
+			step.Decision(),
+			strings.Join(step.Paths, ", "),
+		))
+		writeFiles(builder, "  ", step.Files)
+		writeCommands(builder, "  ", step.Commands)
+		if len(step.OnFailure) > 0 {
+			builder.WriteString("  on failure:\n")
+			writeCommands(builder, "    ", step.OnFailure)
+		}
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func containsCommand(commands []Command, command Command) bool {
+	for _, c := range commands {
+		if reflect.DeepEqual(c, command) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeCommands(builder *strings.Builder, indent string, commands []RecordedCommand) {
+	for _, command := range commands {
+		builder.WriteString(fmt.Sprintf("%s$ %s\n", indent, command.String()))
+		for _, key := range sortedKeys(command.Env) {
+			builder.WriteString(fmt.Sprintf("%s    %s=%s\n", indent, key, command.Env[key]))
+		}
+	}
+}
+
+func writeFiles(builder *strings.Builder, indent string, files []RecordedFile) {
+	for _, file := range files {
+		builder.WriteString(fmt.Sprintf("%s> %s\n", indent, file.Path))
+		for _, line := range strings.Split(strings.TrimSuffix(file.Content, "\n"), "\n") {
+			builder.WriteString(fmt.Sprintf("%s    %s\n", indent, line))
+		}
+	}
+}
+
+func (p Plan) WriteJson(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
@@ -0,0 +1,225 @@
+package build
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// rollbackPrevious rolls Helm releases back to their previous revision. Other applications have no deploy history,
+	// so they're rolled back to a sha.
+	rollbackPrevious = "previous"
+	// rollbackDir holds worktrees of the shas Terraform and Helm applications are rolled back to
+	rollbackDir = ".rollback"
+	// rollbackToEnv passes the dispatched sha to the rollback command, so the input isn't interpolated into the script
+	rollbackToEnv = "ROLLBACK_TO"
+)
+
+// Rollback redeploys an application as of the sha the pipeline was parsed with, refusing when the images
+// pushed for that sha don't exist. With previous, Helm releases are rolled back to their previous revision instead.
+// Registries which skip checks, like the RecordingCommandRunner of a dry run, aren't read.
+func (p Pipeline) Rollback(id string, previous bool, registry CommandRunner) (SideEffects, error) {
+	application, present := p.config.Applications[id]
+	if !present {
+		return SideEffects{}, fmt.Errorf("invalid id %s", id)
+	}
+	if previous {
+		if application.Type != applicationTypeHelm {
+			return SideEffects{}, fmt.Errorf("%s can only be rolled back to a sha, previous only applies to Helm applications", id)
+		}
+		return NewHelm(application).Rollback(), nil
+	}
+
+	if !skipsChecks(registry) {
+		for _, image := range application.ArtifactImages() {
+			if registry.RunSilent("docker", "manifest", "inspect", image) != nil {
+				return SideEffects{}, fmt.Errorf("%s doesn't exist, refusing to roll back %s to %s", image, id, application.CurrentSha)
+			}
+		}
+	}
+
+	var sideEffects SideEffects
+	var err error
+	switch application.Type {
+	case applicationTypeTerraform:
+		sideEffects, err = NewTerraform(application).Rollback()
+	case applicationTypeHelm:
+		sideEffects, err = NewHelm(application).RollbackToSha()
+	case applicationTypeKustomize, applicationTypeKubectl:
+		sideEffects, err = application.PrepareBuild().Build()
+	default:
+		return SideEffects{}, fmt.Errorf("%s can't be rolled back", id)
+	}
+	if err != nil {
+		return SideEffects{}, err
+	}
+	sideEffects.Commands = append(application.SignatureChecks(), sideEffects.Commands...)
+	return sideEffects, nil
+}
+
+// Rollback rolls the release back to its previous revision. Blue/green releases are rolled back by flipping the Service
+// back to the idle slot, which still runs the previous release.
+func (b HelmDeployment) Rollback() SideEffects {
+	if b.Helm.Strategy.Type == helmStrategyBlueGreen {
+		return NewSideEffects(b.liveSlot(), b.flipSlot())
+	}
+	rollback := NewCommand("helm", "rollback", b.Helm.Release(b.Id), "--namespace", b.Namespace, "--wait")
+	if b.Helm.Timeout != "" {
+		rollback = rollback.Add("--timeout", b.Helm.Timeout)
+	}
+	return NewSideEffects(rollback)
+}
+
+// RollbackToSha deploys from a worktree checked out at the application's sha, so a local chart and values files
+// match it. Runtime args and Helm options are still those of the current config.
+func (b HelmDeployment) RollbackToSha() (SideEffects, error) {
+	worktree := rollbackWorktree(b.CurrentSha)
+	if b.isLocalChart() {
+		b.Path = path.Join(worktree, b.Path)
+	}
+	var valuesFiles []string
+	for _, file := range b.ValuesFiles {
+		valuesFiles = append(valuesFiles, path.Join(worktree, file))
+	}
+	b.ValuesFiles = valuesFiles
+	sideEffects, err := b.Build()
+	if err != nil {
+		return SideEffects{}, err
+	}
+	return inWorktree(sideEffects, worktree, b.CurrentSha), nil
+}
+
+// Rollback plans and applies from a worktree checked out at the application's sha, so modules and var files match it.
+func (b TfConfig) Rollback() (SideEffects, error) {
+	worktree := rollbackWorktree(b.CurrentSha)
+	b.Path = path.Join(worktree, b.Path)
+	b.Phase = ""
+	sideEffects, err := b.Build()
+	if err != nil {
+		return SideEffects{}, err
+	}
+	return inWorktree(sideEffects, worktree, b.CurrentSha), nil
+}
+
+func rollbackWorktree(sha string) string {
+	return path.Join(rollbackDir, sha)
+}
+
+// inWorktree checks out the worktree before the side effects, and removes it afterwards whether or not they succeed.
+func inWorktree(sideEffects SideEffects, worktree string, sha string) SideEffects {
+	sideEffects.Commands = append(
+		[]Command{NewCommand("git", "worktree", "add", "--detach", worktree, sha)},
+		sideEffects.Commands...,
+	)
+	remove := NewCommand("git", "worktree", "remove", "--force", worktree)
+	return sideEffects.Add(remove).AddOnFailure(remove)
+}
+
+// ToGitHubRollbackWorkflow generates a manually dispatched workflow rolling back the chosen application,
+// with a job for each application, and environment when there are any, which only runs when it's chosen.
+func (p Pipeline) ToGitHubRollbackWorkflow() GitHubActionsWorkflow {
+	jobs := map[string]GitHubActionsJob{}
+	inputs := map[string]WorkflowDispatchInput{
+		"to": {
+			Description: "sha to roll back to, or previous for Helm applications",
+			Required:    true,
+			Default:     rollbackPrevious,
+			Type:        "string",
+		},
+	}
+
+	if len(p.config.Environments) == 0 {
+		for id, app := range p.config.Applications {
+			jobs["rollback-"+id] = app.ToGitHubRollbackJob(p.Cmd, p.ConfigPath, "")
+		}
+	}
+
+	var environments []string
+	for _, environment := range p.config.Environments {
+		environments = append(environments, environment.Name)
+		for id, app := range environment.Applications {
+			job := app.ToGitHubRollbackJob(p.Cmd, p.ConfigPath, environment.Name)
+			if job.Environment == "" && environment.RequireApproval {
+				job.Environment = environment.Name
+			}
+			jobs[fmt.Sprintf("rollback-%s-%s", id, environment.Name)] = job
+		}
+	}
+	if len(environments) > 0 {
+		inputs["environment"] = WorkflowDispatchInput{
+			Description: "environment to roll back",
+			Required:    true,
+			Type:        "choice",
+			Options:     environments,
+		}
+	}
+
+	inputs["application"] = WorkflowDispatchInput{
+		Description: "application to roll back",
+		Required:    true,
+		Type:        "choice",
+		Options:     sortedKeys(p.config.Applications),
+	}
+
+	return GitHubActionsWorkflow{
+		Name: p.Name + " Rollback",
+		On: GitHubActionsTriggers{
+			WorkflowDispatch: &GitHubActionsWorkflowDispatch{Inputs: inputs},
+		},
+		Jobs: jobs,
+	}
+}
+
+// ToGitHubRollbackJob sets up the application like its deploy job, and runs the rollback command instead of deploying.
+// Docker is configured to check the artifact images exist, unless deploying already configures it to pin digests.
+// Terraform rollbacks wait on the apply environment, since they apply without a separate plan job.
+func (a Application) ToGitHubRollbackJob(cmd string, configPath string, environment string) GitHubActionsJob {
+	condition := fmt.Sprintf("inputs.application == '%s'", a.Id)
+	name := "Rollback " + a.Id
+	var args []string
+	if environment != "" {
+		condition += fmt.Sprintf(" && inputs.environment == '%s'", environment)
+		name += " in " + environment
+		args = append(args, "--environment "+environment)
+	}
+
+	steps := a.GetSteps(cmd, configPath)
+	last := len(steps) - 1
+	setupSteps := append([]GitHubActionsStep{}, steps[:last]...)
+	if len(a.ArtifactImages()) > 0 && !a.readsRegistry {
+		host, _, _ := strings.Cut(a.Repository, "/")
+		setupSteps = append(setupSteps, GetConfigureDockerSteps(host)...)
+	}
+	rollbackStep := GetRollbackStep(a.Id, a.RuntimeArgs, GetRollbackRunCommand(a.Id, cmd, configPath, args...))
+
+	job := GetGitHubActionsJob(a.Id, name, append(setupSteps, rollbackStep), NewDependencies())
+	job.If = condition
+	if a.Type == applicationTypeTerraform {
+		job.Environment = a.Terraform.ApplyEnvironment
+	}
+	return job
+}
+
+func GetRollbackStep(applicationId string, runtimeArgs []RuntimeArg, runCommand string) GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Rollback " + applicationId,
+		Env:  runtimeArgEnv(map[string]string{rollbackToEnv: "${{ inputs.to }}"}, runtimeArgs),
+		Run:  runCommand,
+	}
+}
+
+func GetRollbackRunCommand(applicationId string, cmd string, configPath string, extraArgs ...string) string {
+	return strings.Join(append([]string{
+		fmt.Sprintf("go run %s rollback %s", cmd, applicationId),
+		"--config " + configPath,
+		fmt.Sprintf(`--to "$%s"`, rollbackToEnv),
+	}, extraArgs...), " \\\n  ")
+}
+
+// RollbackWorkflowPath is where the rollback workflow is written alongside a generated workflow.
+func RollbackWorkflowPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "-rollback" + ext
+}
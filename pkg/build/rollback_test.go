@@ -0,0 +1,188 @@
+package build
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func rollbackPipeline(t *testing.T, sha string) Pipeline {
+	args := TestArgs("test_fixtures/valid_pipeline_config.yaml")
+	args.CurrentSha = sha
+	pipeline, err := ParsePipeline(args, NewAlwaysChanged())
+	assert.Nil(t, err)
+	return pipeline
+}
+
+func TestRollbackHelmApplicationToSha(t *testing.T) {
+	pipeline := rollbackPipeline(t, "previousSha")
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "docker", "manifest", "inspect", "us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:previousSha").Return(nil)
+	runner.On("RunSilent", "docker", "manifest", "inspect", "us-central1-docker.pkg.dev/gcp-project/repo-name/api-app:previousSha").Return(nil)
+
+	sideEffects, err := pipeline.Rollback("website", false, runner)
+
+	assert.Nil(t, err)
+	chart := ".rollback/previousSha/helm/website"
+	assert.Equal(t, []string{"git", "worktree", "add", "--detach", ".rollback/previousSha", "previousSha"},
+		commandLines(sideEffects.Commands)[0])
+	assert.Equal(t, []string{"helm", "dep", "update", chart}, commandLines(sideEffects.Commands)[1])
+	assert.Equal(t, []string{"upgrade", "website", chart}, sideEffects.Commands[2].Arguments[:3])
+	assert.Contains(t, sideEffects.Commands[2].Arguments, "tag=previousSha")
+	assert.Equal(t, [][]string{
+		{"git", "worktree", "remove", "--force", ".rollback/previousSha"},
+	}, commandLines(sideEffects.Commands[3:]))
+	assert.Equal(t, [][]string{
+		{"git", "worktree", "remove", "--force", ".rollback/previousSha"},
+	}, commandLines(sideEffects.OnFailure))
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackDryRunDoesNotReadRegistry(t *testing.T) {
+	pipeline := rollbackPipeline(t, "previousSha")
+	registry := NewRecordingCommandRunner()
+
+	_, err := pipeline.Rollback("website", false, registry)
+
+	assert.Nil(t, err)
+	assert.Empty(t, registry.Commands())
+}
+
+func TestRollbackRefusesMissingImages(t *testing.T) {
+	pipeline := rollbackPipeline(t, "previousSha")
+	runner := new(mocks.CommandRunner)
+	runner.On("RunSilent", "docker", "manifest", "inspect", "us-central1-docker.pkg.dev/gcp-project/repo-name/client-app:previousSha").Return(nil)
+	runner.On("RunSilent", "docker", "manifest", "inspect", "us-central1-docker.pkg.dev/gcp-project/repo-name/api-app:previousSha").Return(fmt.Errorf("no such manifest"))
+
+	_, err := pipeline.Rollback("website", false, runner)
+
+	assert.EqualError(t, err, "us-central1-docker.pkg.dev/gcp-project/repo-name/api-app:previousSha doesn't exist, refusing to roll back website to previousSha")
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackHelmApplicationToPrevious(t *testing.T) {
+	pipeline := rollbackPipeline(t, "previousSha")
+	runner := new(mocks.CommandRunner)
+
+	sideEffects, err := pipeline.Rollback("website", true, runner)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("helm", "rollback", "website", "--namespace", "website-namespace", "--wait"),
+	}, sideEffects.Commands)
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackTerraformApplication(t *testing.T) {
+	pipeline := rollbackPipeline(t, "previousSha")
+	runner := new(mocks.CommandRunner)
+
+	sideEffects, err := pipeline.Rollback("infra", false, runner)
+
+	assert.Nil(t, err)
+	chdir := "-chdir=.rollback/previousSha/tf/main"
+	assert.Equal(t, [][]string{
+		{"git", "worktree", "add", "--detach", ".rollback/previousSha", "previousSha"},
+		{"terraform", chdir, "init"},
+		{"terraform", chdir, "plan", "-out=plan.out"},
+		{"terraform", chdir, "show", "-json", "plan.out"},
+		commandLines([]Command{WritePlanSummary()})[0],
+		{"terraform", chdir, "show", "-json", "plan.out"},
+		{"terraform", chdir, "apply", "plan.out"},
+		{"git", "worktree", "remove", "--force", ".rollback/previousSha"},
+	}, commandLines(sideEffects.Commands))
+	assert.Equal(t, [][]string{
+		{"git", "worktree", "remove", "--force", ".rollback/previousSha"},
+	}, commandLines(sideEffects.OnFailure))
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackToPreviousOnlyAppliesToHelm(t *testing.T) {
+	pipeline := rollbackPipeline(t, "currentSha")
+	runner := new(mocks.CommandRunner)
+
+	_, err := pipeline.Rollback("infra", true, runner)
+
+	assert.EqualError(t, err, "infra can only be rolled back to a sha, previous only applies to Helm applications")
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackArgsResolveSha(t *testing.T) {
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "git", "rev-parse", "--verify", "HEAD^{commit}").Return("currentSha", nil)
+	runner.On("Output", "git", "rev-parse", "--verify", "abc123^{commit}").Return("", fmt.Errorf("exit status 128"))
+
+	sha, err := RollbackArgs{To: "previous"}.Sha(runner)
+	assert.Nil(t, err)
+	assert.Equal(t, "currentSha", sha)
+
+	_, err = RollbackArgs{To: "abc123"}.Sha(runner)
+	assert.EqualError(t, err, "can't resolve abc123 to a commit: exit status 128")
+	runner.AssertExpectations(t)
+}
+
+func TestRollbackWorkflow(t *testing.T) {
+	pipeline := rollbackPipeline(t, "currentSha")
+
+	workflow := pipeline.ToGitHubRollbackWorkflow()
+
+	assert.Equal(t, "My Build Rollback", workflow.Name)
+	assert.Nil(t, workflow.On.Push)
+	inputs := workflow.On.WorkflowDispatch.Inputs
+	assert.Equal(t, []string{"db", "infra", "website"}, inputs["application"].Options)
+	assert.Equal(t, "previous", inputs["to"].Default)
+	assert.Equal(t, []string{"rollback-db", "rollback-infra", "rollback-website"}, sortedKeys(workflow.Jobs))
+
+	job := workflow.Jobs["rollback-website"]
+	assert.Equal(t, "Rollback website", job.Name)
+	assert.Equal(t, "inputs.application == 'website'", job.If)
+	assert.Nil(t, job.Needs)
+	step := job.Steps[len(job.Steps)-1]
+	assert.Equal(t, "Rollback website", step.Name)
+	assert.Equal(t, "${{ inputs.to }}", step.Env["ROLLBACK_TO"])
+	assert.Equal(t, fmt.Sprintf(`go run %s rollback website \
+  --config test_fixtures/valid_pipeline_config.yaml \
+  --to "$ROLLBACK_TO"`, pipeline.Cmd), step.Run)
+	deploySteps := stepNames(pipeline.ToGitHubWorkflow().Jobs["deploy-website"].Steps)
+	assert.Equal(t, append(deploySteps[:len(deploySteps)-1], "Configure GCloud SDK", "Configure Docker", "Rollback website"), stepNames(job.Steps))
+	assert.NotContains(t, stepNames(workflow.Jobs["rollback-infra"].Steps), "Configure Docker")
+}
+
+func TestRollbackWorkflowWithEnvironments(t *testing.T) {
+	pipeline, err := ParsePipeline(TestArgs("test_fixtures/environments_pipeline_config.yaml"), NewAlwaysChanged())
+	assert.Nil(t, err)
+
+	workflow := pipeline.ToGitHubRollbackWorkflow()
+
+	assert.Equal(t, []string{"dev", "staging", "prod"}, workflow.On.WorkflowDispatch.Inputs["environment"].Options)
+	job := workflow.Jobs["rollback-api-chart-prod"]
+	assert.Equal(t, "Rollback api-chart in prod", job.Name)
+	assert.Equal(t, "inputs.application == 'api-chart' && inputs.environment == 'prod'", job.If)
+	assert.Equal(t, "prod", job.Environment)
+	assert.Equal(t, "", workflow.Jobs["rollback-api-chart-dev"].Environment)
+	assert.Contains(t, job.Steps[len(job.Steps)-1].Run, "--environment prod")
+}
+
+func TestRollbackWorkflowPath(t *testing.T) {
+	assert.Equal(t, ".github/workflows/ci-rollback.yaml", RollbackWorkflowPath(".github/workflows/ci.yaml"))
+}
+
+func commandLines(commands []Command) [][]string {
+	var lines [][]string
+	for _, command := range commands {
+		lines = append(lines, append([]string{command.Name}, command.Arguments...))
+	}
+	return lines
+}
+
+func TestRollbackBlueGreenHelmApplicationToPrevious(t *testing.T) {
+	application := NewTestBuilder().Application("api-chart", "helm/api", applicationTypeHelm).SetNamespace("api")
+	application.Helm.Strategy = HelmStrategy{Type: helmStrategyBlueGreen, Service: "api"}
+
+	assert.Equal(t, [][]string{
+		{"kubectl", "get", "service", "api", "--namespace", "api", "--output", "jsonpath={.spec.selector.slot}"},
+		{"kubectl", "patch", "service", "api", "--namespace", "api", "--type", "merge", "--patch", `{"spec":{"selector":{"slot":"$IDLE_SLOT"}}}`},
+	}, commandLines(NewHelm(application).Rollback().Commands))
+}
@@ -90,8 +90,9 @@ func (g GitHubActionsSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretCo
 	for _, secretConfig := range secretConfigs {
 		if fun.Contains(g.secretNames, secretConfig.SecretName) {
 			runtimeArgs = append(runtimeArgs, RuntimeArg{
-				Key:   secretConfig.Key,
-				Value: g.resolve(secretConfig.SecretName),
+				Key:         secretConfig.Key,
+				Value:       g.resolve(secretConfig.SecretName),
+				SecretValue: secretConfig.SecretName,
 			})
 		}
 	}
@@ -133,8 +134,9 @@ func (g GcpSecretProvider) ResolveRuntimeArgs(secretConfigs []SecretConfig) []Ru
 	for _, secretConfig := range secretConfigs {
 		if fun.Contains(g.secretNames, secretConfig.SecretName) {
 			runtimeArgs = append(runtimeArgs, RuntimeArg{
-				Key:   secretConfig.Key,
-				Value: g.resolve(secretConfig.SecretName),
+				Key:         secretConfig.Key,
+				Value:       g.resolve(secretConfig.SecretName),
+				SecretValue: secretConfig.SecretName,
 			})
 		}
 	}
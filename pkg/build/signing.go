@@ -0,0 +1,114 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	defaultCosignVersion = "v2.2.0"
+	// githubOidcIssuer issues the tokens keyless signatures are made with in GitHub Actions
+	githubOidcIssuer = "https://token.actions.githubusercontent.com"
+)
+
+// SignOptions sign pushed app images with cosign, either keylessly with the CI's OIDC token or with a key.
+type SignOptions struct {
+	Keyless bool
+	// Key is a cosign key reference, such as gcpkms://projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+	Key string
+	// Identity is a regular expression matching the workflow identity of keyless signatures, checked when deploying
+	Identity string
+}
+
+func (s SignOptions) IsEmpty() bool {
+	return s == SignOptions{}
+}
+
+func (s SignOptions) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if s.IsEmpty() {
+		return errs
+	}
+	if s.Keyless == (s.Key != "") {
+		errs = errs.Put("key", fmt.Errorf("one of keyless or key is required"))
+	}
+	if s.Keyless && s.Identity == "" {
+		errs = errs.Put("identity", fmt.Errorf("required to verify keyless signatures"))
+	}
+	if s.Identity != "" {
+		if _, err := regexp.Compile(s.Identity); err != nil {
+			errs = errs.Put("identity", fmt.Errorf("'%s' is not a regular expression", s.Identity))
+		}
+	}
+	if !s.Keyless && s.Identity != "" {
+		errs = errs.Put("identity", fmt.Errorf("only applies to keyless signatures"))
+	}
+	return errs
+}
+
+// sign signs the digest the image was pushed with, so promoting it later by tag keeps the signature.
+func (s SignOptions) sign(image string) Command {
+	sign := NewCommand("cosign", "sign", "--yes")
+	if s.Key != "" {
+		sign = sign.Add("--key", s.Key)
+	}
+	return sign.Add(image)
+}
+
+// verify checks the image has a signature made with the key, or keylessly by the identity.
+func (s SignOptions) verify(image string) Command {
+	verify := NewCommand("cosign", "verify")
+	if s.Key != "" {
+		verify = verify.Add("--key", s.Key)
+	} else {
+		verify = verify.Add("--certificate-identity-regexp", s.Identity, "--certificate-oidc-issuer", githubOidcIssuer)
+	}
+	return verify.Add(image)
+}
+
+// sign resolves the digest of the pushed app image and signs it.
+func (b DockerImage) sign() []Command {
+	if b.Docker.Sign.IsEmpty() {
+		return nil
+	}
+	return []Command{
+		NewCommand("oras", "resolve", b.AppImageName(b.CurrentSha)).SetVars(DigestVars{Name: digestVar(b.Id)}),
+		b.Docker.Sign.sign(fmt.Sprintf("%s@$%s", b.AppImageBase(), digestVar(b.Id))),
+	}
+}
+
+// SignatureChecks refuse to deploy artifact images which aren't signed, when the application requires signed images.
+// The digest of each image is resolved once and verified, and deploying references the same digest, so the commit tag
+// can't be moved between checking and deploying.
+func (a Application) SignatureChecks() []Command {
+	if !a.verifiesSignatures() {
+		return nil
+	}
+	ids := a.artifactImageIds()
+	checks := a.resolveDigests(ids)
+	for _, id := range ids {
+		checks = append(checks, a.Signing[id].verify(a.pinnedImage(id)))
+	}
+	return checks
+}
+
+// verifiesSignatures is true when deploying checks signatures. Nothing is pushed when verifying, and checking for
+// drift doesn't deploy, so neither checks signatures.
+func (a Application) verifiesSignatures() bool {
+	return a.RequireSignedImages && !a.Verify && !a.Drift
+}
+
+func GetSetupCosignStep() GitHubActionsStep {
+	return GitHubActionsStep{
+		Name: "Setup Cosign",
+		Uses: "sigstore/cosign-installer@v3",
+		With: map[string]interface{}{
+			"cosign-release": defaultCosignVersion,
+		},
+	}
+}
+
+// cosignInstallCommand installs a release of the cosign CLI, for CI systems without an action for it.
+func cosignInstallCommand(version string) string {
+	return fmt.Sprintf("curl -fsSL -o /usr/local/bin/cosign https://github.com/sigstore/cosign/releases/download/%s/cosign-linux-amd64 && chmod +x /usr/local/bin/cosign", version)
+}
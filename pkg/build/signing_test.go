@@ -0,0 +1,229 @@
+package build
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const kmsKey = "gcpkms://projects/gcp-project/locations/global/keyRings/build/cryptoKeys/images"
+
+func TestBuildSignedAppArtifact(t *testing.T) {
+	builder := NewTestBuilder()
+	artifact := builder.Artifact("api", "packages/api")
+	artifact.Docker = DockerOptions{
+		Targets:    DockerTargets{SkipTest: true},
+		Sbom:       true,
+		Provenance: true,
+		Sign:       SignOptions{Key: kmsKey},
+	}
+	commitTag := artifact.AppImageName("currentSha")
+
+	build, err := artifact.PrepareBuild()
+	assert.Nil(t, err)
+	sideEffects, err := build.Build()
+
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("docker", "buildx", "build",
+			"-f", "packages/api/Dockerfile",
+			"--target", "app",
+			"--sbom=true", "--provenance=mode=max",
+			"-t", commitTag, "-t", artifact.AppImageName("latest-green"), "--push",
+			"packages/api",
+		),
+		NewCommand("oras", "resolve", commitTag).SetVars(DigestVars{Name: "API_DIGEST"}),
+		NewCommand("cosign", "sign", "--yes", "--key", kmsKey, artifact.AppImageBase()+"@$API_DIGEST"),
+	), sideEffects)
+
+	assert.Equal(t, []string{"Checkout Repo", "Setup Go", "Authenticate to GCloud via Service Account",
+		"Configure GCloud SDK", "Configure Docker", "Setup ORAS", "Setup Docker Buildx", "Setup Cosign", "Build api"},
+		stepNames(artifact.GetSteps("./cmd/build", "pipeline.yaml")))
+
+	artifact.hasChanged = false
+	build, _ = artifact.PrepareBuild()
+	sideEffects, err = build.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, NewSideEffects(
+		NewCommand("oras", "resolve", artifact.AppImageName("latest-green")).SetVars(DigestVars{Name: "API_DIGEST"}),
+		NewCommand("oras", "tag", artifact.AppImageBase()+"@$API_DIGEST", "currentSha"),
+	), sideEffects)
+}
+
+func TestDeployRequiringSignedImages(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("website", "helm/website", applicationTypeHelm).
+		SetNamespace("website")
+	application.Artifacts = []string{"client"}
+	application.RuntimeArgs = []RuntimeArg{{Key: "api.image", ArtifactImage: "api"}}
+	application.RequireSignedImages = true
+	application.readsRegistry = true
+	application.Signing = map[string]SignOptions{
+		"client": {Key: kmsKey},
+		"api":    {Keyless: true, Identity: "^https://github.com/itura/fun/"},
+	}
+	pipeline := NewPipeline(
+		SuccessfulParse("My Build", map[string]Artifact{}, map[string]Application{"website": application}, NewDependencies()),
+		"pipeline.yaml",
+		"github.com/itura/fun/cmd/build@v0.1.19",
+	)
+
+	sideEffects, err := pipeline.DeployApplication("website")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Command{
+		NewCommand("oras", "resolve", builder.repository()+"/client-app:currentSha").SetVars(DigestVars{Name: "CLIENT_DIGEST"}),
+		NewCommand("oras", "resolve", builder.repository()+"/api-app:currentSha").SetVars(DigestVars{Name: "API_DIGEST"}),
+		NewCommand("cosign", "verify", "--key", kmsKey, builder.repository()+"/client-app@$CLIENT_DIGEST"),
+		NewCommand("cosign", "verify",
+			"--certificate-identity-regexp", "^https://github.com/itura/fun/",
+			"--certificate-oidc-issuer", "https://token.actions.githubusercontent.com",
+			builder.repository()+"/api-app@$API_DIGEST"),
+		NewCommand("helm", "dep", "update", "helm/website"),
+	}, sideEffects.Commands[:5])
+	// the verified digest is deployed, rather than resolving the commit tag again
+	assert.Contains(t, sideEffects.Commands[5].Arguments, "api.image="+builder.repository()+"/api-app@$API_DIGEST")
+	assert.Len(t, sideEffects.Commands, 6)
+	assert.Equal(t, []string{"Authenticate to GKE Cluster", "Setup Helm", "Configure GCloud SDK", "Configure Docker",
+		"Setup ORAS", "Setup Cosign", "Deploy website"},
+		stepNames(application.GetSteps("./cmd/build", "pipeline.yaml")))
+
+	application.Verify = true
+	assert.Empty(t, application.SignatureChecks())
+
+	worker := builder.Application("worker", "k8s/worker", applicationTypeKustomize)
+	worker.Artifacts = []string{"api"}
+	worker.RequireSignedImages = true
+	sideEffects, err = NewManifests(worker).Build()
+	assert.Nil(t, err)
+	assert.Equal(t,
+		NewCommand("kustomize", "edit", "set", "image", builder.repository()+"/api-app="+builder.repository()+"/api-app@$API_DIGEST").SetDir("k8s/worker"),
+		sideEffects.Commands[0])
+}
+
+func TestEnvironmentRequiresSignedImages(t *testing.T) {
+	config := PipelineConfigRaw{
+		Applications: []ApplicationConfig{{Id: "api-chart", Path: "helm/api"}},
+		Environments: EnvironmentConfigs{{Name: "prod", RequireSignedImages: true}, {Name: "dev"}},
+	}
+
+	prod, err := config.ForEnvironment("prod")
+	assert.Nil(t, err)
+	assert.True(t, prod.Applications[0].RequireSignedImages)
+	assert.True(t, prod.Applications[0].readsRegistry())
+
+	dev, err := config.ForEnvironment("dev")
+	assert.Nil(t, err)
+	assert.False(t, dev.Applications[0].RequireSignedImages)
+}
+
+func TestSignOptionsValidation(t *testing.T) {
+	cases := []struct {
+		name     string
+		sign     SignOptions
+		expected ValidationErrors
+	}{
+		{
+			name:     "Key",
+			sign:     SignOptions{Key: kmsKey},
+			expected: NewValidationErrors("sign"),
+		},
+		{
+			name:     "Keyless",
+			sign:     SignOptions{Keyless: true, Identity: "^https://github.com/itura/fun/"},
+			expected: NewValidationErrors("sign"),
+		},
+		{
+			name: "Both",
+			sign: SignOptions{Keyless: true, Key: kmsKey, Identity: "("},
+			expected: NewValidationErrors("sign").
+				Put("key", fmt.Errorf("one of keyless or key is required")).
+				Put("identity", fmt.Errorf("'(' is not a regular expression")),
+		},
+		{
+			name: "KeylessWithoutIdentity",
+			sign: SignOptions{Keyless: true},
+			expected: NewValidationErrors("sign").
+				Put("identity", fmt.Errorf("required to verify keyless signatures")),
+		},
+		{
+			name: "IdentityWithKey",
+			sign: SignOptions{Key: kmsKey, Identity: ".*"},
+			expected: NewValidationErrors("sign").
+				Put("identity", fmt.Errorf("only applies to keyless signatures")),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.sign.Validate("sign"))
+		})
+	}
+}
+
+func TestRequireSignedImagesValidation(t *testing.T) {
+	config := PipelineConfigRaw{
+		Artifacts: []ArtifactConfig{
+			{Id: "api", Path: "packages/api", Docker: DockerOptions{Sign: SignOptions{Key: kmsKey}}},
+			{Id: "client", Path: "packages/client"},
+			{Id: "worker", Path: "packages/worker"},
+			{Id: "pkg", Path: ".", Type: artifactTypeLib, Docker: DockerOptions{Sbom: true}},
+		},
+		Applications: []ApplicationConfig{
+			{Id: "website", Path: "helm/website", Artifacts: []string{"api", "client"}},
+			{Id: "jobs", Path: "helm/jobs", Artifacts: []string{"api"}},
+		},
+		Environments: EnvironmentConfigs{
+			{Name: "dev", Applications: map[string]EnvironmentApplicationConfig{
+				"jobs": {Values: []RuntimeArg{{Key: "dev.image", ArtifactImage: "worker"}}},
+			}},
+			{Name: "prod", RequireSignedImages: true, Applications: map[string]EnvironmentApplicationConfig{
+				"jobs": {Values: []RuntimeArg{{Key: "worker.image", ArtifactImage: "worker"}}},
+			}},
+		},
+	}
+
+	errs := ValidateApplications(NewValidationErrors(""), config)
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("environments").
+				PutChild(NewValidationErrors("1").
+					Put("requireSignedImages", fmt.Errorf("application 'website' deploys artifact 'client', which isn't signed")).
+					Put("requireSignedImages", fmt.Errorf("application 'jobs' deploys artifact 'worker', which isn't signed")))),
+		errs,
+	)
+
+	config.Applications[0].RequireSignedImages = true
+	errs = ValidateApplications(NewValidationErrors(""), config)
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("applications").
+				PutChild(NewValidationErrors("website").
+					Put("requireSignedImages", fmt.Errorf("artifact 'client' isn't signed")))).
+			PutChild(NewValidationErrors("environments").
+				PutChild(NewValidationErrors("1").
+					Put("requireSignedImages", fmt.Errorf("application 'jobs' deploys artifact 'worker', which isn't signed")))),
+		errs,
+	)
+
+	errs = ValidateArtifacts(NewValidationErrors(""), config)
+	assert.Equal(t,
+		NewValidationErrors("").
+			PutChild(NewValidationErrors("artifacts").
+				PutChild(NewValidationErrors("pkg").
+					PutChild(NewValidationErrors("docker").
+						Put("sign", fmt.Errorf("sbom, provenance and sign only apply to app artifacts, since lib artifacts aren't pushed"))))),
+		errs,
+	)
+}
+
+func TestKeylessSigningUnsupportedTarget(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	config.Artifacts[0].Docker.Sign = SignOptions{Keyless: true, Identity: "^https://github.com/itura/fun/"}
+
+	_, err = ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetCircleci)
+
+	assert.EqualError(t, err, "keyless signing is not supported for target 'circleci'")
+}
@@ -0,0 +1,138 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TerraformPlan is the part of `terraform show -json` output describing resource changes.
+type TerraformPlan struct {
+	ResourceChanges []TerraformResourceChange `json:"resource_changes"`
+}
+
+type TerraformResourceChange struct {
+	Address string `json:"address"`
+	Change  struct {
+		Actions []string `json:"actions"`
+	} `json:"change"`
+}
+
+// PlanSummary lists the addresses of resources by the action a plan takes on them.
+type PlanSummary struct {
+	Create  []string
+	Update  []string
+	Replace []string
+	Delete  []string
+}
+
+func ParsePlanSummary(output string) (PlanSummary, error) {
+	var plan TerraformPlan
+	err := json.Unmarshal([]byte(output), &plan)
+	if err != nil {
+		return PlanSummary{}, fmt.Errorf("invalid terraform plan: %w", err)
+	}
+
+	summary := PlanSummary{}
+	for _, resource := range plan.ResourceChanges {
+		actions := strings.Join(resource.Change.Actions, ",")
+		switch actions {
+		case "create":
+			summary.Create = append(summary.Create, resource.Address)
+		case "update":
+			summary.Update = append(summary.Update, resource.Address)
+		case "delete,create", "create,delete":
+			summary.Replace = append(summary.Replace, resource.Address)
+		case "delete":
+			summary.Delete = append(summary.Delete, resource.Address)
+		}
+	}
+	return summary, nil
+}
+
+func (s PlanSummary) IsEmpty() bool {
+	return len(s.Create) == 0 && len(s.Update) == 0 && len(s.Replace) == 0 && len(s.Delete) == 0
+}
+
+// Destroys counts resources which are deleted, including those replaced.
+func (s PlanSummary) Destroys() int {
+	return len(s.Replace) + len(s.Delete)
+}
+
+func (s PlanSummary) Markdown(id string) string {
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("### Terraform plan for %s\n\n", id))
+	builder.WriteString("| create | update | replace | delete |\n")
+	builder.WriteString("| --- | --- | --- | --- |\n")
+	builder.WriteString(fmt.Sprintf("| %d | %d | %d | %d |\n", len(s.Create), len(s.Update), len(s.Replace), len(s.Delete)))
+	if !s.IsEmpty() {
+		builder.WriteString("\n")
+	}
+	for _, group := range []struct {
+		action    string
+		addresses []string
+	}{
+		{"create", s.Create},
+		{"update", s.Update},
+		{"replace", s.Replace},
+		{"delete", s.Delete},
+	} {
+		for _, address := range group.addresses {
+			builder.WriteString(fmt.Sprintf("- %s `%s`\n", group.action, address))
+		}
+	}
+	return builder.String()
+}
+
+// terraformPlanSummaryVar holds the markdown summary of a plan, for the command which writes it.
+const terraformPlanSummaryVar = "TERRAFORM_PLAN_SUMMARY"
+
+// PlanCheck summarizes the saved plan of a Terraform application, and enforces drift detection and the destroy policy.
+type PlanCheck struct {
+	ApplicationId string
+	// Drift fails when the plan has any changes
+	Drift bool
+	// PreventDestroy fails when the plan deletes or replaces resources
+	PreventDestroy bool
+}
+
+func (c PlanCheck) Summarize(output string) (PlanSummary, error) {
+	if strings.TrimSpace(output) == "" {
+		return PlanSummary{}, fmt.Errorf("no plan to check for %s", c.ApplicationId)
+	}
+	return ParsePlanSummary(output)
+}
+
+func (c PlanCheck) Check(output string) error {
+	summary, err := c.Summarize(output)
+	if err != nil {
+		return err
+	}
+	return c.Evaluate(summary)
+}
+
+// Vars returns the markdown summary of the plan, for WritePlanSummary to print.
+func (c PlanCheck) Vars(output string) map[string]string {
+	summary, err := c.Summarize(output)
+	if err != nil {
+		return nil
+	}
+	return map[string]string{terraformPlanSummaryVar: summary.Markdown(c.ApplicationId)}
+}
+
+// WritePlanSummary prints the summary a PlanCheck read, and adds it to the GitHub Actions job summary when there is one.
+func WritePlanSummary() Command {
+	return NewCommand("sh", "-c", `printf '%s\n' "$1" | tee -a "${GITHUB_STEP_SUMMARY:-/dev/null}"`,
+		"plan-summary", "$"+terraformPlanSummaryVar)
+}
+
+func (c PlanCheck) Evaluate(summary PlanSummary) error {
+	if c.Drift && !summary.IsEmpty() {
+		return fmt.Errorf("drift detected in %s: %d to create, %d to update, %d to replace, %d to delete",
+			c.ApplicationId, len(summary.Create), len(summary.Update), len(summary.Replace), len(summary.Delete))
+	}
+	if c.PreventDestroy && summary.Destroys() > 0 {
+		return fmt.Errorf("%s would destroy %d resources, pass --allow-destroy to apply", c.ApplicationId, summary.Destroys())
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+const showJson = `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "google_storage_bucket.assets", "change": {"actions": ["create"]}},
+    {"address": "google_sql_database.db", "change": {"actions": ["update"]}},
+    {"address": "google_sql_user.admin", "change": {"actions": ["delete", "create"]}},
+    {"address": "google_storage_bucket.old", "change": {"actions": ["delete"]}},
+    {"address": "google_project.main", "change": {"actions": ["no-op"]}}
+  ]
+}`
+
+func TestParsePlanSummary(t *testing.T) {
+	summary, err := ParsePlanSummary(showJson)
+
+	assert.Nil(t, err)
+	assert.Equal(t, PlanSummary{
+		Create:  []string{"google_storage_bucket.assets"},
+		Update:  []string{"google_sql_database.db"},
+		Replace: []string{"google_sql_user.admin"},
+		Delete:  []string{"google_storage_bucket.old"},
+	}, summary)
+	assert.Equal(t, 2, summary.Destroys())
+	assert.Equal(t, "### Terraform plan for infra\n\n"+
+		"| create | update | replace | delete |\n"+
+		"| --- | --- | --- | --- |\n"+
+		"| 1 | 1 | 1 | 1 |\n\n"+
+		"- create `google_storage_bucket.assets`\n"+
+		"- update `google_sql_database.db`\n"+
+		"- replace `google_sql_user.admin`\n"+
+		"- delete `google_storage_bucket.old`\n",
+		summary.Markdown("infra"))
+
+	_, err = ParsePlanSummary("Error: no plan")
+	assert.NotNil(t, err)
+}
+
+func TestPlanCheck(t *testing.T) {
+	summary, err := ParsePlanSummary(showJson)
+	assert.Nil(t, err)
+
+	assert.Nil(t, PlanCheck{ApplicationId: "infra"}.Evaluate(summary))
+	assert.Nil(t, PlanCheck{ApplicationId: "infra", Drift: true}.Evaluate(PlanSummary{}))
+	assert.EqualError(t, PlanCheck{ApplicationId: "infra", Drift: true}.Evaluate(summary),
+		"drift detected in infra: 1 to create, 1 to update, 1 to replace, 1 to delete")
+	assert.EqualError(t, PlanCheck{ApplicationId: "infra", PreventDestroy: true}.Evaluate(summary),
+		"infra would destroy 2 resources, pass --allow-destroy to apply")
+	assert.Nil(t, PlanCheck{ApplicationId: "infra", PreventDestroy: true}.Evaluate(PlanSummary{Create: []string{"a"}}))
+}
+
+func TestPlanCheckWritesSummaryBeforeFailing(t *testing.T) {
+	check := PlanCheck{ApplicationId: "infra", Drift: true}
+	show := NewCommand("terraform", "show", "-json", "plan.out")
+	sideEffects := NewSideEffects(show.SetVars(check), WritePlanSummary(), show.SetCheck(check))
+	summary, _ := ParsePlanSummary(showJson)
+	runner := new(mocks.CommandRunner)
+	runner.On("Output", "terraform", "show", "-json", "plan.out").Return(showJson, nil).Twice()
+	runner.On("Run", "sh", "-c", `printf '%s\n' "${1}" | tee -a "${GITHUB_STEP_SUMMARY:-/dev/null}"`,
+		"plan-summary", summary.Markdown("infra")).Return(nil).Once()
+
+	err := sideEffects.Apply(runner)
+
+	assert.EqualError(t, err, "drift detected in infra: 1 to create, 1 to update, 1 to replace, 1 to delete")
+	runner.AssertExpectations(t)
+	assert.Nil(t, check.Vars(""))
+	assert.EqualError(t, check.Check(""), "no plan to check for infra")
+}
+
+func TestDriftScheduleTriggers(t *testing.T) {
+	triggers := NewGitHubActionsTriggers(TriggersConfig{
+		PullRequest: &PullRequestTriggerConfig{},
+		Schedule:    ScheduleTriggerConfigs{{Cron: "0 6 * * *", Drift: true}},
+	})
+
+	assert.Equal(t, []string{
+		"--verify=${{ github.event_name == 'pull_request' }}",
+		"--drift=${{ github.event_name == 'schedule' }}",
+	}, triggers.DeployArgs())
+	assert.Equal(t, []string{"--verify=${{ github.event_name == 'pull_request' }}"}, triggers.BuildArgs())
+
+	jobs := TerraformJobs("deploy-infra", NewGitHubActionsJob("Deploy infra").AddSteps(GitHubActionsStep{Run: "deploy"}),
+		"tf/main", TerraformOptions{ApplyEnvironment: "infra"}, triggers)
+	assert.Equal(t, "github.event_name != 'pull_request' && github.event_name != 'schedule'", jobs["deploy-infra"].If)
+}
+
+func TestDownstreamJobsRunAfterSkippedApply(t *testing.T) {
+	config, err := readFile("test_fixtures/valid_pipeline_config.yaml")
+	assert.Nil(t, err)
+	config.Triggers = TriggersConfig{PullRequest: &PullRequestTriggerConfig{}}
+	config.Applications[0].Terraform.ApplyEnvironment = "infra"
+
+	writer, err := ParseConfigForTarget(config, "test_fixtures/valid_pipeline_config.yaml", "./cmd/build", ciTargetGithub)
+	assert.Nil(t, err)
+	workflow := writer.(GitHubActionsWorkflow)
+
+	assert.Equal(t, "github.event_name != 'pull_request'", workflow.Jobs["deploy-infra"].If)
+	assert.Equal(t, "", workflow.Jobs["plan-infra"].If)
+	assert.Equal(t, []string{"deploy-infra"}, workflow.Jobs["deploy-db"].Needs)
+	assert.Equal(t, "${{ !failure() && !cancelled() }}", workflow.Jobs["deploy-db"].If)
+	assert.Equal(t, "${{ !failure() && !cancelled() }}", workflow.Jobs["deploy-website"].If)
+	assert.Equal(t, "", workflow.Jobs["build-api"].If)
+}
@@ -52,6 +52,7 @@ func ValidPipelineConfig(builder TestBuilder) PipelineConfig {
 	appInfra := TerraformConfig(builder, "infra", "tf/main")
 	appDatabase := PostgresHelmChart(builder, appInfra.Id)
 	appWebsite := WebsiteHelmChart(builder, artifactClient.Id, artifactApi.Id, appInfra.Id, appDatabase.Id)
+	appWebsite.Artifacts = []string{artifactClient.Id, artifactApi.Id}
 	return SuccessfulParse(
 		"My Build",
 		map[string]Artifact{
@@ -81,8 +82,8 @@ func PostgresHelmChart(builder TestBuilder, upstreams ...string) Application {
 	return builder.Application("db", "helm/db", applicationTypeHelm, upstreams...).
 		SetNamespace("db-namespace").
 		AddRuntimeArg("postgresql.dbName", "my-db").
-		AddRuntimeArg("postgresql.auth.password", "${{ steps.secrets-gcp-project.outputs.pg-password }}").
-		AddRuntimeArg("postgresql.auth.username", "${{ secrets.pg-username }}").
+		AddSecretArg("postgresql.auth.password", "pg-password", "${{ steps.secrets-gcp-project.outputs.pg-password }}").
+		AddSecretArg("postgresql.auth.username", "pg-username", "${{ secrets.pg-username }}").
 		AddStep(
 			CheckoutRepoStep(),
 			SetupGoStep(),
@@ -95,10 +96,10 @@ func WebsiteHelmChart(builder TestBuilder, upstreams ...string) Application {
 	return builder.Application("website", "helm/website", applicationTypeHelm, upstreams...).
 		SetNamespace("website-namespace").
 		AddRuntimeArg("app-name", "website").
-		AddRuntimeArg("client.secrets.clientId", "${{ steps.secrets-gcp-project.outputs.client-id }}").
-		AddRuntimeArg("client.secrets.clientSecret", "${{ steps.secrets-gcp-project.outputs.client-secret }}").
-		AddRuntimeArg("client.secrets.nextAuthUrl", "${{ steps.secrets-gcp-project.outputs.next-auth-url }}").
-		AddRuntimeArg("client.secrets.nextAuthSecret", "${{ steps.secrets-gcp-project.outputs.next-auth-secret }}").
+		AddSecretArg("client.secrets.clientId", "client-id", "${{ steps.secrets-gcp-project.outputs.client-id }}").
+		AddSecretArg("client.secrets.clientSecret", "client-secret", "${{ steps.secrets-gcp-project.outputs.client-secret }}").
+		AddSecretArg("client.secrets.nextAuthUrl", "next-auth-url", "${{ steps.secrets-gcp-project.outputs.next-auth-url }}").
+		AddSecretArg("client.secrets.nextAuthSecret", "next-auth-secret", "${{ steps.secrets-gcp-project.outputs.next-auth-secret }}").
 		AddStep(
 			CheckoutRepoStep(),
 			SetupGoStep(),
@@ -194,8 +195,10 @@ func TestArgs(configPath string) ActionArgs {
 			ConfigPath: configPath,
 			Self:       false,
 		},
-		Id:         "test",
-		CurrentSha: "currentSha",
-		Force:      false,
+		PipelineArgs: PipelineArgs{
+			CurrentSha: "currentSha",
+			Force:      false,
+		},
+		Id: "test",
 	}
 }
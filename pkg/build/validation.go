@@ -164,6 +164,14 @@ func (m InvalidCloudProvider) Error() string {
 	return m.Message
 }
 
+func InvalidInputType(t string) error {
+	return fmt.Errorf("invalid input type '%s', must be one of %s", t, strings.Join(workflowDispatchInputTypes, ", "))
+}
+
+func DuplicateId(id string) error {
+	return fmt.Errorf("duplicate id '%s'", id)
+}
+
 var (
 	eMissingRequiredField = fun.Error("required")
 )
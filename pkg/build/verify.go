@@ -0,0 +1,249 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itura/fun/pkg/fun"
+)
+
+const (
+	defaultEndpointStatus   = http.StatusOK
+	defaultEndpointRetries  = 5
+	defaultEndpointInterval = 5 * time.Second
+	defaultEndpointTimeout  = 10 * time.Second
+)
+
+// VerifyOptions check an application once it's deployed, failing the deploy when a check fails.
+// Checks run in order: rollouts, then the HTTP endpoint, then the command, then the container.
+// The command and container may interpolate {{ sha }} and {{ environment }}.
+type VerifyOptions struct {
+	// Rollouts are resources of the form <kind>/<name>, waited on with kubectl rollout status
+	Rollouts []string
+	// Timeout is a duration such as 5m, passed to kubectl rollout status
+	Timeout string
+	Http    EndpointCheck
+	// Command is run with sh -c
+	Command   string
+	Container VerifyContainer
+	// Rollback rolls the application back when a check fails
+	Rollback bool
+}
+
+// VerifyContainer is an image run to completion with docker run.
+type VerifyContainer struct {
+	Image string
+	Args  []string
+}
+
+func (v VerifyOptions) Validate(key string, applicationType ApplicationType) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if v.Timeout != "" {
+		if _, err := time.ParseDuration(v.Timeout); err != nil {
+			errs = errs.Put("timeout", fmt.Errorf("'%s' is not a duration", v.Timeout))
+		}
+	}
+	errs = validateRollouts(errs, v.Rollouts)
+	if len(v.Rollouts) > 0 && applicationType == applicationTypeTerraform {
+		errs = errs.Put("rollouts", fmt.Errorf("only applies to helm, kustomize and kubectl applications"))
+	}
+	if v.Container.Image == "" && len(v.Container.Args) > 0 {
+		errs = errs.Put("container", fmt.Errorf("args require an image"))
+	}
+	if v.Rollback && applicationType == applicationTypeTerraform {
+		errs = errs.Put("rollback", fmt.Errorf("terraform applications can't be rolled back automatically"))
+	}
+	return errs.PutChild(v.Http.Validate("http"))
+}
+
+// EndpointCheck requests a URL until it responds with the expected status and a body containing Body,
+// or it runs out of retries.
+type EndpointCheck struct {
+	Url string
+	// Status is the expected status code, 200 by default
+	Status  int
+	Body    string
+	Retries int
+	// Interval is a duration between attempts, 5s by default
+	Interval string
+	// Timeout is a duration limiting each request, 10s by default
+	Timeout string
+}
+
+func (e EndpointCheck) Validate(key string) ValidationErrors {
+	errs := NewValidationErrors(key)
+	if e == (EndpointCheck{}) {
+		return errs
+	}
+	if e.Url == "" {
+		errs = errs.Put("url", eMissingRequiredField)
+	}
+	if e.Status != 0 && (e.Status < 100 || e.Status > 599) {
+		errs = errs.Put("status", fmt.Errorf("%d is not an HTTP status", e.Status))
+	}
+	if e.Retries < 0 {
+		errs = errs.Put("retries", fmt.Errorf("must not be negative"))
+	}
+	if e.Interval != "" {
+		if _, err := time.ParseDuration(e.Interval); err != nil {
+			errs = errs.Put("interval", fmt.Errorf("'%s' is not a duration", e.Interval))
+		}
+	}
+	if e.Timeout != "" {
+		if _, err := time.ParseDuration(e.Timeout); err != nil {
+			errs = errs.Put("timeout", fmt.Errorf("'%s' is not a duration", e.Timeout))
+		}
+	}
+	return errs
+}
+
+// Run makes the request, retrying until the response is as expected.
+func (e EndpointCheck) Run() error {
+	client := fun.NewRestClient(e.Url).
+		SetClient(&http.Client{Timeout: e.duration(e.Timeout, defaultEndpointTimeout)}).
+		SetParams(fun.NewHttpParams())
+
+	err := e.check(client)
+	for attempt := 0; err != nil && attempt < e.retries(); attempt++ {
+		time.Sleep(e.duration(e.Interval, defaultEndpointInterval))
+		err = e.check(client)
+	}
+	return err
+}
+
+func (e EndpointCheck) check(client *fun.RestClient) error {
+	var body string
+	var status int
+	res, err := client.GetRaw(&body, "")
+	var remoteErr *fun.RemoteServiceError
+	switch {
+	case errors.As(err, &remoteErr):
+		status = remoteErr.Status
+		body = remoteErr.PayloadRaw
+	case err != nil:
+		return err
+	default:
+		status = res.StatusCode
+	}
+
+	if status != e.status() {
+		return fmt.Errorf("%s responded with status %d, expected %d", e.Url, status, e.status())
+	}
+	if !strings.Contains(body, e.Body) {
+		return fmt.Errorf("%s responded without '%s'", e.Url, e.Body)
+	}
+	return nil
+}
+
+func (e EndpointCheck) status() int {
+	if e.Status == 0 {
+		return defaultEndpointStatus
+	}
+	return e.Status
+}
+
+func (e EndpointCheck) retries() int {
+	if e.Retries == 0 {
+		return defaultEndpointRetries
+	}
+	return e.Retries
+}
+
+func (e EndpointCheck) duration(value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return duration
+}
+
+// args describes the check like a command's arguments, for dry runs.
+func (e EndpointCheck) args() []string {
+	args := []string{e.Url, "--expect-status", strconv.Itoa(e.status())}
+	if e.Body != "" {
+		args = append(args, "--expect-body", e.Body)
+	}
+	return args
+}
+
+// PostDeployChecks verifies the application once deployed. When rollback is enabled, a failing check first rolls the
+// application back, so the deploy still fails but doesn't leave the broken release serving.
+func (a Application) PostDeployChecks() []Command {
+	if a.Verify || a.Drift || a.Phase == terraformPhasePlan {
+		return nil
+	}
+
+	options := a.PostDeploy
+	var checks []Command
+	for _, resource := range options.Rollouts {
+		rollout := NewCommand("kubectl", "rollout", "status", resource)
+		if a.Namespace != "" {
+			rollout = rollout.Add("--namespace", a.Namespace)
+		}
+		if options.Timeout != "" {
+			rollout = rollout.Add("--timeout", options.Timeout)
+		}
+		checks = append(checks, rollout)
+	}
+	if options.Http.Url != "" {
+		check := options.Http
+		checks = append(checks, Command{Endpoint: &check})
+	}
+	if options.Command != "" {
+		checks = append(checks, NewCommand("sh", "-c", a.interpolate(options.Command)))
+	}
+	if options.Container.Image != "" {
+		checks = append(checks, NewCommand("docker", "run", "--rm", a.interpolate(options.Container.Image)).
+			Add(options.Container.Args...))
+	}
+
+	if !options.Rollback {
+		return checks
+	}
+	rollback := a.rollbackCommands()
+	for i := range checks {
+		checks[i] = checks[i].SetOnFailure(rollback...)
+	}
+	return checks
+}
+
+func (a Application) interpolate(value string) string {
+	return RuntimeArg{Value: value}.Interpolate(a.CurrentSha, a.Environment).Value
+}
+
+// rollbackCommands undo a deploy which failed its checks. Helm releases are rolled back to their previous revision,
+// and manifests' rollouts are undone.
+func (a Application) rollbackCommands() []Command {
+	if a.Type == applicationTypeHelm {
+		return NewHelm(a).Rollback().Commands
+	}
+
+	var commands []Command
+	var resources []string
+	for _, resource := range append(append([]string{}, a.Manifests.Rollouts...), a.PostDeploy.Rollouts...) {
+		if fun.Contains(resources, resource) {
+			continue
+		}
+		resources = append(resources, resource)
+		undo := NewCommand("kubectl", "rollout", "undo", resource)
+		if a.Namespace != "" {
+			undo = undo.Add("--namespace", a.Namespace)
+		}
+		commands = append(commands, undo)
+	}
+	return commands
+}
+
+func validateRollouts(errs ValidationErrors, rollouts []string) ValidationErrors {
+	for _, rollout := range rollouts {
+		kind, name, found := strings.Cut(rollout, "/")
+		if !found || kind == "" || name == "" {
+			errs = errs.Put("rollouts", fmt.Errorf("'%s' must be of the form <kind>/<name>", rollout))
+		}
+	}
+	return errs
+}
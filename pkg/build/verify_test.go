@@ -0,0 +1,216 @@
+package build
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/itura/fun/pkg/build/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointCheck(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/warming":
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"status": "ok"}`))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/maintenance":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("down for maintenance"))
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+			_, _ = w.Write([]byte(`{"error": "gone"}`))
+		default:
+			_, _ = w.Write([]byte("degraded"))
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name     string
+		check    EndpointCheck
+		expected string
+	}{
+		{
+			name:  "RetriesUntilHealthy",
+			check: EndpointCheck{Url: server.URL + "/warming", Body: `"ok"`, Interval: "1ms"},
+		},
+		{
+			name:  "ExpectedStatus",
+			check: EndpointCheck{Url: server.URL + "/missing", Status: http.StatusNotFound},
+		},
+		{
+			name:  "ExpectedStatusWithBody",
+			check: EndpointCheck{Url: server.URL + "/maintenance", Status: http.StatusServiceUnavailable, Body: "maintenance"},
+		},
+		{
+			name:  "ExpectedStatusWithJsonBody",
+			check: EndpointCheck{Url: server.URL + "/gone", Status: http.StatusGone, Body: `"error": "gone"`},
+		},
+		{
+			name:     "UnexpectedStatus",
+			check:    EndpointCheck{Url: server.URL + "/missing", Retries: 1, Interval: "1ms"},
+			expected: fmt.Sprintf("%s/missing responded with status 404, expected 200", server.URL),
+		},
+		{
+			name:     "UnexpectedBody",
+			check:    EndpointCheck{Url: server.URL + "/healthz", Body: "ok", Retries: 1, Interval: "1ms"},
+			expected: fmt.Sprintf("%s/healthz responded without 'ok'", server.URL),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.check.Run()
+			if tc.expected == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestPostDeployChecks(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.PostDeploy = VerifyOptions{
+		Rollouts:  []string{"deployment/api"},
+		Timeout:   "2m",
+		Http:      EndpointCheck{Url: "https://api.example.com/healthz", Body: "ok"},
+		Command:   "./scripts/smoke.sh {{ sha }}",
+		Container: VerifyContainer{Image: "us-central1-docker.pkg.dev/gcp-project/repo-name/e2e:{{ sha }}", Args: []string{"--suite", "smoke"}},
+		Rollback:  true,
+	}
+
+	rollback := NewCommand("helm", "rollback", "api-chart", "--namespace", "api", "--wait")
+	assert.Equal(t, []Command{
+		NewCommand("kubectl", "rollout", "status", "deployment/api", "--namespace", "api", "--timeout", "2m").
+			SetOnFailure(rollback),
+		Command{Endpoint: &application.PostDeploy.Http, OnFailure: []Command{rollback}},
+		NewCommand("sh", "-c", "./scripts/smoke.sh currentSha").SetOnFailure(rollback),
+		NewCommand("docker", "run", "--rm", "us-central1-docker.pkg.dev/gcp-project/repo-name/e2e:currentSha", "--suite", "smoke").
+			SetOnFailure(rollback),
+	}, application.PostDeployChecks())
+
+	application.Verify = true
+	assert.Empty(t, application.PostDeployChecks())
+}
+
+func TestPostDeployChecksRollBackOnFailure(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api", "k8s/api", applicationTypeKustomize).
+		SetNamespace("api")
+	application.Manifests.Rollouts = []string{"deployment/api"}
+	application.PostDeploy = VerifyOptions{Command: "./scripts/smoke.sh", Rollback: true}
+	pipeline := NewPipeline(
+		SuccessfulParse("My Build", map[string]Artifact{}, map[string]Application{"api": application}, NewDependencies()),
+		"pipeline.yaml",
+		"github.com/itura/fun/cmd/build@v0.1.19",
+	)
+
+	sideEffects, err := pipeline.DeployApplication("api")
+	assert.Nil(t, err)
+
+	runner := new(mocks.CommandRunner)
+	runner.On("Run", "kubectl", "apply", "--server-side", "-k", "k8s/api", "--namespace", "api").Return(nil)
+	runner.On("Run", "kubectl", "rollout", "status", "deployment/api", "--namespace", "api").Return(nil)
+	runner.On("Run", "sh", "-c", "./scripts/smoke.sh").Return(fmt.Errorf("exit status 1"))
+	runner.On("Run", "kubectl", "rollout", "undo", "deployment/api", "--namespace", "api").Return(nil)
+
+	err = sideEffects.Apply(runner)
+	assert.EqualError(t, err, "exit status 1")
+	runner.AssertExpectations(t)
+}
+
+func TestPlanRecordsPostDeployChecks(t *testing.T) {
+	builder := NewTestBuilder()
+	application := builder.Application("api-chart", "helm/api", applicationTypeHelm).
+		SetNamespace("api")
+	application.PostDeploy = VerifyOptions{
+		Http:     EndpointCheck{Url: "https://api.example.com/healthz"},
+		Command:  "./scripts/smoke.sh",
+		Rollback: true,
+	}
+	pipeline := NewPipeline(
+		SuccessfulParse("My Build", map[string]Artifact{}, map[string]Application{"api-chart": application}, NewDependencies()),
+		"pipeline.yaml",
+		"github.com/itura/fun/cmd/build@v0.1.19",
+	)
+
+	plan, err := pipeline.Plan("api-chart")
+
+	assert.Nil(t, err)
+	commands := plan.Steps[0].Commands
+	assert.Equal(t, RecordedCommand{
+		Name:      "GET",
+		Arguments: []string{"https://api.example.com/healthz", "--expect-status", "200"},
+	}, commands[len(commands)-2])
+	assert.Equal(t, []RecordedCommand{
+		{Name: "helm", Arguments: []string{"rollback", "api-chart", "--namespace", "api", "--wait"}},
+	}, plan.Steps[0].OnFailure)
+}
+
+func TestVerifyOptionsValidation(t *testing.T) {
+	cases := []struct {
+		name            string
+		verify          VerifyOptions
+		applicationType ApplicationType
+		expected        ValidationErrors
+	}{
+		{
+			name: "Valid",
+			verify: VerifyOptions{
+				Rollouts: []string{"deployment/api"},
+				Timeout:  "2m",
+				Http:     EndpointCheck{Url: "https://api.example.com/healthz", Status: 204, Interval: "10s"},
+				Rollback: true,
+			},
+			applicationType: applicationTypeHelm,
+			expected:        NewValidationErrors("verify"),
+		},
+		{
+			name: "Invalid",
+			verify: VerifyOptions{
+				Rollouts:  []string{"api"},
+				Timeout:   "soon",
+				Http:      EndpointCheck{Status: 700, Retries: -1, Timeout: "later"},
+				Container: VerifyContainer{Args: []string{"--suite", "smoke"}},
+			},
+			applicationType: applicationTypeHelm,
+			expected: NewValidationErrors("verify").
+				Put("timeout", fmt.Errorf("'soon' is not a duration")).
+				Put("rollouts", fmt.Errorf("'api' must be of the form <kind>/<name>")).
+				Put("container", fmt.Errorf("args require an image")).
+				PutChild(NewValidationErrors("http").
+					Put("url", eMissingRequiredField).
+					Put("status", fmt.Errorf("700 is not an HTTP status")).
+					Put("retries", fmt.Errorf("must not be negative")).
+					Put("timeout", fmt.Errorf("'later' is not a duration"))),
+		},
+		{
+			name:            "Terraform",
+			verify:          VerifyOptions{Rollouts: []string{"deployment/api"}, Rollback: true},
+			applicationType: applicationTypeTerraform,
+			expected: NewValidationErrors("verify").
+				Put("rollouts", fmt.Errorf("only applies to helm, kustomize and kubectl applications")).
+				Put("rollback", fmt.Errorf("terraform applications can't be rolled back automatically")),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.verify.Validate("verify", tc.applicationType))
+		})
+	}
+}
@@ -97,6 +97,15 @@ func (c *RestClient) Get(payload interface{}, path string, params ...*HttpParams
 	return c.send(payload, http.MethodGet, path, nil, params...)
 }
 
+// GetRaw reads the response body into body as it is, rather than decoding it as JSON.
+func (c *RestClient) GetRaw(body *string, path string, params ...*HttpParams) (*http.Response, error) {
+	return c.do(http.MethodGet, path, nil, func(res *http.Response) error {
+		bodyBytes, err := io.ReadAll(res.Body)
+		*body = string(bodyBytes)
+		return err
+	}, params...)
+}
+
 func (c *RestClient) Delete(payload interface{}, path string, params ...*HttpParams) (*http.Response, error) {
 	return c.send(payload, http.MethodDelete, path, nil, params...)
 }
@@ -122,6 +131,12 @@ func (c *RestClient) sendBody(payload interface{}, method string, path string, b
 }
 
 func (c *RestClient) send(payload interface{}, method string, path string, body io.Reader, params ...*HttpParams) (*http.Response, error) {
+	return c.do(method, path, body, func(res *http.Response) error {
+		return ParseBody(payload, res)
+	}, params...)
+}
+
+func (c *RestClient) do(method string, path string, body io.Reader, parse func(res *http.Response) error, params ...*HttpParams) (*http.Response, error) {
 	url := c.url(path)
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
@@ -148,15 +163,14 @@ func (c *RestClient) send(payload interface{}, method string, path string, body
 		}
 
 		if res.ContentLength != 0 {
-			var payload JSON
-			err := ParseBody(&payload, res)
+			// the body is read whole first, so that it's kept as it is whether or not it's JSON
+			bodyBytes, err := io.ReadAll(res.Body)
 			if err != nil {
-				bodyBytes, err := io.ReadAll(res.Body)
-				if err != nil {
-					return res, fmt.Errorf("%v caught during %w", err, e)
-				}
-				e.PayloadRaw = string(bodyBytes)
-			} else {
+				return res, fmt.Errorf("%v caught during %w", err, e)
+			}
+			e.PayloadRaw = string(bodyBytes)
+			var payload JSON
+			if json.Unmarshal(bodyBytes, &payload) == nil {
 				e.Payload = payload
 			}
 		}
@@ -165,7 +179,7 @@ func (c *RestClient) send(payload interface{}, method string, path string, body
 	}
 
 	if res.ContentLength != 0 {
-		return res, ParseBody(payload, res)
+		return res, parse(res)
 	}
 
 	return res, nil
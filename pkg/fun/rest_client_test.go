@@ -2,6 +2,8 @@ package fun
 
 import (
 	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -61,3 +63,17 @@ func (s *ClientSuite) TestHttpParamsQueryParams() {
 		),
 	)
 }
+
+func (s *ClientSuite) TestGetRawBody() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var body string
+	res, err := NewRestClient(server.URL).GetRaw(&body, "/healthz")
+
+	s.Nil(err)
+	s.Equal(http.StatusOK, res.StatusCode)
+	s.Equal("ok", body)
+}